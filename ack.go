@@ -0,0 +1,91 @@
+package hipchat
+
+import (
+	"context"
+	"github.com/pyalex/hipchat/xmpp"
+)
+
+// awaitAck registers a pending acknowledgement under a new id, sends the
+// stanza with send and waits until the server either echoes the stanza back
+// or rejects it.
+func (c *Client) awaitAck(ctx context.Context, send func(id string) error) error {
+	id := xmpp.ID()
+	ack := make(chan error, 1)
+
+	c.acksMutex.Lock()
+	c.acks[id] = ack
+	c.acksMutex.Unlock()
+
+	defer func() {
+		c.acksMutex.Lock()
+		delete(c.acks, id)
+		c.acksMutex.Unlock()
+	}()
+
+	if err := send(id); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-ack:
+		return err
+	case <-ctx.Done():
+		return contextError(ctx)
+	case <-c.done:
+		return ErrNotConnected
+	}
+}
+
+// resolveAck completes the pending acknowledgement for the stanza id, with
+// err if the stanza was rejected, and reports whether there was one.
+func (c *Client) resolveAck(id string, err error) bool {
+	if id == "" {
+		return false
+	}
+
+	c.acksMutex.Lock()
+	ack, ok := c.acks[id]
+	delete(c.acks, id)
+	c.acksMutex.Unlock()
+
+	if ok {
+		ack <- err
+	}
+	return ok
+}
+
+// reportError delivers a stanza error to the caller waiting for the stanza
+// or, if there is none, on the Errors channel.
+func (c *Client) reportError(id string, err *StanzaError) {
+	if isRateLimit(err) {
+		c.rateLimited(&RateLimited{
+			From:       err.From,
+			Condition:  err.Condition,
+			Text:       err.Text,
+			RetryAfter: RateLimitBackoff,
+		})
+	}
+
+	if c.resolveAck(id, err) {
+		return
+	}
+
+	c.deliverMutex.RLock()
+	defer c.deliverMutex.RUnlock()
+
+	if c.Closed() {
+		return
+	}
+
+	select {
+	case c.receivedErrors <- err:
+	default:
+		c.logger.Warn("dropped stanza error", "event", "stanza_error", "from", err.From, "id", id, "error", err)
+	}
+}
+
+// Errors returns a read-only channel of the stanza errors sent by the server
+// that no caller was waiting for, e.g. a rejected message sent with Say.
+func (c *Client) Errors() <-chan *StanzaError {
+	return c.receivedErrors
+}
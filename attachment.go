@@ -0,0 +1,161 @@
+package hipchat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pyalex/hipchat/xmpp"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+// ValidationTimeout is how long the validators of an attachment may take
+// before it is rejected, unless the Config says otherwise.
+var ValidationTimeout = 5 * time.Second
+
+// describeAttachments adds the description of the shared file HipChat sent
+// with m, if any, to the attachment with the same URL, or attaches the file if
+// the HTML body did not.
+func (c *Client) describeAttachments(m *Message, f *xmpp.ExtensionFile) {
+	if f == nil || f.URL == "" {
+		return
+	}
+
+	var a *xmpp.Attachment
+	for i := range m.Attachments {
+		if m.Attachments[i].ImageURL == f.URL {
+			a = &m.Attachments[i]
+			break
+		}
+	}
+	if a == nil {
+		m.Attachments = append(m.Attachments, xmpp.Attachment{Kind: xmpp.KindFile, ImageURL: f.URL, ImageFilename: f.Name, ThumbnailURL: f.ThumbURL})
+		a = &m.Attachments[len(m.Attachments)-1]
+	}
+
+	a.Size = f.Size
+	a.MIMEType = f.MIMEType
+	if a.MIMEType == "" {
+		a.MIMEType = mime.TypeByExtension(path.Ext(f.Name))
+	}
+	a.Uploader = f.UploadedBy
+	if f.Width > 0 && f.Height > 0 {
+		a.Width, a.Height = f.Width, f.Height
+	}
+	if f.UploadDate != "" {
+		if uploaded, err := parseStamp(f.UploadDate); err == nil {
+			a.Uploaded = uploaded
+		}
+	}
+}
+
+// An AttachmentValidator checks an attachment of an incoming message before
+// the message is delivered, e.g. against size limits or with a virus scanner,
+// and returns why the attachment is rejected, or nil. The attachments of a
+// message are checked concurrently while incoming stanzas wait, so ctx ends
+// after the validation timeout; an attachment whose validators have not
+// returned by then is rejected. Validators must not modify m.
+type AttachmentValidator func(ctx context.Context, m *Message, a *xmpp.Attachment) error
+
+// A RejectedAttachment is an attachment removed from a message by an
+// AttachmentValidator, along with the reason it returned.
+type RejectedAttachment struct {
+	Attachment xmpp.Attachment
+	Reason     error
+}
+
+// WithAttachmentValidator adds a validator the attachments of incoming
+// messages must pass. Rejected attachments are moved from Attachments to
+// RejectedAttachments.
+func WithAttachmentValidator(v AttachmentValidator) Option {
+	return func(c *Client) error {
+		if v == nil {
+			return errors.New("attachment validator must not be nil")
+		}
+		c.validators = append(c.validators, v)
+		return nil
+	}
+}
+
+// MaxAttachmentSize returns a validator rejecting attachments larger than
+// size bytes. Attachments of unknown size pass.
+func MaxAttachmentSize(size int64) AttachmentValidator {
+	return func(ctx context.Context, m *Message, a *xmpp.Attachment) error {
+		if a.Size > size {
+			return fmt.Errorf("%s is larger than %d bytes", a.ImageFilename, size)
+		}
+		return nil
+	}
+}
+
+// AllowMIMETypes returns a validator rejecting attachments whose MIME type is
+// not one of types. A type ending in "/*", e.g. "image/*", allows all
+// subtypes.
+func AllowMIMETypes(types ...string) AttachmentValidator {
+	return func(ctx context.Context, m *Message, a *xmpp.Attachment) error {
+		t, _, _ := strings.Cut(a.MIMEType, ";")
+		for _, allowed := range types {
+			if t == allowed || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(t, strings.TrimSuffix(allowed, "*")) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s has the disallowed type %q", a.ImageFilename, a.MIMEType)
+	}
+}
+
+// validateAttachments removes the attachments of m rejected by a validator.
+// The validators get a copy of m, so the ones still running after the
+// validation timeout do not race with its delivery.
+func (c *Client) validateAttachments(ctx context.Context, m *Message) {
+	if len(c.validators) == 0 || len(m.Attachments) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeouts.Validation)
+	defer cancel()
+
+	validated := *m
+	results := make([]chan error, len(m.Attachments))
+	for i := range m.Attachments {
+		results[i] = make(chan error, 1)
+		a := m.Attachments[i]
+		go func(result chan<- error) {
+			result <- c.validate(ctx, &validated, &a)
+		}(results[i])
+	}
+
+	kept := make([]xmpp.Attachment, 0, len(m.Attachments))
+	for i, a := range m.Attachments {
+		var err error
+		select {
+		case err = <-results[i]:
+		case <-ctx.Done():
+			err = fmt.Errorf("validating %s: %w", a.ImageFilename, contextError(ctx))
+		}
+		if err != nil {
+			c.logger.Info("rejected attachment", "event", "attachment", "id", m.Mid, "url", a.ImageURL, "reason", err)
+			m.RejectedAttachments = append(m.RejectedAttachments, RejectedAttachment{Attachment: a, Reason: err})
+			continue
+		}
+		kept = append(kept, a)
+	}
+	m.Attachments = kept
+}
+
+// validate runs the validators on a until one rejects it. A panicking
+// validator rejects the attachment.
+func (c *Client) validate(ctx context.Context, m *Message, a *xmpp.Attachment) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("attachment validator panicked: %v", x)
+		}
+	}()
+	for _, v := range c.validators {
+		if err := v(ctx, m, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
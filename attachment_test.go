@@ -0,0 +1,114 @@
+package hipchat
+
+import (
+	"context"
+	"errors"
+	"github.com/pyalex/hipchat/xmpp"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validatingClient returns a client with the validators and no connection.
+func validatingClient(timeout time.Duration, validators ...AttachmentValidator) *Client {
+	return &Client{
+		config:     Config{Timeouts: Timeouts{Validation: timeout}}.withDefaults(),
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		validators: validators,
+	}
+}
+
+func attachmentMessage() *Message {
+	return &Message{Mid: "1", Attachments: []xmpp.Attachment{
+		{ImageURL: "https://example.com/small.png", ImageFilename: "small.png", Size: 10, MIMEType: "image/png"},
+		{ImageURL: "https://example.com/big.zip", ImageFilename: "big.zip", Size: 1000, MIMEType: "application/zip"},
+	}}
+}
+
+func TestValidateAttachments(t *testing.T) {
+	tests := []struct {
+		name      string
+		validator AttachmentValidator
+		kept      []string
+	}{
+		{"size", MaxAttachmentSize(100), []string{"small.png"}},
+		{"type", AllowMIMETypes("image/*"), []string{"small.png"}},
+		{"exact type", AllowMIMETypes("application/zip"), []string{"big.zip"}},
+		{"panic", func(ctx context.Context, m *Message, a *xmpp.Attachment) error {
+			if a.Size > 100 {
+				panic("scanner crashed")
+			}
+			return nil
+		}, []string{"small.png"}},
+		{"timeout", func(ctx context.Context, m *Message, a *xmpp.Attachment) error {
+			if a.Size > 100 {
+				time.Sleep(time.Second) // ignores ctx
+			}
+			return nil
+		}, []string{"small.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validatingClient(50*time.Millisecond, tt.validator)
+			m := attachmentMessage()
+
+			began := time.Now()
+			c.validateAttachments(context.Background(), m)
+			if elapsed := time.Since(began); elapsed > 500*time.Millisecond {
+				t.Errorf("validation took %v", elapsed)
+			}
+
+			if len(m.Attachments) != len(tt.kept) {
+				t.Fatalf("kept %d attachments, want %v", len(m.Attachments), tt.kept)
+			}
+			for i, name := range tt.kept {
+				if m.Attachments[i].ImageFilename != name {
+					t.Errorf("kept %s, want %s", m.Attachments[i].ImageFilename, name)
+				}
+			}
+			if len(m.RejectedAttachments) != 2-len(tt.kept) {
+				t.Fatalf("rejected %d attachments, want %d", len(m.RejectedAttachments), 2-len(tt.kept))
+			}
+			for _, r := range m.RejectedAttachments {
+				if r.Reason == nil {
+					t.Errorf("rejected %s without a reason", r.Attachment.ImageFilename)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateAttachmentsReasons(t *testing.T) {
+	c := validatingClient(50*time.Millisecond, func(ctx context.Context, m *Message, a *xmpp.Attachment) error {
+		switch a.ImageFilename {
+		case "small.png":
+			panic("scanner crashed")
+		default:
+			<-ctx.Done()
+			return ctx.Err()
+		}
+	})
+	m := attachmentMessage()
+	c.validateAttachments(context.Background(), m)
+
+	if len(m.Attachments) != 0 || len(m.RejectedAttachments) != 2 {
+		t.Fatalf("kept %d and rejected %d attachments, want 0 and 2", len(m.Attachments), len(m.RejectedAttachments))
+	}
+	if r := m.RejectedAttachments[0]; r.Attachment.ImageFilename != "small.png" || !strings.Contains(r.Reason.Error(), "panicked") {
+		t.Errorf("rejected %s for %v, want small.png for panicking", r.Attachment.ImageFilename, r.Reason)
+	}
+	if r := m.RejectedAttachments[1]; r.Attachment.ImageFilename != "big.zip" || !errors.Is(r.Reason, context.DeadlineExceeded) {
+		t.Errorf("rejected %s for %v, want big.zip for timing out", r.Attachment.ImageFilename, r.Reason)
+	}
+}
+
+func TestValidateAttachmentsWithoutValidators(t *testing.T) {
+	c := validatingClient(0)
+	m := attachmentMessage()
+	c.validateAttachments(context.Background(), m)
+	if len(m.Attachments) != 2 || m.RejectedAttachments != nil {
+		t.Errorf("kept %d and rejected %d attachments without validators", len(m.Attachments), len(m.RejectedAttachments))
+	}
+}
@@ -0,0 +1,220 @@
+package hipchat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/pyalex/hipchat/xmpp"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An AttachmentCache keeps downloaded attachments in a directory, named by
+// the hash of their URL, so attachments downloaded again, e.g. when history
+// is replayed, are served from disk. Once the files take more than the size
+// of the cache the least recently used ones are removed.
+type AttachmentCache struct {
+	dir   string
+	size  int64
+	mutex sync.Mutex
+	used  int64
+	files map[string]*cachedFile
+}
+
+type cachedFile struct {
+	name  string
+	size  int64
+	atime time.Time
+}
+
+// NewAttachmentCache creates an AttachmentCache keeping at most size bytes in
+// dir, which is created if needed. Files left in dir by an earlier cache are
+// reused; other files in dir are left alone.
+func NewAttachmentCache(dir string, size int64) (*AttachmentCache, error) {
+	if size <= 0 {
+		return nil, errors.New("attachment cache size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	a := &AttachmentCache{dir: dir, size: size, files: make(map[string]*cachedFile)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || !isCacheName(e.Name()) {
+			continue
+		}
+		a.files[e.Name()] = &cachedFile{name: e.Name(), size: info.Size(), atime: info.ModTime()}
+		a.used += info.Size()
+	}
+	a.mutex.Lock()
+	a.evict()
+	a.mutex.Unlock()
+	return a, nil
+}
+
+// WithAttachmentCache makes Download keep attachments in the cache.
+func WithAttachmentCache(cache *AttachmentCache) Option {
+	return func(c *Client) error {
+		c.attachmentCache = cache
+		return nil
+	}
+}
+
+// Download writes the file of the attachment to w, from the attachment cache
+// if the client has one and the file is in it, and returns the number of
+// bytes written. Files downloaded are added to the cache. opts may be nil;
+// the client's HTTP client is used unless opts names one, and resumed
+// downloads bypass the cache.
+func (c *Client) Download(ctx context.Context, a *xmpp.Attachment, w io.Writer, opts *xmpp.DownloadOptions) (int64, error) {
+	o := downloadOptions(opts)
+	if o.Client == nil {
+		o.Client = c.httpClient
+	}
+	if c.attachmentCache == nil || o.Offset > 0 {
+		return a.Download(ctx, w, &o)
+	}
+	return c.attachmentCache.download(ctx, a, w, &o)
+}
+
+// downloadOptions returns a copy of opts, or the zero options if it is nil.
+func downloadOptions(opts *xmpp.DownloadOptions) xmpp.DownloadOptions {
+	if opts == nil {
+		return xmpp.DownloadOptions{}
+	}
+	return *opts
+}
+
+// isCacheName reports whether name is the name of a cached file, i.e. a hex
+// encoded SHA-256 hash.
+func isCacheName(name string) bool {
+	if len(name) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// cacheName returns the name the file at url is cached under.
+func cacheName(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+// download serves the attachment from the cache or downloads it into the
+// cache and w at the same time.
+func (a *AttachmentCache) download(ctx context.Context, att *xmpp.Attachment, w io.Writer, opts *xmpp.DownloadOptions) (int64, error) {
+	name := cacheName(att.ImageURL)
+	if f, size, err := a.open(name); err == nil {
+		defer f.Close()
+		if opts.Progress != nil {
+			w = &progressWriter{w: w, total: size, progress: opts.Progress}
+		}
+		return io.Copy(w, f)
+	}
+
+	tmp, err := os.CreateTemp(a.dir, name+"-*.part")
+	if err != nil {
+		return att.Download(ctx, w, opts)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, err := att.Download(ctx, io.MultiWriter(w, tmp), opts)
+	if err != nil {
+		return n, err
+	}
+	if err := tmp.Close(); err == nil {
+		a.add(name, tmp.Name(), n)
+	}
+	return n, nil
+}
+
+// open opens the cached file, returning its size, and marks it as recently
+// used.
+func (a *AttachmentCache) open(name string) (*os.File, int64, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	cf, ok := a.files[name]
+	if !ok {
+		return nil, 0, os.ErrNotExist
+	}
+	f, err := os.Open(filepath.Join(a.dir, name))
+	if err != nil {
+		a.used -= cf.size
+		delete(a.files, name)
+		return nil, 0, err
+	}
+	cf.atime = time.Now()
+	os.Chtimes(f.Name(), cf.atime, cf.atime)
+	return f, cf.size, nil
+}
+
+// A progressWriter reports the bytes written to w like a download does.
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	total    int64
+	progress func(written, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	p.progress(p.written, p.total)
+	return n, err
+}
+
+// add moves the downloaded file into the cache and evicts files if the cache
+// grew too large. Files larger than the cache are not kept.
+func (a *AttachmentCache) add(name, tmp string, size int64) {
+	if size > a.size {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := os.Rename(tmp, filepath.Join(a.dir, name)); err != nil {
+		return
+	}
+	if old, ok := a.files[name]; ok {
+		a.used -= old.size
+	}
+	a.files[name] = &cachedFile{name: name, size: size, atime: time.Now()}
+	a.used += size
+	a.evict()
+}
+
+// evict removes the least recently used files until the cache fits its size.
+// The caller must hold mutex.
+func (a *AttachmentCache) evict() {
+	if a.used <= a.size {
+		return
+	}
+
+	files := make([]*cachedFile, 0, len(a.files))
+	for _, cf := range a.files {
+		files = append(files, cf)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].atime.Before(files[j].atime)
+	})
+	for _, cf := range files {
+		if a.used <= a.size {
+			break
+		}
+		os.Remove(filepath.Join(a.dir, cf.name))
+		delete(a.files, cf.name)
+		a.used -= cf.size
+	}
+}
@@ -0,0 +1,158 @@
+package hipchat
+
+import (
+	"bytes"
+	"context"
+	"github.com/pyalex/hipchat/xmpp"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// fileServer serves each path as a file whose content is the path, and
+// counts the requests.
+func fileServer(t *testing.T) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(r.URL.Path))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+// fetch downloads the file at path through the cache and checks its content.
+func fetch(t *testing.T, cache *AttachmentCache, srv *httptest.Server, path string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	a := &xmpp.Attachment{ImageURL: srv.URL + path}
+	n, err := cache.download(context.Background(), a, &buf, &xmpp.DownloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != path || n != int64(len(path)) {
+		t.Fatalf("downloaded %q, %d bytes, want %q", buf.String(), n, path)
+	}
+}
+
+// cached reports whether the file at path is in the cache's directory.
+func cached(cache *AttachmentCache, srv *httptest.Server, path string) bool {
+	_, err := os.Stat(filepath.Join(cache.dir, cacheName(srv.URL+path)))
+	return err == nil
+}
+
+func TestAttachmentCacheHit(t *testing.T) {
+	srv, requests := fileServer(t)
+	cache, err := NewAttachmentCache(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch(t, cache, srv, "/a.png")
+	fetch(t, cache, srv, "/a.png")
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("server got %d requests, want 1", n)
+	}
+
+	// progress is reported for cached files too
+	var written, total int64
+	opts := &xmpp.DownloadOptions{Progress: func(w, t int64) { written, total = w, t }}
+	if _, err := cache.download(context.Background(), &xmpp.Attachment{ImageURL: srv.URL + "/a.png"}, io.Discard, opts); err != nil {
+		t.Fatal(err)
+	}
+	if written != 6 || total != 6 {
+		t.Errorf("reported %d of %d bytes, want 6 of 6", written, total)
+	}
+
+	fetch(t, cache, srv, "/b.png")
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("server got %d requests, want 2", n)
+	}
+}
+
+func TestAttachmentCacheEviction(t *testing.T) {
+	srv, requests := fileServer(t)
+	cache, err := NewAttachmentCache(t.TempDir(), 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch(t, cache, srv, "/one") // 4 bytes each
+	fetch(t, cache, srv, "/two")
+	fetch(t, cache, srv, "/one") // makes /two the least recently used
+	fetch(t, cache, srv, "/six")
+	fetch(t, cache, srv, "/ten")
+	if cached(cache, srv, "/two") || !cached(cache, srv, "/one") || !cached(cache, srv, "/six") || !cached(cache, srv, "/ten") {
+		t.Error("did not evict the least recently used file")
+	}
+	if cache.used != 12 {
+		t.Errorf("cache uses %d bytes, want 12", cache.used)
+	}
+	if n := atomic.LoadInt32(requests); n != 4 {
+		t.Errorf("server got %d requests, want 4", n)
+	}
+
+	// a file larger than the cache is not kept
+	fetch(t, cache, srv, "/too-large-to-keep")
+	if cached(cache, srv, "/too-large-to-keep") || cache.used != 12 {
+		t.Error("kept a file larger than the cache")
+	}
+}
+
+func TestAttachmentCacheReopen(t *testing.T) {
+	srv, requests := fileServer(t)
+	dir := t.TempDir()
+	cache, err := NewAttachmentCache(dir, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch(t, cache, srv, "/one")
+	fetch(t, cache, srv, "/two")
+	fetch(t, cache, srv, "/six")
+
+	// files not made by the cache are left alone
+	for _, name := range []string{"notes.txt", cacheName("x") + "-1.part"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("keep"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache, err = NewAttachmentCache(dir, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.used != 12 || len(cache.files) != 3 {
+		t.Errorf("reopened cache has %d files of %d bytes, want 3 of 12", len(cache.files), cache.used)
+	}
+	fetch(t, cache, srv, "/one")
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("server got %d requests, want the reopened cache to serve /one", n)
+	}
+
+	// a smaller cache evicts the least recently used files when reopened
+	cache, err = NewAttachmentCache(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached(cache, srv, "/one") || cached(cache, srv, "/two") || cached(cache, srv, "/six") {
+		t.Error("shrunk cache kept the wrong files")
+	}
+	for _, name := range []string{"notes.txt", cacheName("x") + "-1.part"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("removed %s: %v", name, err)
+		}
+	}
+}
+
+func TestNewAttachmentCacheSize(t *testing.T) {
+	if _, err := NewAttachmentCache(t.TempDir(), 0); err == nil {
+		t.Error("created a cache without room")
+	}
+}
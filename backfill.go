@@ -0,0 +1,93 @@
+package hipchat
+
+import (
+	"context"
+	"errors"
+	"github.com/pyalex/hipchat/xmpp"
+	"runtime/pprof"
+	"time"
+)
+
+// A BackfillSink receives the archived messages paged in by Backfill and keeps
+// track of how far each room has been backfilled.
+type BackfillSink interface {
+	// Checkpoint returns the archive id the next page of the room starts
+	// after, or an empty string to start at the beginning of the archive.
+	Checkpoint(roomJid string) (string, error)
+	// Write stores a page of messages of the room. Once it returns nil, the
+	// page's Last id becomes the room's checkpoint.
+	Write(roomJid string, page *HistoryPage) error
+}
+
+// Backfill is meant to run as a goroutine. It pages the archives of all joined
+// rooms into sink, one page per room in turn, waiting interval between two
+// requests, and longer while the server throttles the client, so the server's
+// rate limits are respected. Rooms that are fully backfilled keep being polled
+// for new messages. Errors are logged and the room is retried on its next
+// turn. Backfill returns when ctx is done.
+func (c *Client) Backfill(ctx context.Context, sink BackfillSink, interval time.Duration) error {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	var rooms []RoomID
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+
+		if len(rooms) == 0 {
+			if rooms = c.JoinedRooms(); len(rooms) == 0 {
+				continue
+			}
+		}
+
+		room := rooms[0]
+		rooms = rooms[1:]
+		var err error
+		labels := pprof.Labels("hipchat.jid", c.Id, "hipchat.subsystem", "backfill", "hipchat.room", string(room))
+		pprof.Do(ctx, labels, func(ctx context.Context) {
+			err = c.backfillPage(ctx, sink, string(room))
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.logger.Error("backfill failed", "event", "backfill", "room", room, "error", err)
+		}
+	}
+}
+
+// errNoResultSet is returned by backfillPage when the archive sends a page
+// without the result set its checkpoint is taken from.
+var errNoResultSet = errors.New("archive page has no result set")
+
+func (c *Client) backfillPage(ctx context.Context, sink BackfillSink, roomJid string) error {
+	after, err := sink.Checkpoint(roomJid)
+	if err != nil {
+		return err
+	}
+
+	if err := c.throttle(ctx); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeouts.History)
+	defer cancel()
+
+	hq := xmpp.HistoryQuery{With: roomJid, After: after, Max: historyPageSize}
+	page, err := c.loadHistoryPage(ctx, hq)
+	if err != nil || len(page.Messages) == 0 {
+		return err
+	}
+
+	// the checkpoint would start the room over
+	if page.Last == "" {
+		return errNoResultSet
+	}
+	for i := range page.Messages {
+		page.Messages[i].IsHistorical = true
+	}
+	return sink.Write(roomJid, page)
+}
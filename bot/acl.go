@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"errors"
+	"github.com/pyalex/hipchat"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Denied is the reply sent when the sender of a command lacks the roles it
+// requires.
+var Denied = "Sorry, you are not allowed to do that."
+
+// directoryTTL is how long the roster and room list used to resolve roles are
+// cached.
+const directoryTTL = 5 * time.Minute
+
+// directoryRetry is how long the directory waits before fetching a list again
+// that could not be fetched.
+const directoryRetry = 30 * time.Second
+
+// A Role is granted to the users it lists by mention name or JID and, if
+// RoomOwners is set, to the owner of the room a command is sent in.
+type Role struct {
+	MentionNames []string
+	JIDs         []hipchat.UserID
+	RoomOwners   bool
+}
+
+// WithRoles sets the roles commands can require with Command.Requires.
+func WithRoles(roles map[string]Role) Option {
+	return func(r *Router) error {
+		for name, role := range roles {
+			if name == "" {
+				return errors.New("role name must not be empty")
+			}
+			r.roles[name] = role
+		}
+		return nil
+	}
+}
+
+// Requires restricts the command to senders having at least one of the
+// roles. Others are answered with Denied. The sender must be known by JID,
+// which the server tells for private messages and, depending on the room's
+// settings, for room messages.
+func (cmd *Command) Requires(roles ...string) *Command {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+	cmd.roles = append(cmd.roles, roles...)
+	return cmd
+}
+
+// authorized reports whether the sender of m has one of the roles the command
+// requires.
+func (r *Router) authorized(cmd *Command, m *hipchat.Message) bool {
+	cmd.mutex.RLock()
+	roles := cmd.roles
+	cmd.mutex.RUnlock()
+
+	if len(roles) == 0 {
+		return true
+	}
+	sender := m.SenderJID.UserID()
+	if sender == "" {
+		return false
+	}
+
+	for _, name := range roles {
+		role, ok := r.roles[name]
+		if !ok {
+			continue
+		}
+		for _, jid := range role.JIDs {
+			if jid == sender {
+				return true
+			}
+		}
+		if len(role.MentionNames) > 0 {
+			mentionName := r.directory.mentionName(r.client, sender)
+			for _, name := range role.MentionNames {
+				if mentionName != "" && strings.EqualFold(strings.TrimPrefix(name, "@"), mentionName) {
+					return true
+				}
+			}
+		}
+		if role.RoomOwners && m.RoomJID != (hipchat.JID{}) {
+			if owner := r.directory.owner(r.client, m.RoomJID.RoomID()); owner != "" && ownerMatches(owner, sender) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ownerMatches reports whether the owner of a room, as listed by the server,
+// is the user.
+func ownerMatches(owner string, user hipchat.UserID) bool {
+	return hipchat.ParseJID(owner).UserID() == user
+}
+
+// A directory caches the roster and room list to resolve the mention names of
+// users and the owners of rooms. A list that could not be fetched is tried
+// again after directoryRetry, the cached one is used until then.
+type directory struct {
+	mutex        sync.Mutex
+	usersExpire  time.Time
+	roomsExpire  time.Time
+	mentionNames map[hipchat.UserID]string
+	owners       map[hipchat.RoomID]string
+}
+
+func (d *directory) mentionName(client hipchat.Chatter, user hipchat.UserID) string {
+	d.mutex.Lock()
+	stale := time.Now().After(d.usersExpire)
+	d.mutex.Unlock()
+
+	if stale {
+		// fetched without the lock, the roster may take an iq timeout
+		users := client.Users()
+
+		d.mutex.Lock()
+		if users == nil {
+			d.usersExpire = time.Now().Add(directoryRetry)
+		} else {
+			d.mentionNames = make(map[hipchat.UserID]string, len(users))
+			for _, u := range users {
+				d.mentionNames[u.Id.UserID()] = u.MentionName
+			}
+			d.usersExpire = time.Now().Add(directoryTTL)
+		}
+		d.mutex.Unlock()
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.mentionNames[user]
+}
+
+func (d *directory) owner(client hipchat.Chatter, room hipchat.RoomID) string {
+	d.mutex.Lock()
+	stale := time.Now().After(d.roomsExpire)
+	d.mutex.Unlock()
+
+	if stale {
+		rooms := client.Rooms()
+
+		d.mutex.Lock()
+		if rooms == nil {
+			d.roomsExpire = time.Now().Add(directoryRetry)
+		} else {
+			d.owners = make(map[hipchat.RoomID]string, len(rooms))
+			for _, r := range rooms {
+				d.owners[r.Id.RoomID()] = r.Owner
+			}
+			d.roomsExpire = time.Now().Add(directoryTTL)
+		}
+		d.mutex.Unlock()
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.owners[room]
+}
@@ -0,0 +1,75 @@
+package bot
+
+import (
+	"github.com/pyalex/hipchat"
+	"github.com/pyalex/hipchat/xmpptest"
+	"testing"
+)
+
+func TestRouterRoles(t *testing.T) {
+	srv := newServer(t)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+	bob := newClient(t, srv, "bob")
+	r := startRouter(t, bot, WithRoles(map[string]Role{"admin": {MentionNames: []string{"@alice"}}}))
+	r.Command("deploy").Requires("admin").Handle(func(c *Context) error {
+		return c.Reply("deploying")
+	})
+
+	if err := alice.SendPrivate("bot@"+xmpptest.Domain, "deploy"); err != nil {
+		t.Fatal(err)
+	}
+	if m := reply(t, alice, "alice"); m.Body != "deploying" {
+		t.Errorf("answered alice with %q, want deploying", m.Body)
+	}
+	if err := bob.SendPrivate("bot@"+xmpptest.Domain, "deploy"); err != nil {
+		t.Fatal(err)
+	}
+	if m := reply(t, bob, "bob"); m.Body != Denied {
+		t.Errorf("answered bob with %q, want Denied", m.Body)
+	}
+
+	// the server does not tell who sends to rooms
+	say(t, alice, "@bot deploy")
+	if m := reply(t, alice, "alice"); m.Body != Denied {
+		t.Errorf("answered alice in the room with %q, want Denied", m.Body)
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	srv := newServer(t)
+	srv.AddRoom(xmpptest.Room{JID: "1_owned@" + xmpptest.ConfDomain, Name: "Owned", Owner: "bob@" + xmpptest.Domain})
+	r, err := NewRouter(newClient(t, srv, "bot"), "bot", WithLogger(discard), WithRoles(map[string]Role{
+		"admin": {JIDs: []hipchat.UserID{"alice@" + xmpptest.Domain}},
+		"owner": {RoomOwners: true},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owned := hipchat.ParseJID("1_owned@" + xmpptest.ConfDomain)
+	alice := hipchat.ParseJID("alice@" + xmpptest.Domain + "/laptop")
+	bob := hipchat.ParseJID("bob@" + xmpptest.Domain)
+	tests := []struct {
+		name  string
+		roles []string
+		m     hipchat.Message
+		ok    bool
+	}{
+		{"no roles", nil, hipchat.Message{}, true},
+		{"listed JID", []string{"admin"}, hipchat.Message{SenderJID: alice}, true},
+		{"unlisted JID", []string{"admin"}, hipchat.Message{SenderJID: bob}, false},
+		{"unknown sender", []string{"admin"}, hipchat.Message{SenderNick: "alice"}, false},
+		{"unknown role", []string{"root"}, hipchat.Message{SenderJID: alice}, false},
+		{"room owner", []string{"owner"}, hipchat.Message{RoomJID: owned, SenderJID: bob}, true},
+		{"not the owner", []string{"owner"}, hipchat.Message{RoomJID: owned, SenderJID: alice}, false},
+		{"owner in private", []string{"owner"}, hipchat.Message{SenderJID: bob}, false},
+		{"either role", []string{"owner", "admin"}, hipchat.Message{RoomJID: owned, SenderJID: alice}, true},
+	}
+	for _, tt := range tests {
+		cmd := NewCommand("deploy").Requires(tt.roles...)
+		if ok := r.authorized(cmd, &tt.m); ok != tt.ok {
+			t.Errorf("%s: authorized %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
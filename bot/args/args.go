@@ -0,0 +1,243 @@
+// Package args parses the arguments of chat commands. Arguments are separated
+// by spaces and may be quoted with single or double quotes, including the
+// typographic quotes some chat clients substitute, to contain spaces. Flags
+// take the form --name=value, --name value or, for boolean flags, --name; a
+// lone -- ends the flags.
+package args
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A Param is a positional parameter. An optional parameter may be left out; a
+// variadic parameter takes all remaining arguments and must come last.
+type Param struct {
+	Name     string
+	Optional bool
+	Variadic bool
+}
+
+// A Flag is a named parameter. Bool flags take no value and are "true" when
+// given.
+type Flag struct {
+	Name    string
+	Default string
+	Usage   string
+	Bool    bool
+}
+
+// A Schema declares the parameters of a command.
+type Schema struct {
+	Params []Param
+	Flags  []Flag
+}
+
+// A UsageError reports arguments not matching the schema.
+type UsageError struct {
+	Msg string
+}
+
+func (e *UsageError) Error() string {
+	return e.Msg
+}
+
+// Values are the parsed arguments of a command.
+type Values struct {
+	values map[string]string
+	given  map[string]bool
+	// Words are the arguments as split by Split, flags included.
+	Words []string
+}
+
+// Get returns the value of the parameter or flag, its default if it was not
+// given or "" if there is none.
+func (v *Values) Get(name string) string {
+	if v == nil {
+		return ""
+	}
+	return v.values[name]
+}
+
+// Has reports whether the parameter or flag was given.
+func (v *Values) Has(name string) bool {
+	return v != nil && v.given[name]
+}
+
+// Split splits s into words, removing the quotes. Single quotes only open a
+// quote at the start of a word.
+func Split(s string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			switch {
+			case r == quote || quote == '"' && r == '”':
+				quote = 0
+			case r == '\\' && quote == '"' && i+1 < len(runes):
+				i++
+				word.WriteRune(runes[i])
+			default:
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'' && !inWord:
+			// an apostrophe within a word, as in "can't", is no quote
+			quote, inWord = r, true
+		case r == '“' || r == '”':
+			quote, inWord = '"', true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, &UsageError{Msg: "unterminated quote"}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Parse splits s and parses the words according to the schema.
+func (s *Schema) Parse(str string) (*Values, error) {
+	words, err := Split(str)
+	if err != nil {
+		return nil, err
+	}
+	return s.ParseWords(words)
+}
+
+// ParseWords parses the words according to the schema. It returns a
+// UsageError if they do not match.
+func (s *Schema) ParseWords(words []string) (*Values, error) {
+	v := &Values{values: make(map[string]string), given: make(map[string]bool), Words: words}
+	for _, f := range s.Flags {
+		if f.Default != "" {
+			v.values[f.Name] = f.Default
+		}
+	}
+
+	positional := make([]string, 0, len(words))
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if word == "--" {
+			positional = append(positional, words[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(word, "--") {
+			positional = append(positional, word)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(word[2:], "=")
+		f := s.flag(name)
+		if f == nil {
+			return nil, &UsageError{Msg: "unknown flag --" + name}
+		}
+		switch {
+		case f.Bool && !hasValue:
+			value = "true"
+		case !hasValue:
+			if i+1 >= len(words) {
+				return nil, &UsageError{Msg: "flag --" + name + " needs a value"}
+			}
+			i++
+			value = words[i]
+		}
+		v.values[name], v.given[name] = value, true
+	}
+
+	for i, p := range s.Params {
+		switch {
+		case p.Variadic:
+			if i < len(positional) {
+				v.values[p.Name], v.given[p.Name] = strings.Join(positional[i:], " "), true
+			} else if !p.Optional {
+				return nil, &UsageError{Msg: "missing " + p.Name}
+			}
+			return v, nil
+		case i < len(positional):
+			v.values[p.Name], v.given[p.Name] = positional[i], true
+		case !p.Optional:
+			return nil, &UsageError{Msg: "missing " + p.Name}
+		}
+	}
+	if len(positional) > len(s.Params) {
+		return nil, &UsageError{Msg: fmt.Sprintf("too many arguments: %s", strings.Join(positional[len(s.Params):], " "))}
+	}
+	return v, nil
+}
+
+// Validate checks that the schema's names are unique and that only the last
+// parameter is variadic.
+func (s *Schema) Validate() error {
+	seen := make(map[string]bool)
+	for i, p := range s.Params {
+		if p.Name == "" || seen[p.Name] {
+			return fmt.Errorf("invalid or duplicate parameter %q", p.Name)
+		}
+		if p.Variadic && i != len(s.Params)-1 {
+			return errors.New("only the last parameter may be variadic")
+		}
+		seen[p.Name] = true
+	}
+	for _, f := range s.Flags {
+		if f.Name == "" || seen[f.Name] {
+			return fmt.Errorf("invalid or duplicate flag %q", f.Name)
+		}
+		seen[f.Name] = true
+	}
+	return nil
+}
+
+// Usage returns how the command is called, e.g.
+// "deploy [--force] [--env=<env>] <version> [comment...]".
+func (s *Schema) Usage(command string) string {
+	usage := command
+	for _, f := range s.Flags {
+		if f.Bool {
+			usage += " [--" + f.Name + "]"
+		} else {
+			usage += " [--" + f.Name + "=<" + f.Name + ">]"
+		}
+	}
+	for _, p := range s.Params {
+		name := p.Name
+		if p.Variadic {
+			name += "..."
+		}
+		if p.Optional {
+			usage += " [" + name + "]"
+		} else {
+			usage += " <" + name + ">"
+		}
+	}
+	return usage
+}
+
+func (s *Schema) flag(name string) *Flag {
+	for i := range s.Flags {
+		if s.Flags[i].Name == name {
+			return &s.Flags[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package args
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		in    string
+		words []string
+	}{
+		{"", []string{}},
+		{"  deploy   web \t prod\n", []string{"deploy", "web", "prod"}},
+		{`say "hello world"`, []string{"say", "hello world"}},
+		{`say 'hello world'`, []string{"say", "hello world"}},
+		{"say “hello world”", []string{"say", "hello world"}},
+		{`say "a \"quoted\" word"`, []string{"say", `a "quoted" word`}},
+		{`say 'no \escapes'`, []string{"say", `no \escapes`}},
+		{`say hello\ world`, []string{"say", "hello world"}},
+		{`say ""`, []string{"say", ""}},
+		{`--env=prod`, []string{"--env=prod"}},
+		{`--msg="two words"`, []string{"--msg=two words"}},
+		{"I can't stop", []string{"I", "can't", "stop"}},
+		{"don't won't", []string{"don't", "won't"}},
+		{`it's "quoted"`, []string{"it's", "quoted"}},
+	}
+	for _, tt := range tests {
+		words, err := Split(tt.in)
+		if err != nil {
+			t.Errorf("Split(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(words, tt.words) {
+			t.Errorf("Split(%q) = %q, want %q", tt.in, words, tt.words)
+		}
+	}
+}
+
+func TestSplitUnterminated(t *testing.T) {
+	for _, in := range []string{`say "hello`, `say 'hello`, "say “hello", `say "escaped\"`} {
+		_, err := Split(in)
+		var usage *UsageError
+		if !errors.As(err, &usage) {
+			t.Errorf("Split(%q) returned %v, want a UsageError", in, err)
+		}
+	}
+}
+
+var deploy = Schema{
+	Params: []Param{{Name: "version"}, {Name: "comment", Optional: true, Variadic: true}},
+	Flags:  []Flag{{Name: "env", Default: "staging"}, {Name: "force", Bool: true}},
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in     string
+		values map[string]string
+		given  []string
+	}{
+		{"1.2", map[string]string{"version": "1.2", "env": "staging", "force": ""}, []string{"version"}},
+		{"--env=prod 1.2", map[string]string{"version": "1.2", "env": "prod"}, []string{"version", "env"}},
+		{"--env prod 1.2", map[string]string{"version": "1.2", "env": "prod"}, []string{"version", "env"}},
+		{"1.2 --force", map[string]string{"version": "1.2", "force": "true"}, []string{"version", "force"}},
+		{"--force=false 1.2", map[string]string{"force": "false"}, []string{"force"}},
+		{`1.2 fixes "the login" bug`, map[string]string{"comment": "fixes the login bug"}, []string{"comment"}},
+		{"1.2 it's done", map[string]string{"comment": "it's done"}, []string{"comment"}},
+		{"-- --force", map[string]string{"version": "--force", "force": ""}, []string{"version"}},
+	}
+	for _, tt := range tests {
+		v, err := deploy.Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		for name, want := range tt.values {
+			if got := v.Get(name); got != want {
+				t.Errorf("Parse(%q): %s = %q, want %q", tt.in, name, got, want)
+			}
+		}
+		for _, name := range tt.given {
+			if !v.Has(name) {
+				t.Errorf("Parse(%q): %s not given", tt.in, name)
+			}
+		}
+	}
+}
+
+func TestParseUsageErrors(t *testing.T) {
+	for _, in := range []string{"", "--env", "--region=eu 1.2", `1.2 "unterminated`} {
+		_, err := deploy.Parse(in)
+		var usage *UsageError
+		if !errors.As(err, &usage) {
+			t.Errorf("Parse(%q) returned %v, want a UsageError", in, err)
+		}
+	}
+
+	single := Schema{Params: []Param{{Name: "room"}}}
+	if _, err := single.Parse("ops dev"); err == nil {
+		t.Error("parsed too many arguments")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema Schema
+		valid  bool
+	}{
+		{"deploy", deploy, true},
+		{"empty", Schema{}, true},
+		{"unnamed param", Schema{Params: []Param{{}}}, false},
+		{"duplicate param", Schema{Params: []Param{{Name: "a"}, {Name: "a"}}}, false},
+		{"variadic not last", Schema{Params: []Param{{Name: "a", Variadic: true}, {Name: "b"}}}, false},
+		{"unnamed flag", Schema{Flags: []Flag{{}}}, false},
+		{"flag named like param", Schema{Params: []Param{{Name: "env"}}, Flags: []Flag{{Name: "env"}}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.schema.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: Validate returned %v", tt.name, err)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	want := "deploy [--env=<env>] [--force] <version> [comment...]"
+	if got := deploy.Usage("deploy"); got != want {
+		t.Errorf("Usage = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,524 @@
+// Package bot turns a HipChat client into a chat bot. A Router reads the
+// client's messages and dispatches the commands addressed to the bot, e.g.
+// "@Bot deploy prod", to the handlers registered for them:
+//
+//	r, err := bot.NewRouter(client, "Bot")
+//	if err != nil {
+//		return err
+//	}
+//	r.Command("deploy").Args("env").Help("deploys the current build").Handle(func(c *bot.Context) error {
+//		return c.Reply("deploying to " + c.Arg("env"))
+//	})
+//	return r.Run(ctx)
+//
+// In private chats the mention may be left out.
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pyalex/hipchat"
+	"github.com/pyalex/hipchat/bot/args"
+	"log/slog"
+	"regexp"
+	"runtime/debug"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Handler handles a message addressed to the bot. If it returns an error,
+// the error is logged and reported back to the sender.
+type Handler func(c *Context) error
+
+// A Router dispatches the messages received by a client to the handlers of
+// the commands they name. It is safe to register commands while it runs.
+type Router struct {
+	client      hipchat.Chatter
+	mentionName string
+	self        string // bare JID of the bot, if the client tells
+	logger      *slog.Logger
+
+	mentionOnly bool
+	userLimit   *limiter
+	roomLimit   *limiter
+	roles       map[string]Role
+	panicReply  string
+	metrics     hipchat.MetricsCollector
+	directory   directory
+
+	mutex      sync.RWMutex
+	commands   map[string]*Command
+	matchers   []matcher
+	passive    []Handler
+	notFound   Handler
+	middleware []Middleware
+
+	conversations      map[conversationKey]chan *hipchat.Message
+	conversationsMutex sync.Mutex
+
+	throttleMutex sync.Mutex // makes checking and counting the limits one step
+}
+
+// A matcher calls its handler for the messages matching its regexp or
+// predicate.
+type matcher struct {
+	re      *regexp.Regexp
+	pred    func(*hipchat.Message) bool
+	handler Handler
+}
+
+// An Option configures a Router created by NewRouter.
+type Option func(*Router) error
+
+// NewRouter creates a Router for the client. Commands are addressed to the bot
+// by its mention name, without the @. If mentionName is empty, it is looked up
+// in the roster.
+func NewRouter(client hipchat.Chatter, mentionName string, options ...Option) (*Router, error) {
+	if client == nil {
+		return nil, errors.New("client must not be nil")
+	}
+
+	r := &Router{
+		client:      client,
+		mentionName: mentionName,
+		logger:      slog.Default(),
+		metrics:     hipchat.NopMetrics{},
+		commands:    make(map[string]*Command),
+		roles:       make(map[string]Role),
+
+		conversations: make(map[conversationKey]chan *hipchat.Message),
+	}
+	r.notFound = r.unknownCommand
+	if c, ok := client.(interface{ JID() hipchat.JID }); ok {
+		r.self = c.JID().Bare()
+	}
+
+	for _, option := range options {
+		if err := option(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.mentionName == "" {
+		if r.mentionName = r.lookupMentionName(); r.mentionName == "" {
+			return nil, errors.New("mention name not found in the roster")
+		}
+	}
+	return r, nil
+}
+
+// lookupMentionName returns the mention name of the bot's own entry in the
+// roster, or "" if there is none.
+func (r *Router) lookupMentionName() string {
+	if r.self == "" {
+		return ""
+	}
+	for _, u := range r.client.Users() {
+		if u.Id.Bare() == r.self {
+			return u.MentionName
+		}
+	}
+	return ""
+}
+
+// WithMentionOnly makes the handlers registered with Match and MatchFunc fire
+// only for messages mentioning the bot and for private messages. Handlers
+// registered with Passive still see all messages.
+func WithMentionOnly() Option {
+	return func(r *Router) error {
+		r.mentionOnly = true
+		return nil
+	}
+}
+
+// WithLogger sets the logger the router reports failed and panicking handlers
+// to. The default is slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Router) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		r.logger = logger
+		return nil
+	}
+}
+
+// WithMetrics makes the router report to m how often each command is invoked
+// (bot_commands_total), how often it fails or panics (bot_command_errors_total)
+// and how long it takes (bot_command_duration), all labeled with the command.
+// Matchers and passive handlers are reported as the commands "match" and
+// "passive".
+func WithMetrics(m hipchat.MetricsCollector) Option {
+	return func(r *Router) error {
+		if m == nil {
+			return errors.New("metrics collector must not be nil")
+		}
+		r.metrics = m
+		return nil
+	}
+}
+
+// WithPanicReply makes the router answer messages whose handler panicked
+// with text, e.g. "internal error". By default the panic is only logged.
+func WithPanicReply(text string) Option {
+	return func(r *Router) error {
+		r.panicReply = text
+		return nil
+	}
+}
+
+// WithNotFound sets the handler called for commands nobody registered. The
+// default replies with the list of known commands.
+func WithNotFound(h Handler) Option {
+	return func(r *Router) error {
+		if h == nil {
+			return errors.New("handler must not be nil")
+		}
+		r.notFound = h
+		return nil
+	}
+}
+
+// Command returns the command with the given name, registering it if it is
+// new. Command names are case insensitive.
+func (r *Router) Command(name string) *Command {
+	name = strings.ToLower(name)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cmd, ok := r.commands[name]
+	if !ok {
+		cmd = NewCommand(name)
+		r.commands[name] = cmd
+	}
+	return cmd
+}
+
+// Add registers a command created with NewCommand. It fails if a command with
+// the same name is registered already.
+func (r *Router) Add(cmd *Command) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.commands[cmd.Name]; ok {
+		return fmt.Errorf("command %q already registered", cmd.Name)
+	}
+	cmd.mutex.RLock()
+	err := cmd.schema.Validate()
+	cmd.mutex.RUnlock()
+	if err != nil {
+		return fmt.Errorf("command %q: %w", cmd.Name, err)
+	}
+	r.commands[cmd.Name] = cmd
+	return nil
+}
+
+// Remove unregisters the command with the given name, if there is one.
+func (r *Router) Remove(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.commands, strings.ToLower(name))
+}
+
+// Match registers a handler called for every message whose body matches re,
+// whether it is addressed to the bot or not. Context.Groups holds the
+// submatches of the leftmost match.
+func (r *Router) Match(re *regexp.Regexp, h Handler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.matchers = append(r.matchers, matcher{re: re, handler: h})
+}
+
+// MatchFunc registers a handler called for every message pred returns true
+// for, whether it is addressed to the bot or not.
+func (r *Router) MatchFunc(pred func(*hipchat.Message) bool, h Handler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.matchers = append(r.matchers, matcher{pred: pred, handler: h})
+}
+
+// Passive registers a handler called for every message the router receives,
+// e.g. to log or index the traffic. Passive handlers should not reply.
+func (r *Router) Passive(h Handler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.passive = append(r.passive, h)
+}
+
+// Commands returns the registered commands sorted by name.
+func (r *Router) Commands() []*Command {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	commands := make([]*Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// Run dispatches the client's messages until ctx is done or the client is
+// closed. Every message is handled on a goroutine of its own, so a slow
+// handler does not hold up the others. Messages replayed from the room
+// history and the bot's own messages are ignored. Answers to questions asked
+// with Context.Ask are handed to the asking handler instead.
+func (r *Router) Run(ctx context.Context) error {
+	messages := r.client.Messages()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-messages:
+			if !ok {
+				return hipchat.ErrNotConnected
+			}
+			if m.IsHistorical || r.own(m) {
+				continue
+			}
+			if r.answer(m) {
+				continue
+			}
+			labels := pprof.Labels("hipchat.subsystem", "router", "hipchat.room", m.RoomJID.Bare())
+			go pprof.Do(ctx, labels, func(ctx context.Context) {
+				r.dispatch(ctx, m)
+			})
+		}
+	}
+}
+
+// own reports whether m was sent by the bot itself: by its JID if the server
+// tells, and otherwise by the nick it joined the room with, if the client
+// tells.
+func (r *Router) own(m *hipchat.Message) bool {
+	if r.self != "" && m.SenderJID.Bare() == r.self {
+		return true
+	}
+	if m.RoomJID.Bare() == "" || m.SenderNick == "" {
+		return false
+	}
+	if c, ok := r.client.(interface{ Nick(hipchat.RoomID) string }); ok {
+		return m.SenderNick == c.Nick(m.RoomJID.RoomID())
+	}
+	return false
+}
+
+// dispatch calls the handlers of the matchers m matches and the handler of
+// the command named in m, if m is addressed to the bot.
+func (r *Router) dispatch(ctx context.Context, m *hipchat.Message) {
+	r.mutex.RLock()
+	passive := r.passive
+	matchers := r.matchers
+	r.mutex.RUnlock()
+
+	for _, h := range passive {
+		r.call(&Context{Message: m, ctx: ctx, router: r}, "passive", h)
+	}
+	if r.mentionOnly && !r.mentioned(m) {
+		matchers = nil
+	}
+	for _, mt := range matchers {
+		c := &Context{Message: m, ctx: ctx, router: r}
+		if mt.re != nil {
+			if c.Groups = mt.re.FindStringSubmatch(m.Body); c.Groups == nil {
+				continue
+			}
+		} else if !mt.pred(m) {
+			continue
+		}
+		r.call(c, "match", mt.handler)
+	}
+
+	name, rest, ok := r.parse(m)
+	if !ok {
+		return
+	}
+
+	c := &Context{Message: m, ctx: ctx, router: r}
+	r.mutex.RLock()
+	cmd, found := r.commands[name]
+	r.mutex.RUnlock()
+
+	if found && !cmd.allows(m) {
+		r.logger.Debug("command not allowed", "event", "command", "command", name, "room", m.RoomJID, "sender", m.SenderJID)
+		return
+	}
+
+	if !r.throttle(m) {
+		return
+	}
+	if found && !r.authorized(cmd, m) {
+		r.logger.Info("command denied", "event", "command", "command", name, "room", m.RoomJID, "sender", m.SenderJID)
+		c.Reply(Denied)
+		return
+	}
+
+	h := r.notFound
+	var schema args.Schema
+	if found {
+		if handler, s := cmd.handling(); handler != nil {
+			c.Command = cmd
+			h, schema = handler, s
+		}
+	}
+
+	words, err := args.Split(rest)
+	if err == nil && c.Command != nil {
+		c.values, err = schema.ParseWords(words)
+	}
+	if err != nil {
+		usage := ""
+		if c.Command != nil {
+			usage = "\nusage: " + cmd.Usage()
+		}
+		c.Reply(err.Error() + usage)
+		return
+	}
+	c.Args = words
+	r.call(c, name, h)
+}
+
+// throttle counts a command against the rate limits of its sender and room and
+// reports whether it may run.
+func (r *Router) throttle(m *hipchat.Message) bool {
+	key := conversationOf(m)
+	ok, warn := r.allow(key, time.Now())
+	if !ok {
+		r.logger.Debug("command throttled", "event", "command", "room", m.RoomJID, "sender", key.sender)
+	}
+	if warn {
+		c := &Context{Message: m, router: r}
+		c.Reply(SlowDown)
+	}
+	return ok
+}
+
+// allow reports whether a command of the conversation is within the limits of
+// both its sender and room and, if it is not, whether to warn the sender. Only
+// commands that may run are counted, so commands refused because the room is
+// busy do not use up the sender's own limit.
+func (r *Router) allow(key conversationKey, now time.Time) (ok, warn bool) {
+	r.throttleMutex.Lock()
+	defer r.throttleMutex.Unlock()
+
+	if ok, warn = r.userLimit.check(key.sender, now); ok {
+		ok, warn = r.roomLimit.check(string(key.room), now)
+	}
+	if ok {
+		r.userLimit.record(key.sender, now)
+		r.roomLimit.record(string(key.room), now)
+	}
+	return ok, warn
+}
+
+// call calls h wrapped in the router's middleware and reports the error it
+// returns, if any. A panicking handler is logged with its stack and answered
+// with the panic reply, if there is one.
+func (r *Router) call(c *Context, name string, h Handler) {
+	labels := map[string]string{"command": name}
+	r.metrics.IncCounter("bot_commands_total", labels)
+	start := time.Now()
+	defer func() {
+		r.metrics.ObserveDuration("bot_command_duration", time.Since(start), labels)
+	}()
+
+	defer func() {
+		if x := recover(); x != nil {
+			r.metrics.IncCounter("bot_command_errors_total", labels)
+			r.logger.Error("handler panicked", "event", "handler_panic", "command", name, "room", c.Message.RoomJID,
+				"error", x, "stack", string(debug.Stack()))
+			if r.panicReply != "" {
+				c.Reply(r.panicReply)
+			}
+		}
+	}()
+
+	r.mutex.RLock()
+	h = chain(h, r.middleware)
+	r.mutex.RUnlock()
+
+	if err := h(c); err != nil {
+		r.metrics.IncCounter("bot_command_errors_total", labels)
+		r.logger.Error("command failed", "event", "command", "command", name, "room", c.Message.RoomJID, "error", err)
+		c.Error("%s failed: %s", name, err)
+	}
+}
+
+// parse splits a message addressed to the bot into the lower case command name
+// and the rest of the body. It reports false if the message is not addressed
+// to the bot.
+func (r *Router) parse(m *hipchat.Message) (string, string, bool) {
+	body, ok := r.addressed(m)
+	if !ok {
+		return "", "", false
+	}
+
+	fields := strings.SplitN(body, " ", 2)
+	if fields[0] == "" {
+		return "", "", false
+	}
+	if len(fields) == 1 {
+		return strings.ToLower(fields[0]), "", true
+	}
+	return strings.ToLower(fields[0]), strings.TrimSpace(fields[1]), true
+}
+
+// addressed returns the body of m without the mention of the bot and reports
+// whether m is addressed to the bot: it mentions the bot first or is a
+// private message.
+func (r *Router) addressed(m *hipchat.Message) (string, bool) {
+	body := strings.TrimSpace(m.Body)
+	mention := "@" + r.mentionName
+	if len(body) >= len(mention) && strings.EqualFold(body[:len(mention)], mention) {
+		rest := body[len(mention):]
+		if rest == "" || strings.ContainsRune(" \t\n:,", rune(rest[0])) {
+			return strings.TrimSpace(strings.TrimLeft(rest, ":,")), true
+		}
+	}
+	if m.RoomJID == (hipchat.JID{}) {
+		return body, true
+	}
+	return "", false
+}
+
+// mentioned reports whether m mentions the bot anywhere in its body or is a
+// private message.
+func (r *Router) mentioned(m *hipchat.Message) bool {
+	if m.RoomJID == (hipchat.JID{}) {
+		return true
+	}
+
+	body := strings.ToLower(m.Body)
+	mention := "@" + strings.ToLower(r.mentionName)
+	for i := strings.Index(body, mention); i >= 0; i = strings.Index(body, mention) {
+		body = body[i+len(mention):]
+		if body == "" || !isNameChar(body[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNameChar reports whether b may be part of a mention name.
+func isNameChar(b byte) bool {
+	return b == '_' || b == '-' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z'
+}
+
+// unknownCommand is the default handler for unknown commands.
+func (r *Router) unknownCommand(c *Context) error {
+	commands := r.Commands()
+	names := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		if h, _ := cmd.handling(); h != nil {
+			names = append(names, cmd.Name)
+		}
+	}
+	if len(names) == 0 {
+		return c.Reply("I do not know any commands.")
+	}
+	return c.Reply("Unknown command. Try one of: " + strings.Join(names, ", "))
+}
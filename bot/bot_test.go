@@ -0,0 +1,251 @@
+package bot
+
+import (
+	"context"
+	"github.com/pyalex/hipchat"
+	"github.com/pyalex/hipchat/xmpptest"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testRoom = "1_test@" + xmpptest.ConfDomain
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newServer starts a test server with the users bot, alice and bob and the
+// room testRoom.
+func newServer(t *testing.T) *xmpptest.Server {
+	t.Helper()
+
+	srv, err := xmpptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	srv.AddUser(xmpptest.User{Name: "bot", Password: "secret", FullName: "Bot", MentionName: "bot"})
+	srv.AddUser(xmpptest.User{Name: "alice", Password: "secret", FullName: "Alice", MentionName: "alice"})
+	srv.AddUser(xmpptest.User{Name: "bob", Password: "secret", FullName: "Bob", MentionName: "bob"})
+	srv.AddRoom(xmpptest.Room{JID: testRoom, Name: "Test"})
+	return srv
+}
+
+// newClient connects the user to the server and joins testRoom with the
+// user's name as nick.
+func newClient(t *testing.T, srv *xmpptest.Server, user string) *hipchat.Client {
+	t.Helper()
+
+	config := hipchat.Config{
+		XMPPHost: srv.Host(),
+		ConfHost: xmpptest.ConfDomain,
+		Port:     srv.Port(),
+		Timeouts: hipchat.Timeouts{IQ: 5 * time.Second, History: 5 * time.Second},
+	}
+	c, err := hipchat.NewClientWithConfig(user, "secret", "", config, hipchat.WithSlog(discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.JoinContext(ctx, testRoom, user, 0); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// receive waits for the next message of the client.
+func receive(t *testing.T, c *hipchat.Client) *hipchat.Message {
+	t.Helper()
+
+	select {
+	case m, ok := <-c.Messages():
+		if !ok {
+			t.Fatal("client closed")
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return nil
+}
+
+// reply waits for the next message to c not sent by c itself in testRoom
+// under the nick, nor addressed to the bot.
+func reply(t *testing.T, c *hipchat.Client, nick string) *hipchat.Message {
+	t.Helper()
+
+	for {
+		m := receive(t, c)
+		if m.SenderNick != nick && !strings.HasPrefix(m.Body, "@bot") {
+			return m
+		}
+	}
+}
+
+// say sends the body to testRoom.
+func say(t *testing.T, c *hipchat.Client, body string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.SayAck(ctx, testRoom, "", body, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startRouter creates a router for the client and runs it until the test
+// ends.
+func startRouter(t *testing.T, c *hipchat.Client, options ...Option) *Router {
+	t.Helper()
+
+	r, err := NewRouter(c, "bot", append([]Option{WithLogger(discard)}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return r
+}
+
+// echo registers the command echo, replying with its arguments and recording
+// them.
+func echo(r *Router) func() []string {
+	var mutex sync.Mutex
+	var calls []string
+	r.Command("echo").Args("text...").Handle(func(c *Context) error {
+		mutex.Lock()
+		calls = append(calls, c.Arg("text"))
+		mutex.Unlock()
+		return c.Reply(c.Arg("text"))
+	})
+	return func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), calls...)
+	}
+}
+
+func TestRouterDispatch(t *testing.T) {
+	srv := newServer(t)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+	r := startRouter(t, bot)
+	echo(r)
+
+	for _, body := range []string{"@bot echo hello world", "@Bot: ECHO hello world", "@bot, echo \"hello world\""} {
+		say(t, alice, body)
+		if m := reply(t, alice, "alice"); m.Body != "hello world" {
+			t.Errorf("answered %q with %q, want hello world", body, m.Body)
+		}
+	}
+
+	// private messages need no mention
+	if err := alice.SendPrivate("bot@"+xmpptest.Domain, "echo in private"); err != nil {
+		t.Fatal(err)
+	}
+	if m := reply(t, alice, "alice"); m.Body != "in private" || m.RoomJID != (hipchat.JID{}) {
+		t.Errorf("answered privately with %q in %v, want in private", m.Body, m.RoomJID)
+	}
+}
+
+func TestRouterUnknownCommand(t *testing.T) {
+	srv := newServer(t)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+	r := startRouter(t, bot)
+	echo(r)
+	r.Command("deploy").Args("env").Handle(func(c *Context) error { return nil })
+
+	say(t, alice, "@bot frobnicate")
+	if m := reply(t, alice, "alice"); m.Body != "Unknown command. Try one of: deploy, echo" {
+		t.Errorf("answered an unknown command with %q", m.Body)
+	}
+
+	// messages not addressed to the bot are no commands
+	say(t, alice, "echo hello")
+	say(t, alice, "@bot echo addressed")
+	if m := reply(t, alice, "alice"); m.Body != "addressed" {
+		t.Errorf("answered %q, want the addressed command only", m.Body)
+	}
+}
+
+func TestRouterUsageErrors(t *testing.T) {
+	srv := newServer(t)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+	r := startRouter(t, bot)
+	r.Command("deploy").Args("env", "version?").BoolFlag("force", "skip the checks").Handle(func(c *Context) error {
+		return c.Reply("deploying " + c.Arg("version") + " to " + c.Arg("env") + " forced " + c.Arg("force"))
+	})
+
+	tests := []struct {
+		body  string
+		reply string
+	}{
+		{"@bot deploy", "missing env\nusage: deploy [--force] <env> [version]"},
+		{"@bot deploy prod 1.2 extra", "too many arguments: extra\nusage: deploy [--force] <env> [version]"},
+		{"@bot deploy --env=prod", "unknown flag --env\nusage: deploy [--force] <env> [version]"},
+		{"@bot deploy \"prod", "unterminated quote\nusage: deploy [--force] <env> [version]"},
+		{"@bot deploy --force prod 1.2", "deploying 1.2 to prod forced true"},
+	}
+	for _, tt := range tests {
+		say(t, alice, tt.body)
+		if m := reply(t, alice, "alice"); m.Body != tt.reply {
+			t.Errorf("answered %q with %q, want %q", tt.body, m.Body, tt.reply)
+		}
+	}
+}
+
+func TestRouterIgnoresOwnMessages(t *testing.T) {
+	srv := newServer(t)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+	r := startRouter(t, bot)
+	calls := echo(r)
+
+	say(t, bot, "@bot echo loop")
+	say(t, alice, "@bot echo one")
+	if m := reply(t, alice, "alice"); m.Body != "one" {
+		t.Fatalf("answered %q, want one", m.Body)
+	}
+	say(t, alice, "@bot echo two")
+	if m := reply(t, alice, "alice"); m.Body != "two" {
+		t.Fatalf("answered %q, want two", m.Body)
+	}
+	if got := calls(); len(got) != 2 {
+		t.Errorf("handled %q, want one and two", got)
+	}
+}
+
+func TestRouterReplyCode(t *testing.T) {
+	srv := newServer(t)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+	r := startRouter(t, bot)
+	r.Command("code").Args("lang?").Handle(func(c *Context) error {
+		return c.ReplyCode(c.Arg("lang"), "x := 1")
+	})
+
+	say(t, alice, "@bot code")
+	if m := reply(t, alice, "alice"); m.Body != "/code x := 1" {
+		t.Errorf("replied %q without a language", m.Body)
+	}
+	say(t, alice, "@bot code go")
+	if m := reply(t, alice, "alice"); m.Body != "```go\nx := 1\n```" {
+		t.Errorf("replied %q with a language", m.Body)
+	}
+}
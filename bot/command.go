@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"github.com/pyalex/hipchat"
+	"github.com/pyalex/hipchat/bot/args"
+	"strings"
+	"sync"
+)
+
+// A Command is a command the bot understands. It is configured with chained
+// calls on the value returned by Router.Command:
+//
+//	r.Command("deploy").Args("env", "version?").Flag("force", "", "skip the checks").Handle(deploy)
+type Command struct {
+	Name string
+
+	// mutex guards the configuration, which may change while the command is
+	// dispatched
+	mutex      sync.RWMutex
+	help       string
+	schema     args.Schema
+	handler    Handler
+	middleware []Middleware
+	rooms      map[hipchat.RoomID]bool
+	users      map[hipchat.UserID]bool
+	roles      []string
+}
+
+// NewCommand creates a command to be registered later with Router.Add, e.g.
+// by a Plugin. Router.Command creates and registers a command in one step.
+func NewCommand(name string) *Command {
+	return &Command{Name: strings.ToLower(name)}
+}
+
+// Args declares the positional arguments of the command. A name ending in "?"
+// is optional, a name ending in "..." takes the rest of the arguments. The
+// values are available from Context.Arg under the name without the suffix.
+// Commands whose arguments do not match are answered with the usage.
+func (cmd *Command) Args(names ...string) *Command {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+
+	cmd.schema.Params = make([]args.Param, len(names))
+	for i, name := range names {
+		p := &cmd.schema.Params[i]
+		if strings.HasSuffix(name, "?") {
+			name, p.Optional = strings.TrimSuffix(name, "?"), true
+		}
+		if strings.HasSuffix(name, "...") {
+			name, p.Variadic = strings.TrimSuffix(name, "..."), true
+		}
+		p.Name = name
+	}
+	return cmd
+}
+
+// Flag declares a flag given as --name=value or --name value. Its value is
+// available from Context.Arg and defaults to def.
+func (cmd *Command) Flag(name, def, usage string) *Command {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+
+	cmd.schema.Flags = append(cmd.schema.Flags, args.Flag{Name: name, Default: def, Usage: usage})
+	return cmd
+}
+
+// BoolFlag declares a flag given as --name. Context.Arg returns "true" for it
+// if it was given.
+func (cmd *Command) BoolFlag(name, usage string) *Command {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+
+	cmd.schema.Flags = append(cmd.schema.Flags, args.Flag{Name: name, Usage: usage, Bool: true})
+	return cmd
+}
+
+// Help sets the description of the command.
+func (cmd *Command) Help(text string) *Command {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+
+	cmd.help = text
+	return cmd
+}
+
+// Handle sets the handler of the command.
+func (cmd *Command) Handle(h Handler) *Command {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+
+	cmd.handler = h
+	return cmd
+}
+
+// InRooms restricts the command to the rooms. It is ignored when sent
+// anywhere else, including private chats.
+func (cmd *Command) InRooms(rooms ...hipchat.RoomID) *Command {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+
+	if cmd.rooms == nil {
+		cmd.rooms = make(map[hipchat.RoomID]bool, len(rooms))
+	}
+	for _, room := range rooms {
+		cmd.rooms[room] = true
+	}
+	return cmd
+}
+
+// ForUsers restricts the command to the users. It is ignored when sent by
+// anybody else or by a sender the server does not reveal the JID of.
+func (cmd *Command) ForUsers(users ...hipchat.UserID) *Command {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+
+	if cmd.users == nil {
+		cmd.users = make(map[hipchat.UserID]bool, len(users))
+	}
+	for _, user := range users {
+		cmd.users[user] = true
+	}
+	return cmd
+}
+
+// allows reports whether the command may be run from the room and by the
+// sender of m.
+func (cmd *Command) allows(m *hipchat.Message) bool {
+	cmd.mutex.RLock()
+	defer cmd.mutex.RUnlock()
+
+	if cmd.rooms != nil && !cmd.rooms[m.RoomJID.RoomID()] {
+		return false
+	}
+	if cmd.users != nil && !cmd.users[m.SenderJID.UserID()] {
+		return false
+	}
+	return true
+}
+
+// Description returns the text set with Help.
+func (cmd *Command) Description() string {
+	cmd.mutex.RLock()
+	defer cmd.mutex.RUnlock()
+
+	return cmd.help
+}
+
+// Usage returns how the command is called, e.g. "deploy [--force] <env>
+// [version]".
+func (cmd *Command) Usage() string {
+	cmd.mutex.RLock()
+	defer cmd.mutex.RUnlock()
+
+	return cmd.schema.Usage(cmd.Name)
+}
+
+// handling returns the handler of the command wrapped in its middleware, or
+// nil if it has none, and the schema its arguments are parsed with.
+func (cmd *Command) handling() (Handler, args.Schema) {
+	cmd.mutex.RLock()
+	defer cmd.mutex.RUnlock()
+
+	if cmd.handler == nil {
+		return nil, cmd.schema
+	}
+	return chain(cmd.handler, cmd.middleware), cmd.schema
+}
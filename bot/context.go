@@ -0,0 +1,80 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"github.com/pyalex/hipchat"
+	"github.com/pyalex/hipchat/bot/args"
+)
+
+// AckText is the reply sent by Context.Ack.
+var AckText = "(thumbsup)"
+
+// A Context is a message being handled by the bot along with ways to answer
+// it.
+type Context struct {
+	Message *hipchat.Message
+	Command *Command // nil for unknown commands
+	Args    []string // the words following the command name, see args.Split
+	Groups  []string // the submatches of the regexp passed to Match
+
+	values *args.Values
+	ctx    context.Context
+	router *Router
+}
+
+// Context returns the context passed to Router.Run. It is done when the
+// router stops.
+func (c *Context) Context() context.Context {
+	return c.ctx
+}
+
+// Arg returns the value of the argument or flag declared with Command.Args or
+// Command.Flag, or "" if it was not given and has no default.
+func (c *Context) Arg(name string) string {
+	return c.values.Get(name)
+}
+
+// Client returns the client the message was received by.
+func (c *Context) Client() hipchat.Chatter {
+	return c.router.client
+}
+
+// Reply answers the message in the room it was sent to or, for private
+// messages, privately.
+func (c *Context) Reply(text string) error {
+	m := c.Message
+	if m.RoomJID != (hipchat.JID{}) {
+		return c.router.client.Say(m.RoomJID.RoomID(), c.router.mentionName, text, nil)
+	}
+	return c.router.client.SendPrivate(m.SenderJID.UserID(), text)
+}
+
+// Ack acknowledges the message with AckText, e.g. when a command was started
+// and takes a while to finish.
+func (c *Context) Ack() error {
+	return c.Reply(AckText)
+}
+
+// ReplyCode replies with text formatted as code in the language lang, e.g.
+// "go". Without a language it uses HipChat's /code command, which keeps the
+// whitespace and highlights the syntax of the language HipChat detects. With
+// one the text is sent in a fenced block tagged with it, so clients pick its
+// lexer.
+func (c *Context) ReplyCode(lang, text string) error {
+	if lang == "" {
+		return c.Reply("/code " + text)
+	}
+	return c.Reply("```" + lang + "\n" + text + "\n```")
+}
+
+// ReplyPrivately answers the message in a private chat with the sender, even
+// if it was sent to a room. It fails if the server did not tell who the sender
+// of a room message is.
+func (c *Context) ReplyPrivately(text string) error {
+	sender := c.Message.SenderJID.UserID()
+	if sender == "" {
+		return errors.New("sender unknown")
+	}
+	return c.router.client.SendPrivate(sender, text)
+}
@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"errors"
+	"github.com/pyalex/hipchat"
+	"time"
+)
+
+var (
+	// ErrNoAnswer is returned by Ask when the sender does not answer in time.
+	ErrNoAnswer = errors.New("no answer")
+	// ErrAsking is returned by Ask when the bot is already waiting for an
+	// answer from the sender in the same room.
+	ErrAsking = errors.New("already waiting for an answer")
+)
+
+// A conversationKey identifies the sender of a message in a room or private
+// chat. Senders whose JID is not known are identified by their nickname.
+type conversationKey struct {
+	room   hipchat.RoomID
+	sender string
+}
+
+func conversationOf(m *hipchat.Message) conversationKey {
+	sender := m.SenderJID.Bare()
+	if sender == "" {
+		sender = m.SenderNick
+	}
+	return conversationKey{room: m.RoomJID.RoomID(), sender: sender}
+}
+
+// Ask replies with the question and waits for the next message of the sender
+// in the same room or private chat, which is returned instead of being
+// dispatched. It returns ErrNoAnswer if no answer comes within timeout and the
+// context's error if the router stops first. Handlers can call Ask repeatedly
+// to walk the sender through several steps.
+func (c *Context) Ask(question string, timeout time.Duration) (*hipchat.Message, error) {
+	key := conversationOf(c.Message)
+	answer := make(chan *hipchat.Message, 1)
+
+	r := c.router
+	r.conversationsMutex.Lock()
+	if _, ok := r.conversations[key]; ok {
+		r.conversationsMutex.Unlock()
+		return nil, ErrAsking
+	}
+	r.conversations[key] = answer
+	r.conversationsMutex.Unlock()
+
+	defer func() {
+		r.conversationsMutex.Lock()
+		delete(r.conversations, key)
+		r.conversationsMutex.Unlock()
+	}()
+
+	if err := c.Reply(question); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case m := <-answer:
+		return m, nil
+	case <-timer.C:
+		return nil, ErrNoAnswer
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+}
+
+// answer hands m to the handler waiting for an answer from its sender and
+// reports whether there was one.
+func (r *Router) answer(m *hipchat.Message) bool {
+	key := conversationOf(m)
+
+	r.conversationsMutex.Lock()
+	answer, ok := r.conversations[key]
+	delete(r.conversations, key)
+	r.conversationsMutex.Unlock()
+
+	if ok {
+		answer <- m
+	}
+	return ok
+}
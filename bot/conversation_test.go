@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRouterAsk(t *testing.T) {
+	srv := newServer(t)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+	bob := newClient(t, srv, "bob")
+	r := startRouter(t, bot)
+	calls := echo(r)
+	r.Command("greet").Args("timeout?").Handle(func(c *Context) error {
+		timeout := 5 * time.Second
+		if c.Arg("timeout") != "" {
+			timeout, _ = time.ParseDuration(c.Arg("timeout"))
+		}
+		answer, err := c.Ask("What is your name?", timeout)
+		if errors.Is(err, ErrNoAnswer) {
+			return c.Reply("Never mind.")
+		}
+		if err != nil {
+			return err
+		}
+		return c.Reply("Hello, " + answer.Body)
+	})
+
+	say(t, alice, "@bot greet")
+	if m := reply(t, alice, "alice"); m.Body != "What is your name?" {
+		t.Fatalf("asked %q", m.Body)
+	}
+
+	// only the asked user answers, and the answer is no command
+	say(t, bob, "@bot echo interjection")
+	if m := reply(t, alice, "alice"); m.Body != "interjection" {
+		t.Fatalf("answered %q, want bob's interjection", m.Body)
+	}
+	say(t, alice, "@bot echo Alice")
+	if m := reply(t, alice, "alice"); m.Body != "Hello, @bot echo Alice" {
+		t.Errorf("answered %q, want the greeting", m.Body)
+	}
+	if got := calls(); len(got) != 1 {
+		t.Errorf("dispatched %q, want bob's command only", got)
+	}
+
+	say(t, alice, "@bot greet 100ms")
+	if m := reply(t, alice, "alice"); m.Body != "What is your name?" {
+		t.Fatalf("asked %q", m.Body)
+	}
+	if m := reply(t, alice, "alice"); m.Body != "Never mind." {
+		t.Errorf("answered %q without an answer, want Never mind.", m.Body)
+	}
+}
@@ -0,0 +1,32 @@
+package bot
+
+// A Middleware wraps a handler to add behavior around it, e.g. logging or
+// authorization. It may return without calling next to stop the message from
+// being handled.
+type Middleware func(next Handler) Handler
+
+// Use adds middleware run around every handler of the router: commands,
+// matchers, passive handlers and the not found handler. The middleware added
+// first runs first.
+func (r *Router) Use(mw ...Middleware) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Use adds middleware run around the handler of the command, inside the
+// middleware of the router. The middleware added first runs first.
+func (cmd *Command) Use(mw ...Middleware) *Command {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+	cmd.middleware = append(cmd.middleware, mw...)
+	return cmd
+}
+
+// chain wraps h in the middleware, the first one outermost.
+func chain(h Handler, mw []Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"fmt"
+	"github.com/pyalex/hipchat"
+	"sort"
+	"sync"
+)
+
+// A Plugin packages a bot feature: the commands it adds to the router and
+// whatever it runs in the background, e.g. a Scheduler.
+type Plugin interface {
+	Name() string
+	// Commands returns the commands the plugin adds while it is enabled.
+	Commands() []*Command
+	// Start starts the plugin's background work.
+	Start(client *hipchat.Client) error
+	// Stop stops what Start started.
+	Stop() error
+}
+
+// A Registry knows the plugins a bot is built with and enables and disables
+// them at runtime, e.g. from the deployment's configuration or from an admin
+// command. Disabling a plugin removes its commands from the router.
+type Registry struct {
+	router *Router
+	client *hipchat.Client
+
+	mutex   sync.Mutex
+	plugins map[string]Plugin
+	enabled map[string]bool
+}
+
+// NewRegistry creates a Registry adding the commands of the plugins to router
+// and starting them with client.
+func NewRegistry(router *Router, client *hipchat.Client) *Registry {
+	return &Registry{
+		router:  router,
+		client:  client,
+		plugins: make(map[string]Plugin),
+		enabled: make(map[string]bool),
+	}
+}
+
+// Register makes the plugin known to the registry. It is disabled until
+// Enable is called.
+func (reg *Registry) Register(p Plugin) error {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	if _, ok := reg.plugins[p.Name()]; ok {
+		return fmt.Errorf("plugin %q already registered", p.Name())
+	}
+	reg.plugins[p.Name()] = p
+	return nil
+}
+
+// Enable starts the plugin and adds its commands to the router. Enabling an
+// enabled plugin does nothing.
+func (reg *Registry) Enable(name string) error {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	p, ok := reg.plugins[name]
+	if !ok {
+		return fmt.Errorf("unknown plugin %q", name)
+	}
+	if reg.enabled[name] {
+		return nil
+	}
+
+	if err := p.Start(reg.client); err != nil {
+		return fmt.Errorf("plugin %q: %w", name, err)
+	}
+	added := make([]*Command, 0)
+	for _, cmd := range p.Commands() {
+		if err := reg.router.Add(cmd); err != nil {
+			for _, cmd := range added {
+				reg.router.Remove(cmd.Name)
+			}
+			p.Stop()
+			return fmt.Errorf("plugin %q: %w", name, err)
+		}
+		added = append(added, cmd)
+	}
+	reg.enabled[name] = true
+	return nil
+}
+
+// EnableAll enables the plugins with the given names, e.g. the ones listed
+// in the deployment's configuration, and stops at the first failure.
+func (reg *Registry) EnableAll(names []string) error {
+	for _, name := range names {
+		if err := reg.Enable(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Disable removes the commands of the plugin from the router and stops it.
+// Disabling a disabled plugin does nothing.
+func (reg *Registry) Disable(name string) error {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	p, ok := reg.plugins[name]
+	if !ok {
+		return fmt.Errorf("unknown plugin %q", name)
+	}
+	if !reg.enabled[name] {
+		return nil
+	}
+
+	for _, cmd := range p.Commands() {
+		reg.router.Remove(cmd.Name)
+	}
+	delete(reg.enabled, name)
+	if err := p.Stop(); err != nil {
+		return fmt.Errorf("plugin %q: %w", name, err)
+	}
+	return nil
+}
+
+// Enabled returns the names of the enabled plugins, sorted.
+func (reg *Registry) Enabled() []string {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	names := make([]string, 0, len(reg.enabled))
+	for name := range reg.enabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
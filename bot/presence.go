@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"context"
+	"github.com/pyalex/hipchat"
+	"log/slog"
+	"time"
+)
+
+// A PresenceRule sets the presence during a time of day, given as the time
+// since midnight, on some days of the week. A rule with no days applies every
+// day. A rule whose To is before its From spans midnight.
+type PresenceRule struct {
+	Days   []time.Weekday
+	From   time.Duration
+	To     time.Duration
+	Show   hipchat.Show
+	Status string
+}
+
+// A PresenceSchedule decides the presence of a bot by the time, e.g. "away"
+// outside business hours with a status message pointing to the on-call. The
+// first rule matching the time wins; if none does, Show and Status apply.
+type PresenceSchedule struct {
+	Location *time.Location // defaults to time.Local
+	Rules    []PresenceRule
+	Show     hipchat.Show
+	Status   string
+}
+
+// BusinessHours returns a schedule showing the bot as available from from to
+// to on weekdays in loc and as away with the status outside of them.
+func BusinessHours(loc *time.Location, from, to time.Duration, status string) *PresenceSchedule {
+	return &PresenceSchedule{
+		Location: loc,
+		Rules: []PresenceRule{{
+			Days: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+			From: from,
+			To:   to,
+			Show: hipchat.ShowChat,
+		}},
+		Show:   hipchat.ShowAway,
+		Status: status,
+	}
+}
+
+// At returns the presence the schedule sets at t.
+func (s *PresenceSchedule) At(t time.Time) (hipchat.Show, string) {
+	loc := s.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	sinceMidnight := t.Sub(midnight)
+
+	for _, rule := range s.Rules {
+		if rule.matches(t.Weekday(), sinceMidnight) {
+			return rule.Show, rule.Status
+		}
+	}
+	return s.Show, s.Status
+}
+
+func (rule *PresenceRule) matches(day time.Weekday, sinceMidnight time.Duration) bool {
+	if len(rule.Days) > 0 {
+		found := false
+		for _, d := range rule.Days {
+			found = found || d == day
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.From <= rule.To {
+		return rule.From <= sinceMidnight && sinceMidnight < rule.To
+	}
+	return rule.From <= sinceMidnight || sinceMidnight < rule.To
+}
+
+// Run sets the presence of the client according to the schedule, checking
+// every minute, until ctx is done. The presence is sent again after the client
+// reconnected, since the server forgets it with the connection.
+func (s *PresenceSchedule) Run(ctx context.Context, client *hipchat.Client, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+
+	var show hipchat.Show
+	var status string
+	sent := false
+	lost := client.DisconnectReason()
+	for {
+		// a new disconnect reason means the client reconnected in between
+		if reason := client.DisconnectReason(); reason != lost {
+			lost, sent = reason, false
+		}
+		if !client.Connected() {
+			sent = false
+		} else if next, nextStatus := s.At(time.Now()); !sent || next != show || nextStatus != status {
+			if err := client.SetStatus(next, nextStatus); err != nil {
+				logger.Error("could not set presence", "event", "presence", "show", next, "error", err)
+			} else {
+				show, status, sent = next, nextStatus, true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
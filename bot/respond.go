@@ -0,0 +1,45 @@
+package bot
+
+import "fmt"
+
+// A Preset formats replies of one kind the same way throughout a bot. Messages
+// sent over XMPP have no color, so presets mark them with an emoticon instead.
+type Preset struct {
+	Prefix string
+}
+
+// The presets used by Context.Success, Context.Warning and Context.Error.
+// Bots may change them to match their style.
+var (
+	SuccessPreset = Preset{Prefix: "(successful)"}
+	WarningPreset = Preset{Prefix: "(warning)"}
+	ErrorPreset   = Preset{Prefix: "(failed)"}
+)
+
+// Format returns text formatted with the preset.
+func (p Preset) Format(text string) string {
+	if p.Prefix == "" {
+		return text
+	}
+	return p.Prefix + " " + text
+}
+
+// Respond replies with text formatted with the preset.
+func (c *Context) Respond(p Preset, text string) error {
+	return c.Reply(p.Format(text))
+}
+
+// Success replies with the text formatted as a success.
+func (c *Context) Success(format string, a ...interface{}) error {
+	return c.Respond(SuccessPreset, fmt.Sprintf(format, a...))
+}
+
+// Warning replies with the text formatted as a warning.
+func (c *Context) Warning(format string, a ...interface{}) error {
+	return c.Respond(WarningPreset, fmt.Sprintf(format, a...))
+}
+
+// Error replies with the text formatted as an error.
+func (c *Context) Error(format string, a ...interface{}) error {
+	return c.Respond(ErrorPreset, fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@never",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"1,,2 * * * *",
+		"a * * * *",
+		"-5 * * * *",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("parsed %q", spec)
+		}
+	}
+}
+
+// 2024-01-01 is a Monday.
+func at(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestScheduleMatches(t *testing.T) {
+	tests := []struct {
+		spec  string
+		match []time.Time
+		miss  []time.Time
+	}{
+		{"* * * * *", []time.Time{at(1, 1, 0, 0), at(12, 31, 23, 59)}, nil},
+		{"30 9 * * *", []time.Time{at(1, 1, 9, 30), at(6, 15, 9, 30)}, []time.Time{at(1, 1, 9, 31), at(1, 1, 10, 30)}},
+		{"*/15 * * * *", []time.Time{at(1, 1, 3, 0), at(1, 1, 3, 15), at(1, 1, 3, 45)}, []time.Time{at(1, 1, 3, 10)}},
+		{"10-20/5 * * * *", []time.Time{at(1, 1, 0, 10), at(1, 1, 0, 15), at(1, 1, 0, 20)}, []time.Time{at(1, 1, 0, 5), at(1, 1, 0, 25), at(1, 1, 0, 12)}},
+		{"0 8,12,17 * * *", []time.Time{at(1, 1, 8, 0), at(1, 1, 12, 0), at(1, 1, 17, 0)}, []time.Time{at(1, 1, 9, 0)}},
+		{"0 9-17 * * *", []time.Time{at(1, 1, 9, 0), at(1, 1, 17, 0)}, []time.Time{at(1, 1, 8, 0), at(1, 1, 18, 0)}},
+		{"0 0 * 2-3 *", []time.Time{at(2, 1, 0, 0), at(3, 31, 0, 0)}, []time.Time{at(1, 31, 0, 0), at(4, 1, 0, 0)}},
+
+		// weekdays: 2024-01-01 is a Monday, 01-06 a Saturday and 01-07 a Sunday
+		{"0 9 * * 1-5", []time.Time{at(1, 1, 9, 0), at(1, 5, 9, 0)}, []time.Time{at(1, 6, 9, 0), at(1, 7, 9, 0)}},
+		{"0 9 * * 0", []time.Time{at(1, 7, 9, 0)}, []time.Time{at(1, 1, 9, 0)}},
+		{"0 9 * * 7", []time.Time{at(1, 7, 9, 0)}, []time.Time{at(1, 6, 9, 0)}},
+
+		// a restricted day of month and day of week match either
+		{"0 0 13 * 5", []time.Time{at(1, 13, 0, 0), at(1, 5, 0, 0), at(9, 13, 0, 0)}, []time.Time{at(1, 14, 0, 0)}},
+		// only one restricted, it alone decides
+		{"0 0 13 * *", []time.Time{at(1, 13, 0, 0)}, []time.Time{at(1, 5, 0, 0)}},
+		{"0 0 * * 5", []time.Time{at(1, 5, 0, 0)}, []time.Time{at(1, 13, 0, 0)}},
+
+		{"@hourly", []time.Time{at(1, 1, 5, 0)}, []time.Time{at(1, 1, 5, 1)}},
+		{"@daily", []time.Time{at(1, 2, 0, 0)}, []time.Time{at(1, 2, 1, 0)}},
+		{"@weekly", []time.Time{at(1, 7, 0, 0)}, []time.Time{at(1, 8, 0, 0)}},
+		{"@monthly", []time.Time{at(2, 1, 0, 0)}, []time.Time{at(2, 2, 0, 0)}},
+		{"@yearly", []time.Time{at(1, 1, 0, 0)}, []time.Time{at(2, 1, 0, 0)}},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		for _, tm := range tt.match {
+			if !s.Matches(tm) {
+				t.Errorf("%q does not match %v", tt.spec, tm.Format(time.RFC1123))
+			}
+		}
+		for _, tm := range tt.miss {
+			if s.Matches(tm) {
+				t.Errorf("%q matches %v", tt.spec, tm.Format(time.RFC1123))
+			}
+		}
+	}
+}
+
+func TestSchedulerSkipsWhileDisconnected(t *testing.T) {
+	srv := newServer(t)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+
+	runs := 0
+	job := Job{Name: "standup", Spec: "0 9 * * *", Room: testRoom, Message: func(ctx context.Context) (string, error) {
+		runs++
+		return "standup time", nil
+	}}
+	skipped, caughtUp := job, job
+	caughtUp.Name, caughtUp.CatchUp = "reminder", true
+
+	s := NewScheduler(bot, discard)
+	for _, job := range []Job{skipped, caughtUp} {
+		if err := s.Add(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	offline := NewScheduler(newClient(t, srv, "bob"), discard)
+	offline.client.Close()
+	for _, job := range s.jobs {
+		offline.tick(context.Background(), job, at(1, 1, 9, 0))
+	}
+	if runs != 0 {
+		t.Fatalf("ran %d jobs while disconnected", runs)
+	}
+
+	// once connected only the job catching up runs, before it is due again
+	for _, job := range s.jobs {
+		s.tick(context.Background(), job, at(1, 1, 9, 1))
+	}
+	if runs != 1 {
+		t.Fatalf("ran %d jobs after reconnecting, want 1", runs)
+	}
+	if m := receive(t, alice); m.Body != "standup time" {
+		t.Errorf("received %q, want the reminder", m.Body)
+	}
+
+	// and only once
+	for _, job := range s.jobs {
+		s.tick(context.Background(), job, at(1, 1, 9, 2))
+	}
+	if runs != 1 {
+		t.Errorf("ran %d jobs, want the missed run caught up once", runs)
+	}
+}
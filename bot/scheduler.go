@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"github.com/pyalex/hipchat"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// A Job posts a message to a room on a cron schedule, e.g. a standup reminder
+// or a daily report.
+type Job struct {
+	Name string
+	Spec string // the cron expression, see ParseSchedule
+	Room hipchat.RoomID
+
+	// Message returns the text to post. Runs it returns an error for are
+	// logged and skipped.
+	Message func(ctx context.Context) (string, error)
+
+	// CatchUp makes the scheduler post once as soon as the client is
+	// connected again if runs were missed while it was disconnected. By
+	// default missed runs are skipped.
+	CatchUp bool
+
+	schedule *Schedule
+	missed   bool
+}
+
+// A Scheduler runs jobs while a client is connected. The client keeps
+// reconnecting on its own, so the jobs survive reconnects; runs that fall
+// into a disconnect are skipped or caught up, see Job.CatchUp.
+type Scheduler struct {
+	client *hipchat.Client
+	logger *slog.Logger
+
+	mutex sync.Mutex
+	jobs  []*Job
+}
+
+// NewScheduler creates a Scheduler posting with client.
+func NewScheduler(client *hipchat.Client, logger *slog.Logger) *Scheduler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Scheduler{client: client, logger: logger}
+}
+
+// Add adds a job to the scheduler.
+func (s *Scheduler) Add(job Job) error {
+	if job.Message == nil {
+		return errors.New("job message must not be nil")
+	}
+	schedule, err := ParseSchedule(job.Spec)
+	if err != nil {
+		return err
+	}
+	job.schedule = schedule
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jobs = append(s.jobs, &job)
+	return nil
+}
+
+// Run runs the jobs at the start of the minutes they are scheduled for until
+// ctx is done.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case now = <-timer.C:
+		}
+
+		s.mutex.Lock()
+		jobs := make([]*Job, len(s.jobs))
+		copy(jobs, s.jobs)
+		s.mutex.Unlock()
+
+		for _, job := range jobs {
+			s.tick(ctx, job, now)
+		}
+	}
+}
+
+// tick runs the job if it is due at now or was missed and is to be caught up.
+func (s *Scheduler) tick(ctx context.Context, job *Job, now time.Time) {
+	due := job.schedule.Matches(now)
+	if !due && !job.missed {
+		return
+	}
+	if !s.client.Connected() {
+		if due {
+			s.logger.Warn("skipped job while disconnected", "event", "schedule", "job", job.Name, "room", job.Room)
+			job.missed = job.CatchUp
+		}
+		return
+	}
+	job.missed = false
+
+	text, err := job.Message(ctx)
+	if err == nil {
+		err = s.client.Say(job.Room, job.Name, text, nil)
+	}
+	if err != nil {
+		s.logger.Error("job failed", "event", "schedule", "job", job.Name, "room", job.Room, "error", err)
+	}
+}
@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// A Limit allows Count commands per period. The zero Limit allows any
+// number.
+type Limit struct {
+	Count int
+	Per   time.Duration
+}
+
+// SlowDown is the reply sent, once per period, to users exceeding the rate
+// limits set with WithRateLimit.
+var SlowDown = "Slow down, please. I will answer again in a moment."
+
+// WithRateLimit limits how many commands the router answers per user and per
+// room. Commands beyond the limits are answered with SlowDown once and
+// ignored afterwards until the period is over, so the bot does not flood
+// HipChat and trip the server's rate limits.
+func WithRateLimit(perUser, perRoom Limit) Option {
+	return func(r *Router) error {
+		if perUser.Count < 0 || perRoom.Count < 0 || perUser.Per < 0 || perRoom.Per < 0 {
+			return errors.New("rate limits must not be negative")
+		}
+		r.userLimit = newLimiter(perUser)
+		r.roomLimit = newLimiter(perRoom)
+		return nil
+	}
+}
+
+// maxWindows is the number of windows a limiter keeps before it forgets the
+// ones that are over.
+const maxWindows = 1024
+
+// A limiter counts the commands of each key in fixed windows.
+type limiter struct {
+	limit   Limit
+	mutex   sync.Mutex
+	windows map[string]*window
+}
+
+type window struct {
+	start  time.Time
+	count  int
+	warned bool
+}
+
+func newLimiter(limit Limit) *limiter {
+	return &limiter{limit: limit, windows: make(map[string]*window)}
+}
+
+// allow counts a command of key and reports whether it may run and, if it
+// may not, whether the sender should be warned.
+func (l *limiter) allow(key string, now time.Time) (ok, warn bool) {
+	if ok, warn = l.check(key, now); ok {
+		l.record(key, now)
+	}
+	return ok, warn
+}
+
+// check reports whether a command of key may run without counting it and, if
+// it may not, whether the sender should be warned.
+func (l *limiter) check(key string, now time.Time) (ok, warn bool) {
+	if l == nil || l.limit.Count == 0 || key == "" {
+		return true, false
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	w := l.window(key, now)
+	if w.count < l.limit.Count {
+		return true, false
+	}
+	warn = !w.warned
+	w.warned = true
+	return false, warn
+}
+
+// record counts a command of key.
+func (l *limiter) record(key string, now time.Time) {
+	if l == nil || l.limit.Count == 0 || key == "" {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.window(key, now).count++
+}
+
+// window returns the current window of key, starting a new one if the last
+// is over. The caller must hold mutex.
+func (l *limiter) window(key string, now time.Time) *window {
+	w := l.windows[key]
+	if w == nil || now.Sub(w.start) >= l.limit.Per {
+		if len(l.windows) >= maxWindows {
+			l.prune(now)
+		}
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+	return w
+}
+
+// prune forgets the windows that are over. The caller must hold mutex.
+func (l *limiter) prune(now time.Time) {
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.limit.Per {
+			delete(l.windows, key)
+		}
+	}
+}
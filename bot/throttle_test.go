@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouterThrottle(t *testing.T) {
+	srv := newServer(t)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+	bob := newClient(t, srv, "bob")
+	r := startRouter(t, bot, WithRateLimit(Limit{Count: 2, Per: time.Minute}, Limit{}))
+	echo(r)
+
+	for _, body := range []string{"one", "two"} {
+		say(t, alice, "@bot echo "+body)
+		if m := reply(t, alice, "alice"); m.Body != body {
+			t.Fatalf("answered %q, want %q", m.Body, body)
+		}
+	}
+	say(t, alice, "@bot echo three")
+	if m := reply(t, alice, "alice"); m.Body != SlowDown {
+		t.Fatalf("answered %q over the limit, want SlowDown", m.Body)
+	}
+
+	// further commands are ignored without another warning, other users
+	// are still answered
+	say(t, alice, "@bot echo four")
+	say(t, bob, "@bot echo five")
+	if m := reply(t, alice, "alice"); m.Body != "five" {
+		t.Errorf("answered %q, want bob's five", m.Body)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := newLimiter(Limit{Count: 2, Per: time.Minute})
+
+	tests := []struct {
+		key  string
+		at   time.Duration
+		ok   bool
+		warn bool
+	}{
+		{"alice", 0, true, false},
+		{"alice", time.Second, true, false},
+		{"alice", 2 * time.Second, false, true},
+		{"alice", 3 * time.Second, false, false},
+		{"bob", 3 * time.Second, true, false},
+		{"alice", time.Minute, true, false},
+		{"", time.Minute, true, false},
+	}
+	for i, tt := range tests {
+		if ok, warn := l.allow(tt.key, now.Add(tt.at)); ok != tt.ok || warn != tt.warn {
+			t.Errorf("%d: %s at %v allowed %v, warned %v, want %v and %v", i, tt.key, tt.at, ok, warn, tt.ok, tt.warn)
+		}
+	}
+
+	var unlimited *limiter
+	if ok, _ := unlimited.allow("alice", now); !ok {
+		t.Error("nil limiter throttled")
+	}
+}
+
+func TestRouterAllow(t *testing.T) {
+	now := time.Now()
+	r := &Router{
+		userLimit: newLimiter(Limit{Count: 2, Per: time.Minute}),
+		roomLimit: newLimiter(Limit{Count: 1, Per: 10 * time.Second}),
+	}
+	alice := conversationKey{room: testRoom, sender: "alice"}
+
+	tests := []struct {
+		at   time.Duration
+		ok   bool
+		warn bool
+	}{
+		{0, true, false},
+		{time.Second, false, true}, // the room is busy
+		{2 * time.Second, false, false},
+		{10 * time.Second, true, false}, // refused commands did not count against alice
+		{20 * time.Second, false, true}, // but now she is over her own limit
+	}
+	for i, tt := range tests {
+		if ok, warn := r.allow(alice, now.Add(tt.at)); ok != tt.ok || warn != tt.warn {
+			t.Errorf("%d: at %v allowed %v, warned %v, want %v and %v", i, tt.at, ok, warn, tt.ok, tt.warn)
+		}
+	}
+}
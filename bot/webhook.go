@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/pyalex/hipchat"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// maxWebhookBody is the largest request body a Webhook accepts.
+const maxWebhookBody = 64 << 10
+
+// A Notification is the JSON body posted to a Webhook. Format is "text", the
+// default; Color is one of HipChat's notification colors. Messages sent over
+// XMPP are always plain text and have no color, so Color is only validated.
+type Notification struct {
+	Room    hipchat.RoomID `json:"room"`
+	Message string         `json:"message"`
+	Format  string         `json:"format"`
+	Color   string         `json:"color"`
+}
+
+// notificationColors are the colors HipChat accepts for notifications.
+var notificationColors = map[string]bool{
+	"": true, "yellow": true, "green": true, "red": true, "purple": true, "gray": true, "random": true,
+}
+
+// A Webhook is an http.Handler relaying the notifications POSTed to it to
+// rooms, e.g. from a CI system. Requests must carry the token either as a
+// bearer token in the Authorization header or in the auth_token query
+// parameter. The client must have joined the rooms.
+type Webhook struct {
+	client hipchat.Chatter
+	token  string
+	name   string
+	logger *slog.Logger
+}
+
+// NewWebhook creates a Webhook posting with client as name. The token must
+// not be empty.
+func NewWebhook(client hipchat.Chatter, token, name string, logger *slog.Logger) *Webhook {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Webhook{client: client, token: token, name: name, logger: logger}
+}
+
+func (wh *Webhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !wh.authorized(req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var n Notification
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxWebhookBody)).Decode(&n); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case n.Room == "" || n.Message == "":
+		http.Error(w, "room and message are required", http.StatusBadRequest)
+		return
+	case n.Format != "" && n.Format != "text":
+		http.Error(w, "unsupported format "+n.Format, http.StatusBadRequest)
+		return
+	case !notificationColors[n.Color]:
+		http.Error(w, "invalid color "+n.Color, http.StatusBadRequest)
+		return
+	}
+
+	if err := wh.client.Say(n.Room, wh.name, n.Message, nil); err != nil {
+		wh.logger.Error("could not relay notification", "event", "webhook", "room", n.Room, "error", err)
+		http.Error(w, "could not relay notification", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorized reports whether the request carries the webhook's token.
+func (wh *Webhook) authorized(req *http.Request) bool {
+	token := req.URL.Query().Get("auth_token")
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return wh.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(wh.token)) == 1
+}
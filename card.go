@@ -0,0 +1,148 @@
+package hipchat
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Card styles.
+const (
+	CardApplication = "application"
+	CardLink        = "link"
+	CardMedia       = "media"
+	CardFile        = "file"
+	CardImage       = "image"
+)
+
+// A Card is a rich notification rendered by HipChat as a tile with a title,
+// description, icon, attributes and activity. Cards can only be sent through
+// the REST API, see the rest package; cards in received messages are parsed
+// into Message.Card. A Card is built with chained calls:
+//
+//	card := hipchat.NewCard("build-42", "Build #42").
+//		WithDescription("All tests passed", "text").
+//		WithAttribute("Branch", "master", "lozenge-success")
+type Card struct {
+	Style       string          `json:"style"`
+	ID          string          `json:"id"`
+	Title       string          `json:"title"`
+	Description CardDescription `json:"description"`
+	URL         string          `json:"url,omitempty"`
+	Format      string          `json:"format,omitempty"` // "compact" or "medium"
+	Icon        *CardIcon       `json:"icon,omitempty"`
+	Attributes  []CardAttribute `json:"attributes,omitempty"`
+	Activity    *CardActivity   `json:"activity,omitempty"`
+}
+
+// A CardDescription is the text of a card in the format "text" or "html".
+type CardDescription struct {
+	Value  string `json:"value"`
+	Format string `json:"format"`
+}
+
+// UnmarshalJSON accepts descriptions given as plain strings as well.
+func (d *CardDescription) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*d = CardDescription{Value: s, Format: "text"}
+		return nil
+	}
+	type description CardDescription
+	return json.Unmarshal(b, (*description)(d))
+}
+
+// A CardIcon is the image shown next to a card's title or activity.
+type CardIcon struct {
+	URL string `json:"url"`
+}
+
+// A CardAttribute is a labeled value shown on a card. Style is the style of
+// the value's lozenge, e.g. "lozenge-success" or "lozenge-error".
+type CardAttribute struct {
+	Label string             `json:"label,omitempty"`
+	Value CardAttributeValue `json:"value"`
+}
+
+// A CardAttributeValue is the value of a card attribute.
+type CardAttributeValue struct {
+	Label string    `json:"label"`
+	Style string    `json:"style,omitempty"`
+	URL   string    `json:"url,omitempty"`
+	Icon  *CardIcon `json:"icon,omitempty"`
+}
+
+// A CardActivity is the line shown in the room for a card in the compact
+// format.
+type CardActivity struct {
+	HTML string    `json:"html"`
+	Icon *CardIcon `json:"icon,omitempty"`
+}
+
+// NewCard creates an application card. The id identifies the card so later
+// notifications can update it.
+func NewCard(id, title string) *Card {
+	return &Card{Style: CardApplication, ID: id, Title: title}
+}
+
+// WithDescription sets the description in the format "text" or "html".
+func (c *Card) WithDescription(text, format string) *Card {
+	c.Description = CardDescription{Value: text, Format: format}
+	return c
+}
+
+// WithURL sets the URL the title links to.
+func (c *Card) WithURL(url string) *Card {
+	c.URL = url
+	return c
+}
+
+// WithIcon sets the icon shown next to the title.
+func (c *Card) WithIcon(url string) *Card {
+	c.Icon = &CardIcon{URL: url}
+	return c
+}
+
+// WithAttribute adds an attribute with a value in the lozenge style, which
+// may be empty.
+func (c *Card) WithAttribute(label, value, style string) *Card {
+	c.Attributes = append(c.Attributes, CardAttribute{Label: label, Value: CardAttributeValue{Label: value, Style: style}})
+	return c
+}
+
+// WithActivity sets the activity line, which makes HipChat show the card in
+// the compact format.
+func (c *Card) WithActivity(html, iconURL string) *Card {
+	c.Activity = &CardActivity{HTML: html}
+	if iconURL != "" {
+		c.Activity.Icon = &CardIcon{URL: iconURL}
+	}
+	c.Format = "compact"
+	return c
+}
+
+// Validate checks that the card has what HipChat requires.
+func (c *Card) Validate() error {
+	switch {
+	case c.ID == "":
+		return errors.New("card id must not be empty")
+	case c.Title == "":
+		return errors.New("card title must not be empty")
+	case c.Style == "":
+		return errors.New("card style must not be empty")
+	}
+	return nil
+}
+
+// attachCard decodes the JSON of the card sent with m, if any. Malformed cards
+// are logged and dropped.
+func (c *Client) attachCard(m *Message, cardJSON string) {
+	if cardJSON == "" {
+		return
+	}
+	card := new(Card)
+	if err := json.Unmarshal([]byte(cardJSON), card); err != nil {
+		c.logger.Warn("skipped malformed card", "event", "stanza", "id", m.Mid, "error", err)
+		return
+	}
+	m.Card = card
+}
@@ -0,0 +1,17 @@
+package hipchat
+
+import "github.com/pyalex/hipchat/xmpp"
+
+// A Chatter is the core of what a bot does with HipChat. *Client implements
+// it; applications that depend on a Chatter rather than a *Client can replace
+// it with a fake in their tests.
+type Chatter interface {
+	Say(roomId RoomID, name, body string, attachments []xmpp.Attachment) error
+	SendPrivate(to UserID, body string) error
+	Join(roomId RoomID, resource string, history int) error
+	Messages() <-chan *Message
+	Rooms() []*Room
+	Users() []*User
+}
+
+var _ Chatter = (*Client)(nil)
@@ -0,0 +1,39 @@
+package hipchat
+
+// Close closes the connection to HipChat and the channels returned by
+// Messages, Invites and Errors. Pending requests return ErrNotConnected. It
+// is safe to call Close more than once and from any goroutine. Shutdown closes
+// the client gracefully.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		c.logger.Info("closing connection", "event", "close")
+
+		c.closed.Store(true)
+		c.online.Store(false)
+		close(c.done)
+		if conn := c.conn(); conn != nil {
+			conn.Close()
+		}
+
+		c.historyMutex.Lock()
+		ids := make([]string, 0, len(c.history))
+		for id := range c.history {
+			ids = append(ids, id)
+		}
+		c.historyMutex.Unlock()
+		for _, id := range ids {
+			c.finishHistory(id, nil, ErrNotConnected)
+		}
+
+		// wait for goroutines delivering on the channels to give up
+		c.deliverMutex.Lock()
+		close(c.receivedMessage)
+		close(c.receivedInvites)
+		close(c.receivedErrors)
+		close(c.receivedPresence)
+		close(c.rateLimits)
+		c.deliverMutex.Unlock()
+
+		c.publishLifecycle(EventClosed, Event{})
+	})
+}
@@ -0,0 +1,143 @@
+// Command hipchat-bot-init writes the main.go of a new bot: a client
+// configured from the environment (see hipchat.NewClientFromEnv) that joins a
+// room, logs disconnects and reconnects, and answers a ping command through a
+// bot.Router.
+//
+// Usage:
+//
+//	hipchat-bot-init [-dir .] [-mention Bot] [-name "Some Bot"] [-room 11111_room@conf.hipchat.com] [-force]
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+var mainTemplate = template.Must(template.New("main.go").Parse(`// Command {{.Package}} is a HipChat bot. It reads its credentials from
+// HIPCHAT_USER and HIPCHAT_PASSWORD (see hipchat.NewClientFromEnv) and
+// answers "@{{.Mention}} ping" in the room it joins.
+package main
+
+import (
+	"context"
+	"github.com/pyalex/hipchat"
+	"github.com/pyalex/hipchat/bot"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+const (
+	room        = hipchat.RoomID({{printf "%q" .Room}})
+	fullName    = {{printf "%q" .Name}}
+	mentionName = {{printf "%q" .Mention}}
+)
+
+func main() {
+	client, err := hipchat.NewClientFromEnv()
+	if err != nil {
+		log.Fatalf("client error: %s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// the client reconnects and rejoins its rooms by itself
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case reason := <-client.OnDisconnect:
+				log.Printf("disconnected: %s", reason)
+			case <-client.OnReconnect:
+				log.Print("reconnected")
+			}
+		}
+	}()
+
+	if err := client.SetStatus(hipchat.ShowChat, ""); err != nil {
+		log.Fatalf("status error: %s", err)
+	}
+	if err := client.Join(room, fullName, 0); err != nil {
+		log.Fatalf("join error: %s", err)
+	}
+
+	router, err := bot.NewRouter(client, mentionName, bot.WithPanicReply("internal error"))
+	if err != nil {
+		log.Fatalf("router error: %s", err)
+	}
+	router.Command("ping").Help("checks that the bot is alive").Handle(func(c *bot.Context) error {
+		return c.Reply("pong")
+	})
+	router.Command("echo").Args("text...").Help("repeats the text").Handle(func(c *bot.Context) error {
+		return c.Reply(c.Arg("text"))
+	})
+
+	if err := router.Run(ctx); err != nil && err != context.Canceled {
+		log.Printf("router stopped: %s", err)
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client.Shutdown(shutdown)
+}
+`))
+
+type params struct {
+	Package string
+	Room    string
+	Name    string
+	Mention string
+}
+
+func main() {
+	dir := flag.String("dir", ".", "directory to write main.go to")
+	mention := flag.String("mention", "Bot", "mention name of the bot, without the @")
+	name := flag.String("name", "Some Bot", "full name the bot joins the room as")
+	room := flag.String("room", "11111_room_name@conf.hipchat.com", "JID of the room to join")
+	force := flag.Bool("force", false, "overwrite an existing main.go")
+	flag.Parse()
+
+	if err := generate(*dir, *force, params{Room: *room, Name: *name, Mention: *mention}); err != nil {
+		fmt.Fprintln(os.Stderr, "hipchat-bot-init:", err)
+		os.Exit(1)
+	}
+}
+
+func generate(dir string, force bool, p params) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	p.Package = filepath.Base(abs)
+
+	var b bytes.Buffer
+	if err := mainTemplate.Execute(&b, p); err != nil {
+		return err
+	}
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "main.go")
+	if _, err := os.Stat(path); err == nil && !force {
+		return errors.New(path + " exists, use -force to overwrite it")
+	}
+	if err := os.WriteFile(path, src, 0644); err != nil {
+		return err
+	}
+	fmt.Println("wrote", path)
+	return nil
+}
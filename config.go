@@ -0,0 +1,58 @@
+package hipchat
+
+import (
+	"crypto/tls"
+	"net"
+	"strconv"
+	"time"
+)
+
+// A Config selects the HipChat server a Client connects to. Zero fields take
+// their defaults from the package-level variables, so two clients with
+// different configs can talk to different servers from the same process.
+type Config struct {
+	XMPPHost string      // defaults to Host
+	ConfHost string      // defaults to Conf
+	Port     int         // defaults to 5222
+	TLS      *tls.Config // see WithTLSConfig
+	Timeouts Timeouts
+}
+
+// Timeouts configures how long a Client waits for the server.
+type Timeouts struct {
+	IQ         time.Duration // defaults to IQTimeout
+	History    time.Duration // defaults to HistoryTimeout
+	Dead       time.Duration // see WithDeadTimeout
+	Validation time.Duration // defaults to ValidationTimeout
+}
+
+// withDefaults returns the config with its zero fields set to the defaults.
+func (config Config) withDefaults() Config {
+	if config.XMPPHost == "" {
+		config.XMPPHost = Host
+	}
+	if config.ConfHost == "" {
+		config.ConfHost = Conf
+	}
+	if config.Port == 0 {
+		config.Port = 5222
+	}
+	if config.Timeouts.IQ == 0 {
+		config.Timeouts.IQ = IQTimeout
+	}
+	if config.Timeouts.History == 0 {
+		config.Timeouts.History = HistoryTimeout
+	}
+	if config.Timeouts.Validation == 0 {
+		config.Timeouts.Validation = ValidationTimeout
+	}
+	if config.Timeouts.Dead == 0 {
+		config.Timeouts.Dead = 5 * time.Minute
+	}
+	return config
+}
+
+// addr returns the address of the XMPP server.
+func (config Config) addr() string {
+	return net.JoinHostPort(config.XMPPHost, strconv.Itoa(config.Port))
+}
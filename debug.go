@@ -0,0 +1,95 @@
+package hipchat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"sort"
+	"time"
+)
+
+// The goroutines of a client carry pprof labels naming the client's JID, the
+// subsystem they belong to and, where there is one, the room they work on, so
+// the goroutine profile of a stuck bot with many rooms tells them apart:
+//
+//	pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
+
+// goLabeled runs fn in a new goroutine labeled with the subsystem and the
+// room, if it is not "".
+func (c *Client) goLabeled(subsystem, room string, fn func()) {
+	labels := pprof.Labels("hipchat.jid", c.Id, "hipchat.subsystem", subsystem)
+	if room != "" {
+		labels = pprof.Labels("hipchat.jid", c.Id, "hipchat.subsystem", subsystem, "hipchat.room", room)
+	}
+	go pprof.Do(context.Background(), labels, func(context.Context) {
+		fn()
+	})
+}
+
+// DumpState writes what the client is doing to w, e.g. from a debug endpoint
+// or a signal handler: its connection, joined rooms, requests waiting for the
+// server, buffered stanzas and statistics.
+func (c *Client) DumpState(w io.Writer) error {
+	stats := c.Stats()
+
+	c.joinedMutex.Lock()
+	joined := make([]string, 0, len(c.joined))
+	for room, resource := range c.joined {
+		joined = append(joined, string(room)+"/"+resource)
+	}
+	c.joinedMutex.Unlock()
+	sort.Strings(joined)
+
+	c.iqMutex.Lock()
+	iqs := len(c.iqs)
+	c.iqMutex.Unlock()
+	c.acksMutex.Lock()
+	acks := len(c.acks)
+	c.acksMutex.Unlock()
+	c.historyMutex.Lock()
+	history := len(c.history)
+	c.historyMutex.Unlock()
+	c.repliesMutex.Lock()
+	replies := len(c.replies)
+	c.repliesMutex.Unlock()
+
+	c.subscribersMutex.RLock()
+	topics := make([]string, 0, len(c.subscribers))
+	for topic, subscribers := range c.subscribers {
+		topics = append(topics, fmt.Sprintf("%s=%d", topic, len(subscribers)))
+	}
+	c.subscribersMutex.RUnlock()
+	sort.Strings(topics)
+
+	reason := "none"
+	if r := c.DisconnectReason(); r != nil {
+		reason = r.String()
+	}
+
+	_, err := fmt.Fprintf(w, `jid: %s
+connected: %t (closed: %t, last disconnect: %s)
+uptime: %s, last stanza: %s ago
+joined rooms: %d %v
+pending: %d iqs, %d acks, %d history queries, %d awaited replies
+buffered: %d/%d messages, %d invites, %d presences, %d errors
+handling: %d messages, dropped: %d
+messages: %d received, %d sent
+bytes: %d received, %d sent
+reconnects: %d
+subscribers: %v
+`,
+		c.JID().Full(),
+		stats.Connected, c.Closed(), reason,
+		stats.Uptime.Round(time.Second), time.Since(stats.LastStanza).Round(time.Millisecond),
+		len(joined), joined,
+		iqs, acks, history, replies,
+		len(c.receivedMessage), cap(c.receivedMessage), len(c.receivedInvites), len(c.receivedPresence), len(c.receivedErrors),
+		c.busy.Load(), stats.MessagesDropped,
+		stats.MessagesReceived, stats.MessagesSent,
+		stats.BytesReceived, stats.BytesSent,
+		stats.Reconnects,
+		topics,
+	)
+	return err
+}
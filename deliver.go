@@ -0,0 +1,102 @@
+package hipchat
+
+import (
+	"context"
+)
+
+// An OverflowPolicy decides what happens to an incoming message when the
+// Messages channel is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for the consumer to make room. Nothing is read from
+	// the connection in the meantime.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest buffered message to make room.
+	OverflowDropOldest
+	// OverflowDropNewest discards the incoming message.
+	OverflowDropNewest
+)
+
+// DroppedMessages returns the number of messages discarded so far because the
+// Messages channel was full.
+func (c *Client) DroppedMessages() uint64 {
+	return c.dropped.Load()
+}
+
+// deliver sends a message on the Messages channel according to the overflow
+// policy. It gives up when ctx is done or the client is closed.
+func (c *Client) deliver(ctx context.Context, m *Message) error {
+	c.deliverMutex.RLock()
+	defer c.deliverMutex.RUnlock()
+
+	if c.Closed() {
+		return ErrNotConnected
+	}
+
+	switch c.overflow {
+	case OverflowDropNewest:
+		select {
+		case c.receivedMessage <- m:
+		default:
+			c.dropped.Add(1)
+		}
+		return nil
+	case OverflowDropOldest:
+		for {
+			select {
+			case c.receivedMessage <- m:
+				return nil
+			default:
+			}
+			select {
+			case <-c.receivedMessage:
+				c.dropped.Add(1)
+			default:
+			}
+		}
+	}
+
+	select {
+	case c.receivedMessage <- m:
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx)
+	case <-c.done:
+		return ErrNotConnected
+	}
+}
+
+// deliverInvite sends an invite on the Invites channel, dropping it if nobody
+// is reading.
+func (c *Client) deliverInvite(r *Room) {
+	c.deliverMutex.RLock()
+	defer c.deliverMutex.RUnlock()
+
+	if c.Closed() {
+		return
+	}
+
+	select {
+	case c.receivedInvites <- r:
+	default:
+		c.logger.Warn("dropped invite", "event", "invite", "room", r.Id)
+	}
+}
+
+// deliverPresence hands a presence to the OnPresence handlers, dropping it if
+// they fall behind.
+func (c *Client) deliverPresence(p *Presence) {
+	c.deliverMutex.RLock()
+	defer c.deliverMutex.RUnlock()
+
+	if c.Closed() {
+		return
+	}
+
+	select {
+	case c.receivedPresence <- p:
+	default:
+		c.logger.Debug("dropped presence", "event", "presence", "from", p.From)
+	}
+}
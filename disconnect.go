@@ -0,0 +1,53 @@
+package hipchat
+
+// A DisconnectReason explains why the connection to HipChat was lost. When
+// the server sent a stream error, Condition holds its defined condition (e.g.
+// "conflict", "system-shutdown", "policy-violation" or "see-other-host"), Text
+// its description and Host the host the client was redirected to. Otherwise
+// Err holds the error that ended the connection.
+type DisconnectReason struct {
+	Condition string
+	Text      string
+	Host      string
+	Err       error
+}
+
+func (r *DisconnectReason) String() string {
+	switch {
+	case r.Condition != "" && r.Text != "":
+		return r.Condition + ": " + r.Text
+	case r.Condition != "":
+		return r.Condition
+	case r.Err != nil:
+		return r.Err.Error()
+	}
+	return "unknown"
+}
+
+// DisconnectReason returns why the connection was last lost, or nil if it
+// never was.
+func (c *Client) DisconnectReason() *DisconnectReason {
+	c.disconnectMutex.Lock()
+	defer c.disconnectMutex.Unlock()
+	return c.disconnectReason
+}
+
+// Connected reports whether the client is connected to HipChat right now. It
+// is false while the client reconnects and once it is closed.
+func (c *Client) Connected() bool {
+	return c.online.Load()
+}
+
+func (c *Client) setDisconnectReason(r *DisconnectReason) {
+	c.online.Store(false)
+	c.disconnectMutex.Lock()
+	c.disconnectReason = r
+	c.disconnectMutex.Unlock()
+	c.logger.Warn("disconnected", "event", "disconnect", "reason", r.String())
+	c.publishLifecycle(EventDisconnected, Event{Reason: r})
+
+	select {
+	case c.OnDisconnect <- r:
+	default:
+	}
+}
@@ -0,0 +1,75 @@
+package hipchat
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+)
+
+// NewClientFromEnv creates a Client configured by environment variables:
+//
+//	HIPCHAT_USER             user name (required)
+//	HIPCHAT_PASSWORD         password, or HIPCHAT_TOKEN (one is required)
+//	HIPCHAT_RESOURCE         resource, a unique "bot-<hex>" if unset
+//	HIPCHAT_HOST             XMPP host, optionally with a port
+//	HIPCHAT_CONF_HOST        conference host
+//	HIPCHAT_TLS_CA_FILE      PEM file with the CAs trusted for the server
+//	HIPCHAT_TLS_SERVER_NAME  name the server certificate is verified against
+//
+// The options are applied as with NewClient.
+func NewClientFromEnv(options ...Option) (*Client, error) {
+	user := os.Getenv("HIPCHAT_USER")
+	if user == "" {
+		return nil, errors.New("HIPCHAT_USER is not set")
+	}
+	pass := os.Getenv("HIPCHAT_PASSWORD")
+	if pass == "" {
+		pass = os.Getenv("HIPCHAT_TOKEN")
+	}
+	if pass == "" {
+		return nil, errors.New("neither HIPCHAT_PASSWORD nor HIPCHAT_TOKEN is set")
+	}
+	resource := os.Getenv("HIPCHAT_RESOURCE")
+
+	config, err := configFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithConfig(user, pass, resource, config, options...)
+}
+
+func configFromEnv() (Config, error) {
+	var config Config
+
+	if host := os.Getenv("HIPCHAT_HOST"); host != "" {
+		config.XMPPHost = host
+		if name, port, err := net.SplitHostPort(host); err == nil {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				return config, errors.New("invalid port in HIPCHAT_HOST")
+			}
+			config.XMPPHost, config.Port = name, p
+		}
+	}
+	config.ConfHost = os.Getenv("HIPCHAT_CONF_HOST")
+
+	caFile := os.Getenv("HIPCHAT_TLS_CA_FILE")
+	serverName := os.Getenv("HIPCHAT_TLS_SERVER_NAME")
+	if caFile != "" || serverName != "" {
+		config.TLS = &tls.Config{ServerName: serverName}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return config, err
+		}
+		config.TLS.RootCAs = x509.NewCertPool()
+		if !config.TLS.RootCAs.AppendCertsFromPEM(pem) {
+			return config, errors.New("no certificates found in HIPCHAT_TLS_CA_FILE")
+		}
+	}
+	return config, nil
+}
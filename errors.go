@@ -0,0 +1,72 @@
+package hipchat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pyalex/hipchat/xmpp"
+)
+
+var (
+	// ErrNotConnected is returned when the client's connection is closed.
+	ErrNotConnected = errors.New("not connected")
+	// ErrAuthFailed is returned by NewClient when the credentials are
+	// rejected.
+	ErrAuthFailed = errors.New("could not authenticate")
+	// ErrRoomNotFound matches stanza errors reporting that a room does not
+	// exist.
+	ErrRoomNotFound = errors.New("room not found")
+	// ErrTimeout is returned when the server does not answer in time. It wraps
+	// context.DeadlineExceeded.
+	ErrTimeout = errors.New("timed out waiting for the server")
+	// ErrRateLimited matches stanza errors reporting that the client is sending
+	// too much.
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// A StanzaError is an error the server sent in reply to a stanza. From is
+// the entity that returned the error, Type tells whether the stanza may be
+// retried ("cancel", "continue", "modify", "auth" or "wait") and Condition is
+// the defined condition, e.g. "item-not-found".
+type StanzaError struct {
+	From      string
+	Type      string
+	Condition string
+	Text      string
+}
+
+func (e *StanzaError) Error() string {
+	if e.Text != "" {
+		return e.Condition + ": " + e.Text
+	}
+	return e.Condition
+}
+
+// Is makes item-not-found conditions match ErrRoomNotFound and rate limit
+// conditions match ErrRateLimited.
+func (e *StanzaError) Is(target error) bool {
+	switch target {
+	case ErrRoomNotFound:
+		return e.Condition == "item-not-found"
+	case ErrRateLimited:
+		return isRateLimit(e)
+	}
+	return false
+}
+
+func newStanzaError(from string, e *xmpp.Error) *StanzaError {
+	if e == nil {
+		return &StanzaError{From: from, Condition: "undefined-condition"}
+	}
+	return &StanzaError{From: from, Type: e.Type, Condition: e.Condition(), Text: e.Text()}
+}
+
+// contextError returns the error of a done context, turning deadlines into
+// ErrTimeout.
+func contextError(ctx context.Context) error {
+	err := ctx.Err()
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
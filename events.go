@@ -0,0 +1,159 @@
+package hipchat
+
+import (
+	"context"
+	"github.com/pyalex/hipchat/xmpp"
+)
+
+// A Topic is a kind of event published inside a client, see Subscribe.
+type Topic string
+
+// The topics events are published on.
+const (
+	TopicMessage   Topic = "message"   // live messages, before they are delivered
+	TopicPresence  Topic = "presence"  // presences, before they are delivered
+	TopicIQ        Topic = "iq"        // every iq received, before it is handled
+	TopicError     Topic = "error"     // stanza errors, before they are delivered
+	TopicLifecycle Topic = "lifecycle" // the milestones named by the Event* constants
+
+	// the topics of stanzas only the client itself handles
+	topicReplayed Topic = "replayed" // messages replayed on joining a room
+	topicArchived Topic = "archived" // messages returned by an archive query
+	topicFin      Topic = "fin"      // the end of an archive query
+	topicInvite   Topic = "invite"   // invitations to a room
+)
+
+// The names of lifecycle events.
+const (
+	EventConnected     = "connected"     // Addr is the server connected to
+	EventAuthenticated = "authenticated" // the resource is bound
+	EventJoined        = "joined"        // Room is the room joined
+	EventDisconnected  = "disconnected"  // Reason tells why
+	EventReconnected   = "reconnected"   // the rooms are rejoined
+	EventClosed        = "closed"        // the channels are closed
+)
+
+// An Event is something that happened to a client. Topic tells which of the
+// other fields are set.
+type Event struct {
+	Topic    Topic
+	Name     string // lifecycle events only
+	Message  *Message
+	Presence *Presence
+	IQ       *xmpp.IncomingIQ
+	Err      *StanzaError
+	Reason   *DisconnectReason
+	Room     RoomID
+	Addr     string
+
+	id     string // of the stanza, or of the archive query for history
+	fin    *xmpp.Fin
+	invite *Room
+}
+
+// A subscriber is a function subscribed to a topic.
+type subscriber struct {
+	fn func(*Event)
+}
+
+// Subscribe makes the client call fn with every event published on the topic
+// and returns a function ending the subscription. Subscribers are called in
+// the order they subscribed, from the goroutine publishing the event, often
+// the one reading from the connection, so they must return quickly and must
+// not modify the event. Panics are recovered and logged.
+func (c *Client) Subscribe(topic Topic, fn func(*Event)) (unsubscribe func()) {
+	s := &subscriber{fn: fn}
+
+	c.subscribersMutex.Lock()
+	defer c.subscribersMutex.Unlock()
+	c.subscribers[topic] = append(c.subscribers[topic], s)
+
+	return func() {
+		c.subscribersMutex.Lock()
+		defer c.subscribersMutex.Unlock()
+		subscribers := c.subscribers[topic]
+		for i := range subscribers {
+			if subscribers[i] == s {
+				c.subscribers[topic] = append(subscribers[:i:i], subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// handle makes fn the client's own handler of the topic. Every stanza read
+// by listen is published, and the handlers, called after the subscribers,
+// are what resolve requests and deliver it on the channels.
+func (c *Client) handle(topic Topic, fn func(*Event)) {
+	c.subscribersMutex.Lock()
+	defer c.subscribersMutex.Unlock()
+	c.handlers[topic] = fn
+}
+
+// publish calls the subscribers of the event's topic, then its handler.
+func (c *Client) publish(e *Event) {
+	c.subscribersMutex.RLock()
+	subscribers := c.subscribers[e.Topic]
+	handler := c.handlers[e.Topic]
+	c.subscribersMutex.RUnlock()
+
+	for _, s := range subscribers {
+		c.protect(string(e.Topic), func() { s.fn(e) })
+	}
+	if handler != nil {
+		handler(e)
+	}
+}
+
+// publishLifecycle publishes the lifecycle event with the given name.
+func (c *Client) publishLifecycle(name string, e Event) {
+	e.Topic = TopicLifecycle
+	e.Name = name
+	c.publish(&e)
+}
+
+// subscribeInternal subscribes the parts of the client that follow events:
+// presence tracking, replies awaited with AwaitReply, statistics, metrics and
+// the Hooks. It also registers the handlers of the stanzas read by listen.
+func (c *Client) subscribeInternal() {
+	c.handle(TopicIQ, c.handleIQ)
+	c.handle(TopicError, func(e *Event) {
+		c.reportError(e.id, e.Err)
+	})
+	c.handle(TopicPresence, func(e *Event) {
+		c.resolveAck(e.id, nil)
+		c.deliverPresence(e.Presence)
+	})
+	// a message not delivered because the client closed is dropped, and
+	// listen ends on its next read
+	deliver := func(e *Event) {
+		c.resolveAck(e.id, nil)
+		c.deliver(context.Background(), e.Message)
+	}
+	c.handle(TopicMessage, deliver)
+	c.handle(topicReplayed, deliver)
+	c.handle(topicArchived, func(e *Event) {
+		c.deliverHistory(e.id, e.Message)
+	})
+	c.handle(topicFin, func(e *Event) {
+		c.finishHistory(e.id, e.fin, nil)
+	})
+	c.handle(topicInvite, func(e *Event) {
+		c.deliverInvite(e.invite)
+	})
+
+	c.Subscribe(TopicPresence, func(e *Event) {
+		c.trackPresence(e.Presence)
+	})
+	c.Subscribe(TopicMessage, func(e *Event) {
+		c.messagesReceived.Add(1)
+		c.resolveReplies(e.Message)
+	})
+	c.Subscribe(TopicLifecycle, func(e *Event) {
+		if e.Name == EventReconnected {
+			c.reconnects.Add(1)
+			c.metrics.IncCounter("hipchat_reconnects_total", nil)
+		}
+	})
+	c.subscribeHooks()
+}
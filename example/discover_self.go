@@ -20,7 +20,7 @@ func main() {
 	var mentionName string
 
 	for _, user := range client.Users() {
-		if user.Id == client.Id {
+		if user.Id.Bare() == client.Id {
 			fullName = user.Name
 			mentionName = user.MentionName
 			break
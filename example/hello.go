@@ -9,7 +9,7 @@ func main() {
 	user := "11111_22222"
 	pass := "secret"
 	resource := "bot"
-	roomJid := "11111_room_name@conf.hipchat.com"
+	roomJid := hipchat.RoomID("11111_room_name@conf.hipchat.com")
 	fullName := "Some Bot"
 
 	client, err := hipchat.NewClient(user, pass, resource)
@@ -18,7 +18,7 @@ func main() {
 		return
 	}
 
-	client.Status("chat")
+	client.SetStatus(hipchat.ShowChat, "")
 	client.Join(roomJid, fullName)
 	client.Say(roomJid, fullName, "Hello")
 	select {}
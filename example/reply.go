@@ -10,7 +10,7 @@ func main() {
 	user := "11111_22222"
 	pass := "secret"
 	resource := "bot"
-	roomJid := "11111_room_name@conf.hipchat.com"
+	roomJid := hipchat.RoomID("11111_room_name@conf.hipchat.com")
 	fullName := "Some Bot"
 	mentionName := "SomeBot"
 
@@ -20,7 +20,7 @@ func main() {
 		return
 	}
 
-	client.Status("chat")
+	client.SetStatus(hipchat.ShowChat, "")
 	client.Join(roomJid, fullName)
 	for message := range client.Messages() {
 		if strings.HasPrefix(message.Body, "@"+mentionName) {
@@ -0,0 +1,96 @@
+package hipchat
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/pyalex/hipchat/xmpp"
+	"io"
+	"strings"
+	"time"
+)
+
+// An ExportFormat selects how ExportHistory writes messages.
+type ExportFormat int
+
+const (
+	// ExportJSON writes one JSON object per message and line (JSON Lines).
+	ExportJSON ExportFormat = iota
+	// ExportCSV writes a header row followed by one row per message.
+	ExportCSV
+)
+
+type exportRecord struct {
+	Stamp       string             `json:"timestamp"`
+	From        string             `json:"sender"`
+	Body        string             `json:"body"`
+	Attachments []exportAttachment `json:"attachments,omitempty"`
+}
+
+type exportAttachment struct {
+	URL          string `json:"url"`
+	Filename     string `json:"filename"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+// ExportHistory pages through the whole archive of a room and writes every
+// message (timestamp, sender, body and attachments) to w in the given format.
+func (c *Client) ExportHistory(ctx context.Context, w io.Writer, roomJid string, format ExportFormat) error {
+	var write func(*exportRecord) error
+	var flush func() error
+
+	switch format {
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		write = func(r *exportRecord) error {
+			return enc.Encode(r)
+		}
+		flush = func() error {
+			return nil
+		}
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		write = func(r *exportRecord) error {
+			urls := make([]string, len(r.Attachments))
+			for i, a := range r.Attachments {
+				urls[i] = a.URL
+			}
+			return cw.Write([]string{r.Stamp, r.From, r.Body, strings.Join(urls, " ")})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		if err := cw.Write([]string{"timestamp", "sender", "body", "attachments"}); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unknown export format")
+	}
+
+	err := c.walkHistory(ctx, xmpp.HistoryQuery{With: roomJid}, func(m *Message) error {
+		return write(newExportRecord(m))
+	})
+	if err != nil {
+		flush()
+		return err
+	}
+	return flush()
+}
+
+func newExportRecord(m *Message) *exportRecord {
+	r := &exportRecord{
+		Stamp: m.Stamp.UTC().Format(time.RFC3339),
+		From:  m.From.String(),
+		Body:  m.Body,
+	}
+	for _, a := range m.Attachments {
+		r.Attachments = append(r.Attachments, exportAttachment{
+			URL:          a.ImageURL,
+			Filename:     a.ImageFilename,
+			ThumbnailURL: a.ThumbnailURL,
+		})
+	}
+	return r
+}
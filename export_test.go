@@ -0,0 +1,4 @@
+package hipchat
+
+// ParseStamp exports parseStamp to the tests of package hipchat_test.
+var ParseStamp = parseStamp
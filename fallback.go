@@ -0,0 +1,129 @@
+package hipchat
+
+import (
+	"context"
+	"errors"
+	"github.com/pyalex/hipchat/xmpp"
+	"time"
+)
+
+// ErrUnknownPresence is returned by Presence when neither the XMPP session
+// nor the fallback tell the presence of a user.
+var ErrUnknownPresence = errors.New("presence unknown")
+
+// A Fallback serves reads over another transport when XMPP can not, see
+// WithFallback. The rest package's Client implements it.
+type Fallback interface {
+	// UserPresence returns the current presence of the user.
+	UserPresence(ctx context.Context, user UserID) (*Presence, error)
+	// RoomHistory returns the first limit messages sent to the room since
+	// start, oldest first, like an archive query.
+	RoomHistory(ctx context.Context, room RoomID, start time.Time, limit int) ([]Message, error)
+	// SetRoomTopic sets the topic of the room.
+	SetRoomTopic(ctx context.Context, room RoomID, topic string) error
+	// SendRoomMessage sends a plain text message to the room.
+	SendRoomMessage(ctx context.Context, room RoomID, body string) error
+	// Rooms returns the rooms of the group.
+	Rooms(ctx context.Context) ([]*Room, error)
+}
+
+// WithFallback sets what the client falls back to when XMPP can not serve a
+// call: Presence and SetTopic while the client is not connected and
+// LoadHistory when the archive query of a room fails or times out. It is
+// usually a client of the REST API:
+//
+//	api, err := rest.NewClient(token)
+//	...
+//	client, err := hipchat.NewClient(user, pass, resource, hipchat.WithFallback(api))
+func WithFallback(f Fallback) Option {
+	return func(c *Client) error {
+		c.fallback = f
+		return nil
+	}
+}
+
+// trackPresence remembers the last presence sent by a user. Presences of
+// room occupants are not tracked.
+func (c *Client) trackPresence(p *Presence) {
+	if p.From.Local == "" || c.isRoom(p.From.Full()) {
+		return
+	}
+
+	c.presencesMutex.Lock()
+	defer c.presencesMutex.Unlock()
+	c.presences[p.From.Bare()] = p
+}
+
+// Presence returns the current presence of the user. While connected it is
+// the last presence the user sent to the client; while the client is not
+// connected, or has not heard from the user, it is read from the fallback set
+// with WithFallback. Presence returns ErrUnknownPresence if neither knows.
+func (c *Client) Presence(ctx context.Context, user UserID) (*Presence, error) {
+	if c.Connected() {
+		c.presencesMutex.Lock()
+		p, ok := c.presences[string(user)]
+		c.presencesMutex.Unlock()
+		if ok {
+			return p, nil
+		}
+	}
+
+	if c.fallback == nil {
+		return nil, ErrUnknownPresence
+	}
+	p, err := c.fallback.UserPresence(ctx, user)
+	if err != nil {
+		c.logger.Debug("fallback failed", "event", "fallback", "op", "presence", "user", user, "error", err)
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetTopic sets the topic of the room. It is sent over XMPP while the client
+// is connected and over the fallback set with WithFallback otherwise.
+func (c *Client) SetTopic(ctx context.Context, roomId RoomID, topic string) error {
+	if c.Connected() || c.fallback == nil {
+		if err := c.beginSend(); err != nil {
+			return err
+		}
+		defer c.sends.Done()
+		if err := c.throttle(ctx); err != nil {
+			return err
+		}
+		return c.conn().MUCSubject(xmpp.ID(), string(roomId), c.Id+"/"+c.Resource, topic)
+	}
+	return c.fallback.SetRoomTopic(ctx, roomId, topic)
+}
+
+// historyFallback loads the room history from the fallback after the archive
+// query failed or timed out with err, or returns err if there is no fallback
+// or ctx, the caller's context, is done.
+func (c *Client) historyFallback(ctx context.Context, jid string, start time.Time, limit int, err error) (*HistoryPage, error) {
+	if c.fallback == nil || !c.isRoom(jid) || ctx.Err() != nil {
+		return nil, err
+	}
+
+	c.logger.Info("loading history from fallback", "event", "fallback", "op", "history", "room", jid, "error", err)
+	messages, ferr := c.fallbackHistory(ctx, c.fallback, RoomID(jid), start, limit)
+	if ferr != nil {
+		c.logger.Debug("fallback failed", "event", "fallback", "op", "history", "room", jid, "error", ferr)
+		return nil, err
+	}
+	return &HistoryPage{Messages: messages, Count: -1, Complete: len(messages) < limit}, nil
+}
+
+// fallbackHistory loads the room history from f and tags the messages like
+// the ones loaded from the archive.
+func (c *Client) fallbackHistory(ctx context.Context, f Fallback, room RoomID, start time.Time, limit int) ([]Message, error) {
+	messages, err := f.RoomHistory(ctx, room, start, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		m := &messages[i]
+		m.RoomName = c.roomName(m.RoomJID.Bare())
+		m.IsHistorical = true
+		c.validateAttachments(ctx, m)
+	}
+	return messages, nil
+}
@@ -0,0 +1,96 @@
+package hipchat
+
+// A Presence is the availability of a user or room occupant as announced by
+// the server. Type is "unavailable" when they leave and empty otherwise.
+type Presence struct {
+	From   JID
+	To     JID
+	Type   string
+	Show   string
+	Status string
+}
+
+// OnMessage registers a function called for every incoming message. Once a
+// function is registered the Messages channel must not be read. All
+// registered functions are called in turn for each message, from a goroutine
+// of their own or from the workers set up with WithHandler.
+func (c *Client) OnMessage(h func(*Message)) {
+	c.handlersMutex.Lock()
+	c.messageHandlers = append(c.messageHandlers, h)
+	c.handlersMutex.Unlock()
+
+	c.dispatchMessages.Do(func() {
+		c.goLabeled("dispatcher", "", c.dispatch)
+	})
+}
+
+// OnInvite registers a function called whenever the client is invited to a
+// room. Once a function is registered the Invites channel must not be read.
+func (c *Client) OnInvite(h func(*Room)) {
+	c.handlersMutex.Lock()
+	c.inviteHandlers = append(c.inviteHandlers, h)
+	c.handlersMutex.Unlock()
+
+	c.dispatchInvites.Do(func() {
+		c.goLabeled("invites", "", func() {
+			for r := range c.receivedInvites {
+				c.handlersMutex.RLock()
+				handlers := c.inviteHandlers
+				c.handlersMutex.RUnlock()
+
+				for _, h := range handlers {
+					c.protect("invite", func() { h(r) })
+				}
+			}
+		})
+	})
+}
+
+// OnPresence registers a function called for every presence the server sends,
+// e.g. users joining and leaving the rooms the client is in.
+func (c *Client) OnPresence(h func(*Presence)) {
+	c.handlersMutex.Lock()
+	c.presenceHandlers = append(c.presenceHandlers, h)
+	c.handlersMutex.Unlock()
+
+	c.dispatchPresence.Do(func() {
+		c.goLabeled("presences", "", func() {
+			for p := range c.receivedPresence {
+				c.handlersMutex.RLock()
+				handlers := c.presenceHandlers
+				c.handlersMutex.RUnlock()
+
+				for _, h := range handlers {
+					c.protect("presence", func() { h(p) })
+				}
+			}
+		})
+	})
+}
+
+// dispatch calls the message handlers for every incoming message until the
+// client is closed.
+func (c *Client) dispatch() {
+	for m := range c.receivedMessage {
+		c.busy.Add(1)
+		c.handlersMutex.RLock()
+		handlers := c.messageHandlers
+		c.handlersMutex.RUnlock()
+
+		for _, h := range handlers {
+			c.protect("message", func() { h(m) })
+		}
+		c.busy.Add(-1)
+	}
+}
+
+// protect calls fn and logs it if fn panics, so a faulty handler does not
+// stop the dispatch.
+func (c *Client) protect(event string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Error("handler panicked", "event", "handler_panic", "handler", event, "error", r)
+		}
+	}()
+	fn()
+}
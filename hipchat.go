@@ -1,21 +1,30 @@
 package hipchat
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"github.com/pyalex/hipchat/xmpp"
-	"log"
-	"regexp"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Host and Conf are the XMPP and conference hosts used by clients whose Config
+// does not name their own.
 var (
-	Host           = "chat.hipchat.com"
-	Conf           = "conf.hipchat.com"
-	regexpImage, _ = regexp.Compile("<img src='([^']+)' title='([^']+)' longdesc='([^']+)##([^']+)'")
+	Host = "chat.hipchat.com"
+	Conf = "conf.hipchat.com"
 )
 
 // A Client represents the connection between the application to the HipChat
-// service.
+// service. A Client is safe for concurrent use by multiple goroutines.
 type Client struct {
 	Username string
 	Password string
@@ -24,35 +33,148 @@ type Client struct {
 
 	OnReconnect chan bool
 
-	// private
-	mentionNames    map[string]string
-	connection      *xmpp.Conn
-	receivedUsers   chan []*User
-	receivedRooms   chan []*Room
-	receivedMessage chan *Message
+	// OnDisconnect receives the reason whenever the connection is lost, before
+	// any attempt to reestablish it. Reasons nobody is waiting for are dropped
+	// once the channel's buffer of one is full.
+	OnDisconnect chan *DisconnectReason
 
-	messageBuffer   []Message
-	recievedHistory chan []Message
-	historyLock     chan bool
+	// HistoryStore, if set, caches the results of LoadHistory. Set it before
+	// sharing the client between goroutines.
+	HistoryStore HistoryStore
 
-	alive  chan bool
-	Closed bool
+	// private
+	config           Config
+	jid              JID
+	jidMutex         sync.Mutex
+	connection       *xmpp.Conn
+	connMutex        sync.RWMutex
+	receivedInvites  chan *Room
+	receivedMessage  chan *Message
+	receivedErrors   chan *StanzaError
+	receivedPresence chan *Presence
+	rateLimits       chan *RateLimited
+	seen             *seenSet
+
+	roomNames      map[string]string
+	roomNamesMutex sync.RWMutex
+
+	joined      map[RoomID]string
+	joinedMutex sync.Mutex
+
+	disconnectReason *DisconnectReason
+	disconnectMutex  sync.Mutex
+	online           atomic.Bool
+
+	iqs     map[string]chan *xmpp.IncomingIQ
+	iqMutex sync.Mutex
+
+	acks      map[string]chan error
+	acksMutex sync.Mutex
+
+	replies      []*replyWaiter
+	repliesMutex sync.Mutex
+
+	presences      map[string]*Presence // by bare JID, see trackPresence
+	presencesMutex sync.Mutex
+	fallback       Fallback
+	preferred      Transport
+
+	history      map[string]*historyQuery
+	historyMutex sync.Mutex
+	archiveNs    string
+
+	deadTimeout       time.Duration
+	keepAliveInterval time.Duration
+	lastReceived      atomic.Int64
+	reconnectGate     atomic.Pointer[func(done <-chan struct{}) bool] // set by Manager
+
+	started          time.Time
+	messagesReceived atomic.Uint64
+	messagesSent     atomic.Uint64
+	bytesReceived    atomic.Int64 // by connections replaced so far
+	bytesSent        atomic.Int64
+	reconnects       atomic.Uint64
+
+	done         chan struct{}
+	closed       atomic.Bool
+	closeOnce    sync.Once
+	deliverMutex sync.RWMutex
+	overflow     OverflowPolicy
+	dropped      atomic.Uint64
+
+	throttledUntil atomic.Int64
+
+	draining  bool // set by Shutdown, guarded by sendMutex
+	sendMutex sync.Mutex
+	sends     sync.WaitGroup
+	busy      atomic.Int64 // messages being handled by the dispatchers
+
+	handlersMutex    sync.RWMutex
+	messageHandlers  []func(*Message)
+	inviteHandlers   []func(*Room)
+	presenceHandlers []func(*Presence)
+	workers          int
+	dispatchMessages sync.Once
+	dispatchInvites  sync.Once
+	dispatchPresence sync.Once
+
+	tlsConfig  *tls.Config
+	httpClient *http.Client
+	hooks      Hooks
+	plainText  bool
+	metrics    MetricsCollector
+	tracer     Tracer
+	validators []AttachmentValidator
+
+	attachmentCache *AttachmentCache
+
+	subscribers      map[Topic][]*subscriber
+	handlers         map[Topic]func(*Event) // see handle
+	subscribersMutex sync.RWMutex
+
+	uploadJid   string // the HTTP upload service, see uploadService
+	uploadMutex sync.Mutex
+
+	logger  *slog.Logger
+	wireLog io.Writer
 }
 
 // A Message represents a message received from HipChat.
 type Message struct {
-	From        string
-	To          string
+	From        JID
+	To          JID
 	Body        string
 	MentionName string
-	Stamp       time.Time
+	Stamp       time.Time // zero if the server sent an invalid timestamp
 	Mid         string
 	Attachments []xmpp.Attachment
+
+	// RoomJID is the bare JID of the room a room message was sent to and
+	// zero for private messages. RoomName is the name of the room if the
+	// client has seen it in the room list.
+	RoomJID  JID
+	RoomName string
+
+	// SenderNick is the nickname of the sender of a room message. SenderJID
+	// is the JID of the sender if the server tells, which it always does for
+	// private messages.
+	SenderNick string
+	SenderJID  JID
+
+	// IsHistorical is set on messages replayed from the archive.
+	IsHistorical bool
+
+	// Card is the card of a notification sent with a card, if any.
+	Card *Card
+
+	// RejectedAttachments are the attachments removed from the message by
+	// the validators set with WithAttachmentValidator.
+	RejectedAttachments []RejectedAttachment
 }
 
 // A User represents a member of the HipChat service.
 type User struct {
-	Id          string
+	Id          JID
 	Name        string
 	MentionName string
 }
@@ -60,208 +182,582 @@ type User struct {
 // A Room represents a room in HipChat the Client can join to communicate with
 // other members..
 type Room struct {
-	Id    string
+	Id    JID
 	Name  string
 	Owner string
 	Topic string
 }
 
 // NewClient creates a new Client connection from the user name, password and
-// resource passed to it.
-func NewClient(user, pass, resource string) (*Client, error) {
-	connection, err := xmpp.Dial(Host)
+// resource passed to it. The options are applied before connecting.
+func NewClient(user, pass, resource string, options ...Option) (*Client, error) {
+	return NewClientWithConfig(user, pass, resource, Config{}, options...)
+}
 
+// NewClientWithConfig is like NewClient but connects to the server selected
+// by config. An empty resource is replaced by a unique one of the form
+// "bot-<hex>", so several instances of the same bot do not kick each other
+// off.
+func NewClientWithConfig(user, pass, resource string, config Config, options ...Option) (*Client, error) {
+	config = config.withDefaults()
+	if resource == "" {
+		resource = "bot-" + xmpp.ID()
+	}
 	c := &Client{
 		Username: user,
 		Password: pass,
 		Resource: resource,
-		Id:       user + "@" + Host,
+		Id:       user + "@" + config.XMPPHost,
 
 		// private
-		connection:      connection,
-		mentionNames:    make(map[string]string),
-		receivedUsers:   make(chan []*User),
-		receivedRooms:   make(chan []*Room, 10),
-		receivedMessage: make(chan *Message, 20),
-		OnReconnect:     make(chan bool),
-
-		messageBuffer:   make([]Message, 0),
-		recievedHistory: make(chan []Message),
-		historyLock:     make(chan bool, 1),
+		receivedInvites:  make(chan *Room, 10),
+		receivedErrors:   make(chan *StanzaError, 10),
+		receivedPresence: make(chan *Presence, 10),
+		rateLimits:       make(chan *RateLimited, 1),
+		receivedMessage:  make(chan *Message, 20),
+		seen:             newSeenSet(seenSize),
+		roomNames:        make(map[string]string),
+		OnReconnect:      make(chan bool),
+		OnDisconnect:     make(chan *DisconnectReason, 1),
+
+		joined: make(map[RoomID]string),
+
+		iqs:  make(map[string]chan *xmpp.IncomingIQ),
+		acks: make(map[string]chan error),
+
+		presences:   make(map[string]*Presence),
+		subscribers: make(map[Topic][]*subscriber),
+		handlers:    make(map[Topic]func(*Event)),
+
+		history:   make(map[string]*historyQuery),
+		archiveNs: xmpp.NsMam,
+
+		config:            config,
+		tlsConfig:         config.TLS,
+		httpClient:        http.DefaultClient,
+		metrics:           NopMetrics{},
+		started:           time.Now(),
+		deadTimeout:       config.Timeouts.Dead,
+		keepAliveInterval: 2 * time.Minute,
+		done:              make(chan struct{}),
+		logger:            slog.Default(),
+	}
 
-		alive:  make(chan bool),
-		Closed: false,
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return c, err
+		}
+	}
+	if c.keepAliveInterval >= c.deadTimeout {
+		return c, errors.New("keepalive interval must be shorter than the dead timeout")
 	}
+	c.subscribeInternal()
 
+	connection, err := c.dial(config.addr())
+	c.connection = connection
 	if err != nil {
 		return c, err
 	}
+	c.publishLifecycle(EventConnected, Event{Addr: config.addr()})
 
 	err = c.authenticate()
 	if err != nil {
 		return c, err
 	}
-
-	go c.listen()
+	c.touch()
+	c.online.Store(true)
+	c.logger.Info("connected", "event", "connect", "host", config.XMPPHost, "jid", c.Id)
+	c.publishLifecycle(EventAuthenticated, Event{})
+
+	if c.workers > 0 {
+		c.dispatchMessages.Do(func() {
+			for i := 0; i < c.workers; i++ {
+				c.goLabeled("dispatcher", "", c.dispatch)
+			}
+		})
+	}
+	c.goLabeled("listener", "", c.listen)
+	c.goLabeled("keepalive", "", c.keepAlive)
+	c.goLabeled("watchdog", "", c.watchdog)
+	c.goLabeled("discovery", "", c.discoverArchive)
+	c.goLabeled("discovery", "", func() { c.Rooms() }) // fills the room name cache
 	return c, nil
 }
 
 // Messages returns a read-only channel of Message structs. After joining a
-// room, messages will be sent on the channel.
+// room, messages will be sent on the channel. The channel must not be read
+// when a handler was set with WithHandler or OnMessage.
 func (c *Client) Messages() <-chan *Message {
 	return c.receivedMessage
 }
 
-// Rooms returns an slice of Room structs.
+// Invites returns a read-only channel of Room structs. When the client is
+// invited to a room, the room is sent on the channel.
+func (c *Client) Invites() <-chan *Room {
+	return c.receivedInvites
+}
+
+// Rooms returns an slice of Room structs. It returns nil if the server does
+// not answer within the IQ timeout.
 func (c *Client) Rooms() []*Room {
-	c.requestRooms()
-	return <-c.receivedRooms
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeouts.IQ)
+	defer cancel()
+
+	rooms, _ := c.RoomsContext(ctx)
+	return rooms
+}
+
+// RoomsContext is like Rooms but waits for the server until ctx is done and
+// reports why the rooms could not be retrieved.
+func (c *Client) RoomsContext(ctx context.Context) ([]*Room, error) {
+	return c.requestRooms(ctx)
 }
 
-// Users returns a slice of User structs.
+// Users returns a slice of User structs. It returns nil if the server does
+// not answer within the IQ timeout.
 func (c *Client) Users() []*User {
-	c.requestUsers()
-	return <-c.receivedUsers
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeouts.IQ)
+	defer cancel()
+
+	users, _ := c.UsersContext(ctx)
+	return users
+}
+
+// UsersContext is like Users but waits for the server until ctx is done and
+// reports why the users could not be retrieved.
+func (c *Client) UsersContext(ctx context.Context) ([]*User, error) {
+	return c.requestUsers(ctx)
+}
+
+// Show is the availability announced by SetStatus.
+type Show string
+
+// The availabilities HipChat understands.
+const (
+	ShowChat Show = "chat"
+	ShowAway Show = "away"
+	ShowXA   Show = "xa" // extended away, i.e. idle
+	ShowDND  Show = "dnd"
+)
+
+// SetStatus tells HipChat whether the client is available to chat, away, idle
+// or busy, along with an optional status message. It rejects availabilities
+// other than the Show constants, which the server would silently ignore.
+func (c *Client) SetStatus(show Show, message string) error {
+	switch show {
+	case ShowChat, ShowAway, ShowXA, ShowDND:
+	default:
+		return fmt.Errorf("invalid show %q", show)
+	}
+	if err := c.beginSend(); err != nil {
+		return err
+	}
+	defer c.sends.Done()
+	if err := c.throttle(context.Background()); err != nil {
+		return err
+	}
+	return c.conn().PresenceStatus(c.Id, string(show), message)
 }
 
 // Status sends a string to HipChat to indicate whether the client is available
 // to chat, away or idle.
-func (c *Client) Status(s string) {
-	c.connection.Presence(c.Id, s)
+//
+// Deprecated: Use SetStatus, which validates the availability.
+func (c *Client) Status(s string) error {
+	if err := c.beginSend(); err != nil {
+		return err
+	}
+	defer c.sends.Done()
+	if err := c.throttle(context.Background()); err != nil {
+		return err
+	}
+	return c.conn().Presence(c.Id, s)
 }
 
 // Join accepts the room id and the name used to display the client in the
 // room.
-func (c *Client) Join(roomId, resource string, history int) {
-	c.connection.MUCPresence(roomId+"/"+resource, c.Id, history)
+func (c *Client) Join(roomId RoomID, resource string, history int) error {
+	if err := c.beginSend(); err != nil {
+		return err
+	}
+	defer c.sends.Done()
+	if err := c.conn().MUCPresence(xmpp.ID(), string(roomId)+"/"+resource, c.Id, history); err != nil {
+		return err
+	}
+
+	c.joinedMutex.Lock()
+	c.joined[roomId] = resource
+	c.joinedMutex.Unlock()
+
+	c.logger.Info("joined room", "event", "join", "room", roomId)
+	c.publishLifecycle(EventJoined, Event{Room: roomId})
+	return nil
 }
 
-func (c *Client) Leave(roomId, resource string) {
-	c.connection.MUCUnavailable(roomId+"/"+resource, c.Id)
+// JoinContext is like Join but waits until the room confirms the join or ctx
+// is done. It returns the StanzaError if the room can not be joined, which
+// matches ErrRoomNotFound if the room does not exist.
+func (c *Client) JoinContext(ctx context.Context, roomId RoomID, resource string, history int) error {
+	if err := c.beginSend(); err != nil {
+		return err
+	}
+	defer c.sends.Done()
+	err := c.awaitAck(ctx, func(id string) error {
+		return c.conn().MUCPresence(id, string(roomId)+"/"+resource, c.Id, history)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.joinedMutex.Lock()
+	c.joined[roomId] = resource
+	c.joinedMutex.Unlock()
+
+	c.logger.Info("joined room", "event", "join", "room", roomId)
+	c.publishLifecycle(EventJoined, Event{Room: roomId})
+	return nil
+}
+
+// Leave accepts the room id and the name used to display the client in the
+// room and leaves the room.
+func (c *Client) Leave(roomId RoomID, resource string) error {
+	if c.Closed() {
+		return ErrNotConnected
+	}
+
+	c.joinedMutex.Lock()
+	delete(c.joined, roomId)
+	c.joinedMutex.Unlock()
+
+	c.logger.Info("left room", "event", "leave", "room", roomId)
+
+	return c.conn().MUCUnavailable(string(roomId)+"/"+resource, c.Id)
+}
+
+// JoinedRooms returns the ids of the rooms the client has joined.
+func (c *Client) JoinedRooms() []RoomID {
+	c.joinedMutex.Lock()
+	defer c.joinedMutex.Unlock()
+
+	rooms := make([]RoomID, 0, len(c.joined))
+	for id := range c.joined {
+		rooms = append(rooms, id)
+	}
+	return rooms
+}
+
+// Nick returns the name the client joined the room with, or "" if it has not
+// joined the room.
+func (c *Client) Nick(roomId RoomID) string {
+	c.joinedMutex.Lock()
+	defer c.joinedMutex.Unlock()
+	return c.joined[roomId]
 }
 
 // Say accepts a room id, the name of the client in the room, and the message
-// body and sends the message to the HipChat room.
-func (c *Client) Say(roomId, name, body string, attachments []xmpp.Attachment) {
-	c.connection.MUCSend(roomId, c.Id+"/"+c.Resource, body, attachments)
+// body and sends the message to the HipChat room. If the server rejects the
+// message, the error is sent on the Errors channel.
+func (c *Client) Say(roomId RoomID, name, body string, attachments []xmpp.Attachment) error {
+	if err := c.beginSend(); err != nil {
+		return err
+	}
+	defer c.sends.Done()
+	if err := c.throttle(context.Background()); err != nil {
+		return err
+	}
+	body, attachments = c.withoutHTML(body, attachments)
+	return c.conn().MUCSend(xmpp.ID(), string(roomId), c.Id+"/"+c.Resource, body, attachments)
 }
 
-// KeepAlive is meant to run as a goroutine. It sends a single whitespace
-// character to HipChat every 60 seconds. This keeps the connection from
-// idling after 150 seconds.
-func (c *Client) KeepAlive(nickname string) {
-	go c.AliveChecker(nickname)
-	for _ = range time.Tick(2 * time.Minute) {
-		log.Println("keep alive")
-		c.Join("1_default@"+Conf, nickname, 1)
+// SayAck is like Say but waits until the room echoes the message back. It
+// returns the StanzaError if the server rejects the message.
+func (c *Client) SayAck(ctx context.Context, roomId RoomID, name, body string, attachments []xmpp.Attachment) error {
+	if err := c.beginSend(); err != nil {
+		return err
+	}
+	defer c.sends.Done()
+	if err := c.throttle(ctx); err != nil {
+		return err
+	}
+	body, attachments = c.withoutHTML(body, attachments)
+	ctx, span := c.startSpan(ctx, "hipchat.say", map[string]string{"room": string(roomId)})
+	start := time.Now()
+	err := c.awaitAck(ctx, func(id string) error {
+		return c.conn().MUCSend(id, string(roomId), c.Id+"/"+c.Resource, body, attachments)
+	})
+	span.End(err)
+	if err == nil {
+		c.metrics.ObserveDuration("hipchat_send_duration", time.Since(start), nil)
+	}
+	return err
+}
+
+// withoutHTML moves the attachments into the plain text body if the client
+// was created with WithPlainTextOnly, so no XHTML-IM body is sent.
+func (c *Client) withoutHTML(body string, attachments []xmpp.Attachment) (string, []xmpp.Attachment) {
+	if !c.plainText || len(attachments) == 0 {
+		return body, attachments
+	}
+	for _, a := range attachments {
+		body += "\n" + a.ImageURL
+	}
+	return body, nil
+}
+
+// SendPrivate sends a private chat message to the user. If the server rejects
+// the message, the error is sent on the Errors channel.
+func (c *Client) SendPrivate(to UserID, body string) error {
+	if err := c.beginSend(); err != nil {
+		return err
+	}
+	defer c.sends.Done()
+	if err := c.throttle(context.Background()); err != nil {
+		return err
 	}
+	return c.conn().Send(xmpp.ID(), string(to), c.Id+"/"+c.Resource, body)
+}
+
+// KeepAlive used to keep the connection from idling.
+//
+// Deprecated: NewClient starts the keepalive itself and stops it on Close.
+// KeepAlive only blocks until the client is closed.
+func (c *Client) KeepAlive(nickname string) {
+	<-c.done
 }
 
+// AliveChecker used to close dead connections.
+//
+// Deprecated: NewClient starts a watchdog that detects dead connections,
+// see WithDeadTimeout. AliveChecker only blocks until the client is closed.
 func (c *Client) AliveChecker(nickname string) {
+	<-c.done
+}
+
+// keepAlive sends a single whitespace character and a ping to HipChat every
+// keepalive interval, two minutes by default. This keeps the connection from
+// idling after 150 seconds and makes sure the watchdog hears from a healthy
+// server. It returns when the client is closed.
+func (c *Client) keepAlive() {
+	tick := time.NewTicker(c.keepAliveInterval)
+	defer tick.Stop()
+
 	for {
 		select {
-		case <-c.alive:
-			log.Println("alive")
-			c.Leave("1_default@"+Conf, nickname)
-		case <-time.After(5 * time.Minute):
-			c.connection.Close()
+		case <-c.done:
+			return
+		case <-tick.C:
 		}
+
+		c.logger.Debug("keep alive", "event", "keepalive")
+		c.conn().KeepAlive(c.Id)
+		c.ping()
 	}
 }
 
-func (c *Client) requestRooms() {
-	c.connection.Discover(c.Id, Conf)
+// ping sends a ping to the server and waits up to the IQ timeout for the answer.
+func (c *Client) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeouts.IQ)
+	defer cancel()
+
+	_, err := c.sendIQ(ctx, func(id string) error {
+		return c.conn().Ping(id, c.Id+"/"+c.Resource, c.config.XMPPHost)
+	})
+	return err
 }
 
-func (c *Client) requestUsers() {
-	c.connection.Roster(c.Id, Host)
+func (c *Client) requestRooms(ctx context.Context) ([]*Room, error) {
+	iq, err := c.sendIQ(ctx, func(id string) error {
+		return c.conn().Discover(id, c.Id, c.config.ConfHost)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]*Room, 0)
+	if iq.Query != nil {
+		for _, item := range iq.Query.Items {
+			rooms = append(rooms, &Room{Id: ParseJID(item.Jid), Name: item.Name,
+				Owner: item.Owner, Topic: item.Topic})
+		}
+	}
+	c.cacheRoomNames(rooms)
+	return rooms, nil
 }
 
-func (c *Client) LoadHistory(roomJid string, start time.Time, limit int) []Message {
-	log.Println("History lock acquire start")
-	c.historyLock <- true
-	log.Println("History lock aquire end")
-	c.connection.History(roomJid, start, limit)
-	return <-c.recievedHistory
+func (c *Client) requestUsers(ctx context.Context) ([]*User, error) {
+	iq, err := c.sendIQ(ctx, func(id string) error {
+		return c.conn().Roster(id, c.Id, c.config.XMPPHost)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*User, 0)
+	if iq.Query != nil {
+		for _, item := range iq.Query.Items {
+			users = append(users, &User{Id: ParseJID(item.Jid), Name: item.Name,
+				MentionName: item.MentionName})
+		}
+	}
+	return users, nil
 }
 
 func (c *Client) authenticate() error {
-	c.connection.Stream(c.Id, Host)
+	c.conn().Stream(c.Id, c.config.XMPPHost)
 	for {
-		element, err := c.connection.Next()
+		element, err := c.conn().Next()
 		if err != nil {
 			return err
 		}
 
 		switch element.Name.Local + element.Name.Space {
 		case "stream" + xmpp.NsStream:
-			features := c.connection.Features()
+			features := c.conn().Features()
 			if features.StartTLS != nil {
-				c.connection.StartTLS()
+				c.conn().StartTLS()
 			} else {
 				for _, m := range features.Mechanisms {
 					if m == "PLAIN" {
-						c.connection.Auth(c.Username, c.Password)
+						c.conn().Auth(c.Username, c.Password)
 					}
 				}
 			}
 		case "proceed" + xmpp.NsTLS:
-			c.connection.UseTLS()
-			c.connection.Stream(c.Id, Host)
+			if err := c.conn().UseTLS(c.tlsConfig); err != nil {
+				return err
+			}
+			c.conn().Stream(c.Id, c.config.XMPPHost)
 
 		case "success" + xmpp.NsSASL:
-			c.connection.Stream(c.Id, Host)
-			c.connection.Bind(c.Resource)
-			c.connection.Session()
+			c.conn().Stream(c.Id, c.config.XMPPHost)
+			c.conn().Bind(c.Resource)
+			c.conn().Session()
 
 		case "failure" + xmpp.NsSASL:
-			return errors.New("could not authenticate")
+			c.metrics.IncCounter("hipchat_auth_failures_total", nil)
+			return ErrAuthFailed
 
 		case "iq" + xmpp.NsJabberClient:
-			for _, attr := range element.Attr {
-				if attr.Name.Local == "type" && attr.Value == "result" {
-					return nil // authenticated
-				}
+			iq, err := c.conn().IQ(&element)
+			if err != nil {
+				return err
+			}
+			if iq.Type != "result" {
+				c.metrics.IncCounter("hipchat_auth_failures_total", nil)
+				return ErrAuthFailed
 			}
 
-			return errors.New("could not authenticate")
+			if iq.Bind != nil {
+				c.jidMutex.Lock()
+				c.jid = ParseJID(iq.Bind.JID)
+				c.jidMutex.Unlock()
+			}
+			return nil // authenticated
 		}
 	}
 
 	return errors.New("unexpectedly ended auth loop")
 }
 
-func (c *Client) Close() {
-	log.Println("Closing XMPP connection")
+// JID returns the full JID the server bound the client to.
+func (c *Client) JID() JID {
+	c.jidMutex.Lock()
+	defer c.jidMutex.Unlock()
+	return c.jid
+}
 
-	c.connection.Close()
-	c.Closed = true
+// Closed reports whether the connection to HipChat has been closed.
+func (c *Client) Closed() bool {
+	return c.closed.Load()
+}
+
+// conn returns the current connection. It changes when the client is
+// redirected to another host.
+func (c *Client) conn() *xmpp.Conn {
+	c.connMutex.RLock()
+	defer c.connMutex.RUnlock()
+	return c.connection
+}
+
+func (c *Client) setConn(connection *xmpp.Conn) {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	c.connection = connection
+}
 
-	close(c.receivedMessage)
-	close(c.receivedRooms)
-	close(c.recievedHistory)
-	close(c.receivedUsers)
+// stampLayouts are the timestamp formats accepted in delays: XEP-0082 with
+// optional fractional seconds and a Z or numeric offset, the same without the
+// colon in the offset, and the legacy XEP-0091 format, which is always UTC.
+var stampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"20060102T15:04:05",
 }
 
-func strtotime(str string) time.Time {
-	stamp, err := time.Parse("2006-01-02T15:04:05Z", str)
+func parseStamp(str string) (time.Time, error) {
+	for _, layout := range stampLayouts {
+		if stamp, err := time.Parse(layout, str); err == nil {
+			return stamp, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", str)
+}
+
+// stamp returns the time a message was sent given the stamp of its delay.
+// Messages without a delay were sent just now. Timestamps that can not be
+// parsed are logged and yield the zero time.
+func (c *Client) stamp(str string) time.Time {
+	if str == "" {
+		return time.Now()
+	}
+	stamp, err := parseStamp(str)
 	if err != nil {
-		stamp = time.Now()
+		c.logger.Warn("invalid timestamp", "event", "stanza", "error", err)
 	}
 	return stamp
 }
 
+// getAttachments returns the images in the XHTML body of a message, which
+// come as img elements with the thumbnail in the longdesc attribute, e.g.
+// <img src="…" title="name" longdesc="size##thumbnail URL"/>.
 func getAttachments(htmlBody string) []xmpp.Attachment {
 	if htmlBody == "" {
 		return nil
 	}
 	attachments := make([]xmpp.Attachment, 0)
-	res := regexpImage.FindAllStringSubmatch(htmlBody, -1)
 
-	if res != nil {
-		for _, row := range res {
-			attachments = append(attachments, xmpp.Attachment{row[1], row[2], row[3], row[4]})
+	d := xml.NewDecoder(strings.NewReader(htmlBody))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	for {
+		t, err := d.Token()
+		if err != nil {
+			break
+		}
+		img, ok := t.(xml.StartElement)
+		if !ok || img.Name.Local != "img" {
+			continue
+		}
+
+		var a xmpp.Attachment
+		var longdesc string
+		for _, attr := range img.Attr {
+			switch attr.Name.Local {
+			case "src":
+				a.ImageURL = attr.Value
+			case "title":
+				a.ImageFilename = attr.Value
+			case "longdesc":
+				longdesc = attr.Value
+			}
 		}
+		size, thumbnail, ok := strings.Cut(longdesc, "##")
+		if !ok || a.ImageURL == "" || a.ImageFilename == "" || size == "" || thumbnail == "" {
+			continue
+		}
+		a.ThumbnailSize, a.ThumbnailURL = size, thumbnail
+		attachments = append(attachments, a)
 	}
 	return attachments
 }
@@ -269,82 +765,221 @@ func getAttachments(htmlBody string) []xmpp.Attachment {
 func (c *Client) listen() {
 	defer func() {
 		if x := recover(); x != nil {
-			log.Println("Closed with exception", x)
+			c.logger.Error("listener panicked", "event", "close", "error", x)
 		}
 	}()
 
 	for {
-		element, err := c.connection.Next()
+		element, err := c.conn().Next()
 		if err != nil {
-			c.Closed = true
+			if c.readFailed(err) {
+				continue
+			}
 			return
 		}
+		c.touch()
+		c.metrics.IncCounter("hipchat_stanzas_received_total", map[string]string{"type": element.Name.Local})
 
 		switch element.Name.Local + element.Name.Space {
-		case "iq" + xmpp.NsJabberClient: // rooms and rosters
-			continue
-
-			//query := c.connection.Query()
-			//switch query.XMLName.Space {
-			//case xmpp.NsMucRoom:
-			//	items := make([]*Room, len(query.Items))
-			//	for i, item := range query.Items {
-			//		items[i] = &Room{Id: item.Jid, Name: item.Name,
-			//			Owner: item.Owner, Topic: item.Topic}
-			//	}
-			//	c.receivedRooms <- items
-			//case xmpp.NsIqRoster:
-			//	items := make([]*User, len(query.Items))
-			//	for i, item := range query.Items {
-			//		items[i] = &User{Id: item.Jid, Name: item.Name, MentionName: item.MentionName}
-			//	}
-			//	c.receivedUsers <- items
-			//}
-		case "message" + xmpp.NsJabberClient:
-			m := c.connection.Message(&element)
-
-			if m.Body != "" && m.Body != "none" {
-				if m.Body == "#attachment" {
-					m.Body = ""
+		case "error" + xmpp.NsStream:
+			se, err := c.conn().StreamError(&element)
+			if err != nil {
+				if c.readFailed(err) {
+					continue
 				}
-
-				c.receivedMessage <- &Message{
-					From:        m.From,
-					To:          m.To,
-					Body:        m.Body,
-					Mid:         m.MID,
-					Stamp:       strtotime(m.Delay.Stamp),
-					Attachments: getAttachments(m.HTMLBody.Body),
+				return
+			}
+			c.setDisconnectReason(&DisconnectReason{
+				Condition: se.Condition(),
+				Text:      se.Text(),
+				Host:      se.SeeOtherHost(),
+			})
+
+			if host := se.SeeOtherHost(); host != "" {
+				c.logger.Info("redirected", "event", "redirect", "host", host)
+				err := c.reconnect(host)
+				if err == nil {
+					continue
 				}
+				c.logger.Error("could not follow redirect", "event", "redirect", "host", host, "error", err)
+			}
 
-			} else if m.Fin.Body != "" {
-				c.recievedHistory <- c.messageBuffer
-				c.messageBuffer = c.messageBuffer[:0]
-				log.Println("History lock released start")
-				<-c.historyLock
-				log.Println("History lock release end")
-			} else if m.Invite != nil && m.Invite.From != "" {
-				items := make([]*Room, 1)
-				items[0] = &Room{Id: m.Invite.From, Topic: m.Invite.Reason}
-				c.receivedRooms <- items
-			} else if m.Result.Body != "" {
-				forwarded := c.connection.ForwardedMessage(m.Result.Body)
-
-				if forwarded.Message.Body == "#attachment" {
-					forwarded.Message.Body = ""
+			// reconnect once the server is willing to talk to us again
+			if se.Condition() == "policy-violation" {
+				c.rateLimited(&RateLimited{
+					From:       c.config.XMPPHost,
+					Condition:  se.Condition(),
+					Text:       se.Text(),
+					RetryAfter: RateLimitBackoff,
+				})
+				c.conn().Close()
+				if c.throttle(context.Background()) == nil && c.reconnectLoop() {
+					continue
 				}
+				return
+			}
 
-				c.messageBuffer = append(c.messageBuffer, Message{
-					From:        forwarded.Message.From,
-					To:          forwarded.Message.To,
-					Body:        forwarded.Message.Body,
-					Mid:         forwarded.Message.MID,
-					Stamp:       strtotime(forwarded.Delay.Stamp),
-					Attachments: getAttachments(forwarded.Message.HTMLBody.Body),
-				})
+			if permanentStreamErrors[se.Condition()] || c.shuttingDown() {
+				c.Close()
+				return
+			}
+			c.conn().Close()
+			if c.reconnectLoop() {
+				continue
+			}
+			return
+		case "iq" + xmpp.NsJabberClient:
+			iq, err := c.conn().IQ(&element)
+			if err != nil {
+				if c.readFailed(err) {
+					continue
+				}
+				return
+			}
+			c.publish(&Event{Topic: TopicIQ, IQ: iq})
+		case "presence" + xmpp.NsJabberClient:
+			p, err := c.conn().DecodePresence(&element)
+			if err != nil {
+				if c.readFailed(err) {
+					continue
+				}
+				return
+			}
+			if p.Type == "error" {
+				c.publish(&Event{Topic: TopicError, Err: newStanzaError(p.From, p.Error), id: p.ID})
+			} else {
+				presence := &Presence{From: ParseJID(p.From), To: ParseJID(p.To), Type: p.Type, Show: p.Show, Status: p.Status}
+				c.publish(&Event{Topic: TopicPresence, Presence: presence, id: p.ID})
+			}
+		case "message" + xmpp.NsJabberClient:
+			m, err := c.conn().Message(&element)
+			if err != nil {
+				if c.readFailed(err) {
+					continue
+				}
+				return
+			}
+			if e := c.messageEvent(m); e != nil {
+				c.publish(e)
 			}
 		default:
-			log.Println(element.Name.Local, element.Name.Space, element.Attr)
+			c.logger.Debug("unhandled element", "event", "stanza", "name", element.Name.Local, "namespace", element.Name.Space)
+		}
+	}
+}
+
+// messageEvent returns the event a message stanza is published as, or nil
+// if it is to be ignored.
+func (c *Client) messageEvent(m *xmpp.IncomingMessage) *Event {
+	switch {
+	case m.Type == "error":
+		return &Event{Topic: TopicError, Err: newStanzaError(m.From, m.Error), id: m.MID}
+	case m.Body != "" && m.Body != "none":
+		if m.Body == "#attachment" {
+			m.Body = ""
+		}
+
+		// drop messages already replayed by CatchUp or a rejoin
+		if !c.seen.add(m.MID) {
+			return nil
+		}
+		c.logger.Debug("received message", "event", "message", "room", m.From, "id", m.MID)
+
+		message := &Message{
+			From:         ParseJID(m.From),
+			To:           ParseJID(m.To),
+			Body:         m.Body,
+			Mid:          m.MID,
+			Stamp:        c.stamp(m.Stamp()),
+			Attachments:  getAttachments(m.HTMLBody.Body),
+			IsHistorical: m.Stamp() != "",
 		}
+		c.address(message, m.FromJID)
+		c.attachCard(message, m.Extension.Card)
+		c.describeAttachments(message, m.Extension.File)
+		c.validateAttachments(context.Background(), message)
+		if message.IsHistorical {
+			return &Event{Topic: topicReplayed, Message: message, id: m.MID}
+		}
+		return &Event{Topic: TopicMessage, Message: message, id: m.MID}
+	case m.Fin != nil:
+		return &Event{Topic: topicFin, fin: m.Fin, id: m.Fin.QueryID}
+	case m.Invite != nil && m.Invite.From != "":
+		return &Event{Topic: topicInvite, invite: &Room{Id: ParseJID(m.Invite.From), Topic: m.Invite.Reason}}
+	case m.Result.Body != "":
+		forwarded, err := c.conn().ForwardedMessage(m.Result.Body)
+		if err != nil {
+			c.logger.Warn("skipped malformed archived message", "event", "history", "id", m.Result.QueryID, "error", err)
+			return nil
+		}
+
+		if forwarded.Message.Body == "#attachment" {
+			forwarded.Message.Body = ""
+		}
+
+		message := &Message{
+			From:         ParseJID(forwarded.Message.From),
+			To:           ParseJID(forwarded.Message.To),
+			Body:         forwarded.Message.Body,
+			Mid:          forwarded.Message.MID,
+			Stamp:        c.stamp(forwarded.Delay.Stamp),
+			Attachments:  getAttachments(forwarded.Message.HTMLBody.Body),
+			IsHistorical: true,
+		}
+		c.address(message, forwarded.Message.FromJID)
+		c.attachCard(message, forwarded.Message.Extension.Card)
+		c.describeAttachments(message, forwarded.Message.Extension.File)
+		c.validateAttachments(context.Background(), message)
+		return &Event{Topic: topicArchived, Message: message, id: m.Result.QueryID}
+	}
+	return nil
+}
+
+// handleIQ answers pings and hands results to the requests waiting for them.
+func (c *Client) handleIQ(e *Event) {
+	iq := e.IQ
+	if c.resolveIQ(iq) {
+		return
 	}
+
+	if iq.Ping != nil && iq.Type == "get" {
+		c.conn().Pong(iq.ID, c.Id+"/"+c.Resource, iq.From)
+	} else if iq.Fin != nil {
+		c.finishHistory(iq.ID, iq.Fin, nil)
+	} else if iq.Type == "error" && iq.ID != "" {
+		// a failed archive query has no fin
+		c.finishHistory(iq.ID, nil, newStanzaError(iq.From, iq.Error))
+	}
+}
+
+// readFailed handles an error reading from the connection and reports whether
+// listen can go on. Malformed stanzas are skipped. Other errors end the
+// connection, which is reestablished unless the client is being closed.
+func (c *Client) readFailed(err error) bool {
+	var malformed *xmpp.MalformedError
+	if errors.As(err, &malformed) {
+		c.logger.Warn("skipped malformed stanza", "event", "stanza", "name", malformed.Name.Local, "error", malformed.Err)
+		return true
+	}
+	if c.Closed() || c.shuttingDown() {
+		return false
+	}
+
+	c.setDisconnectReason(&DisconnectReason{Err: err})
+	if c.reconnectLoop() {
+		return true
+	}
+	c.Close()
+	return false
+}
+
+// permanentStreamErrors are the stream error conditions after which
+// reconnecting would fail the same way, e.g. because another client took
+// over the resource.
+var permanentStreamErrors = map[string]bool{
+	"conflict":            true,
+	"host-unknown":        true,
+	"invalid-from":        true,
+	"not-authorized":      true,
+	"unsupported-version": true,
 }
@@ -0,0 +1,528 @@
+package hipchat_test
+
+import (
+	"context"
+	"errors"
+	"github.com/pyalex/hipchat"
+	"github.com/pyalex/hipchat/xmpp"
+	"github.com/pyalex/hipchat/xmpptest"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRoom = "1_test@" + xmpptest.ConfDomain
+
+var quiet = hipchat.WithSlog(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+// newServer starts a test server with the users bot and alice and the room
+// testRoom.
+func newServer(t *testing.T) *xmpptest.Server {
+	t.Helper()
+
+	srv, err := xmpptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	srv.AddUser(xmpptest.User{Name: "bot", Password: "secret", FullName: "Bot", MentionName: "bot"})
+	srv.AddUser(xmpptest.User{Name: "alice", Password: "secret", FullName: "Alice", MentionName: "alice"})
+	srv.AddRoom(xmpptest.Room{JID: testRoom, Name: "Test"})
+	return srv
+}
+
+// newClient connects the user to the server.
+func newClient(t *testing.T, srv *xmpptest.Server, user string, options ...hipchat.Option) *hipchat.Client {
+	t.Helper()
+
+	config := hipchat.Config{
+		XMPPHost: srv.Host(),
+		ConfHost: xmpptest.ConfDomain,
+		Port:     srv.Port(),
+		Timeouts: hipchat.Timeouts{IQ: 5 * time.Second, History: 5 * time.Second},
+	}
+	c, err := hipchat.NewClientWithConfig(user, "secret", "", config, append([]hipchat.Option{quiet}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// receive waits for the next message of the client.
+func receive(t *testing.T, c *hipchat.Client) *hipchat.Message {
+	t.Helper()
+
+	select {
+	case m, ok := <-c.Messages():
+		if !ok {
+			t.Fatal("client closed")
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return nil
+}
+
+func TestParseStamp(t *testing.T) {
+	utc := time.Date(2024, time.March, 5, 14, 30, 15, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2024-03-05T14:30:15Z", utc},
+		{"2024-03-05T14:30:15.123Z", utc.Add(123 * time.Millisecond)},
+		{"2024-03-05T14:30:15.123456789Z", utc.Add(123456789)},
+		{"2024-03-05T16:30:15+02:00", utc},
+		{"2024-03-05T09:30:15.5-05:00", utc.Add(500 * time.Millisecond)},
+		{"2024-03-05T16:30:15+0200", utc},
+		{"20240305T14:30:15", utc},
+	}
+	for _, tt := range tests {
+		stamp, err := hipchat.ParseStamp(tt.in)
+		if err != nil {
+			t.Errorf("ParseStamp(%q): %v", tt.in, err)
+			continue
+		}
+		if !stamp.Equal(tt.want) {
+			t.Errorf("ParseStamp(%q) = %v, want %v", tt.in, stamp, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "yesterday", "2024-03-05", "2024-03-05 14:30:15", "2024-13-05T14:30:15Z", "1709649015"} {
+		if stamp, err := hipchat.ParseStamp(in); err == nil {
+			t.Errorf("ParseStamp(%q) = %v, want an error", in, stamp)
+		}
+	}
+}
+
+func TestConnect(t *testing.T) {
+	srv := newServer(t)
+	c := newClient(t, srv, "bot")
+
+	if !c.Connected() {
+		t.Error("client not connected")
+	}
+	jid := c.JID()
+	if jid.Bare() != "bot@"+xmpptest.Domain || jid.Resource == "" {
+		t.Errorf("bound JID %v, want bot@%s with a resource", jid, xmpptest.Domain)
+	}
+	if sessions := srv.Sessions(); len(sessions) != 1 || sessions[0].User() != "bot" {
+		t.Errorf("server has %d sessions, want one of bot", len(sessions))
+	}
+}
+
+func TestConnectWrongPassword(t *testing.T) {
+	srv := newServer(t)
+
+	config := hipchat.Config{XMPPHost: srv.Host(), Port: srv.Port()}
+	_, err := hipchat.NewClientWithConfig("bot", "wrong", "", config, quiet)
+	if !errors.Is(err, hipchat.ErrAuthFailed) {
+		t.Errorf("connected with %v, want ErrAuthFailed", err)
+	}
+}
+
+func TestReconnectWrongPassword(t *testing.T) {
+	srv := newServer(t)
+	c := newClient(t, srv, "bot")
+
+	closed := make(chan struct{})
+	c.Subscribe(hipchat.TopicLifecycle, func(e *hipchat.Event) {
+		if e.Name == hipchat.EventClosed {
+			close(closed)
+		}
+	})
+
+	srv.AddUser(xmpptest.User{Name: "bot", Password: "changed"})
+	srv.Sessions()[0].Close()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client kept reconnecting with rejected credentials")
+	}
+
+	if r := c.DisconnectReason(); r == nil || !errors.Is(r.Err, hipchat.ErrAuthFailed) {
+		t.Errorf("disconnected because of %v, want ErrAuthFailed", r)
+	}
+	if c.Connected() || !c.Closed() {
+		t.Error("client not closed")
+	}
+}
+
+func TestJoinAndEcho(t *testing.T) {
+	srv := newServer(t)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bot.JoinContext(ctx, testRoom, "Bot", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.JoinContext(ctx, testRoom, "Alice", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := alice.SayAck(ctx, testRoom, "Alice", "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	m := receive(t, bot)
+	if m.Body != "hello" || m.RoomJID.Bare() != testRoom || m.SenderNick != "Alice" {
+		t.Errorf("received %q in %v from %q, want hello in %s from Alice", m.Body, m.RoomJID, m.SenderNick, testRoom)
+	}
+}
+
+func TestJoinUnknownRoom(t *testing.T) {
+	srv := newServer(t)
+	c := newClient(t, srv, "bot")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := c.JoinContext(ctx, "1_missing@"+xmpptest.ConfDomain, "Bot", 0)
+	if !errors.Is(err, hipchat.ErrRoomNotFound) {
+		t.Errorf("joined with %v, want ErrRoomNotFound", err)
+	}
+}
+
+func TestLoadHistory(t *testing.T) {
+	srv := newServer(t)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	srv.AddArchive(testRoom,
+		xmpptest.ArchivedMessage{From: testRoom + "/Alice", Body: "one", Stamp: start},
+		xmpptest.ArchivedMessage{From: testRoom + "/Alice", Body: "two", Stamp: start.Add(time.Minute)},
+		xmpptest.ArchivedMessage{From: testRoom + "/Alice", Body: "three", Stamp: start.Add(2 * time.Minute)},
+	)
+	c := newClient(t, srv, "bot")
+
+	page, err := c.LoadHistory(testRoom, start, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 2 || page.Messages[0].Body != "one" || page.Messages[1].Body != "two" {
+		t.Fatalf("loaded %+v, want one and two", page.Messages)
+	}
+	if page.Count != 3 || page.Complete {
+		t.Errorf("page count %d, complete %v, want 3 and false", page.Count, page.Complete)
+	}
+	if !page.Messages[0].IsHistorical {
+		t.Error("archived message not marked historical")
+	}
+}
+
+func TestLoadHistoryRejected(t *testing.T) {
+	srv := newServer(t)
+	srv.Handle(rejectArchive)
+	c := newClient(t, srv, "bot")
+
+	began := time.Now()
+	_, err := c.LoadHistory(testRoom, time.Now().Add(-time.Hour), 10)
+	var stanzaErr *hipchat.StanzaError
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != "forbidden" {
+		t.Errorf("loaded history with %v, want a forbidden StanzaError", err)
+	}
+	if elapsed := time.Since(began); elapsed > 2*time.Second {
+		t.Errorf("rejected query took %v", elapsed)
+	}
+}
+
+func TestStreamHistory(t *testing.T) {
+	srv := newServer(t)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	srv.AddArchive(testRoom,
+		xmpptest.ArchivedMessage{From: testRoom + "/Alice", Body: "one", Stamp: start},
+		xmpptest.ArchivedMessage{From: testRoom + "/Alice", Body: "two", Stamp: start.Add(time.Minute)},
+	)
+	bot := newClient(t, srv, "bot")
+	alice := newClient(t, srv, "alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bot.JoinContext(ctx, testRoom, "Bot", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.JoinContext(ctx, testRoom, "Alice", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := bot.StreamHistory(ctx, testRoom, start, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the unread stream does not hold up other messages
+	if err := alice.SayAck(ctx, testRoom, "Alice", "live", nil); err != nil {
+		t.Fatal(err)
+	}
+	if m := receive(t, bot); m.Body != "live" {
+		t.Errorf("received %q while the stream was unread, want live", m.Body)
+	}
+
+	var bodies []string
+	for m := range stream.Messages() {
+		bodies = append(bodies, m.Body)
+	}
+	if len(bodies) != 2 || bodies[0] != "one" || bodies[1] != "two" {
+		t.Errorf("streamed %q, want one and two", bodies)
+	}
+	if err := stream.Err(); err != nil {
+		t.Errorf("stream ended with %v", err)
+	}
+}
+
+func TestStreamHistoryRejected(t *testing.T) {
+	srv := newServer(t)
+	srv.Handle(rejectArchive)
+	c := newClient(t, srv, "bot")
+
+	stream, err := c.StreamHistory(context.Background(), testRoom, time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range stream.Messages() {
+		t.Error("rejected stream sent a message")
+	}
+	var stanzaErr *hipchat.StanzaError
+	if err := stream.Err(); !errors.As(err, &stanzaErr) {
+		t.Errorf("stream ended with %v, want a StanzaError", err)
+	}
+}
+
+func TestStreamHistoryCancelled(t *testing.T) {
+	srv := newServer(t)
+	srv.Handle(ignoreArchive)
+	c := newClient(t, srv, "bot")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.StreamHistory(ctx, testRoom, time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-stream.Messages():
+		if ok {
+			t.Fatal("cancelled stream sent a message")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled stream not closed")
+	}
+	if err := stream.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("stream ended with %v, want context.Canceled", err)
+	}
+}
+
+// fallback is a Fallback serving a fixed room history.
+type fallback struct {
+	history []hipchat.Message
+}
+
+func (f *fallback) UserPresence(ctx context.Context, user hipchat.UserID) (*hipchat.Presence, error) {
+	return nil, hipchat.ErrUnknownPresence
+}
+
+func (f *fallback) RoomHistory(ctx context.Context, room hipchat.RoomID, start time.Time, limit int) ([]hipchat.Message, error) {
+	return f.history, nil
+}
+
+func (f *fallback) SetRoomTopic(ctx context.Context, room hipchat.RoomID, topic string) error {
+	return errors.New("not supported")
+}
+
+func (f *fallback) SendRoomMessage(ctx context.Context, room hipchat.RoomID, body string) error {
+	return errors.New("not supported")
+}
+
+func (f *fallback) Rooms(ctx context.Context) ([]*hipchat.Room, error) {
+	return nil, errors.New("not supported")
+}
+
+// rejectArchive makes the server reject archive queries as forbidden.
+func rejectArchive(s *xmpptest.Session, stanza *xmpptest.Stanza) bool {
+	if !ignoreArchive(s, stanza) {
+		return false
+	}
+	s.Send(xmpptest.NewStanza("", "iq", "type", "error", "id", stanza.Attr("id"), "to", s.JID()).Add(
+		xmpptest.NewStanza("", "error", "type", "auth").Add(xmpptest.NewStanza(xmpp.NsStanzas, "forbidden"))))
+	return true
+}
+
+// ignoreArchive makes the server swallow archive queries.
+func ignoreArchive(s *xmpptest.Session, stanza *xmpptest.Stanza) bool {
+	return stanza.ChildNS(xmpp.NsMam2, "query") != nil || stanza.ChildNS(xmpp.NsMam, "query") != nil
+}
+
+func TestLoadHistoryFallbackOnTimeout(t *testing.T) {
+	srv := newServer(t)
+	srv.Handle(ignoreArchive)
+	f := &fallback{history: []hipchat.Message{{Body: "from the fallback"}}}
+	c := newClient(t, srv, "bot", hipchat.WithFallback(f))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	page, err := c.LoadHistoryContext(ctx, testRoom, time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].Body != "from the fallback" {
+		t.Errorf("loaded %+v, want the fallback's page", page.Messages)
+	}
+	if !page.Messages[0].IsHistorical {
+		t.Error("fallback message not marked historical")
+	}
+}
+
+// archiveWithoutSet makes the server answer archive queries with as many
+// messages as asked for but without a result set to page with, and counts
+// the queries.
+func archiveWithoutSet(queries *int32) xmpptest.Handler {
+	return func(s *xmpptest.Session, stanza *xmpptest.Stanza) bool {
+		query := stanza.ChildNS(xmpp.NsMam2, "query")
+		if query == nil {
+			query = stanza.ChildNS(xmpp.NsMam, "query")
+		}
+		if query == nil {
+			return false
+		}
+		atomic.AddInt32(queries, 1)
+
+		max := 0
+		if set := query.ChildNS("http://jabber.org/protocol/rsm", "set"); set != nil && set.Child("max") != nil {
+			max, _ = strconv.Atoi(set.Child("max").Text)
+		}
+		for i := 0; i < max; i++ {
+			id := "m" + strconv.Itoa(i)
+			s.Send(xmpptest.NewStanza("", "message", "to", s.JID()).Add(
+				xmpptest.NewStanza(query.XMLName.Space, "result", "queryid", query.Attr("queryid"), "id", id).Add(
+					xmpptest.NewStanza(xmpp.NsMamForward, "forwarded").Add(
+						xmpptest.NewStanza("urn:xmpp:delay", "delay", "stamp", "2024-01-01T00:00:00Z"),
+						xmpptest.NewStanza(xmpp.NsJabberClient, "message", "id", id, "from", testRoom+"/Alice").Add(
+							xmpptest.NewStanza("", "body").SetText("archived"))))))
+		}
+		s.Send(xmpptest.NewStanza("", "iq", "type", "result", "id", stanza.Attr("id"), "to", s.JID()).Add(
+			xmpptest.NewStanza(query.XMLName.Space, "fin", "queryid", query.Attr("queryid"), "complete", "false")))
+		return true
+	}
+}
+
+func TestHistoryWithoutResultSet(t *testing.T) {
+	srv := newServer(t)
+	var queries int32
+	srv.Handle(archiveWithoutSet(&queries))
+	c := newClient(t, srv, "bot")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	messages, err := c.LoadLastN(ctx, testRoom, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 5 || atomic.LoadInt32(&queries) != 1 {
+		t.Errorf("loaded %d messages in %d queries, want 5 in one", len(messages), atomic.LoadInt32(&queries))
+	}
+
+	atomic.StoreInt32(&queries, 0)
+	it := c.IterateHistory(testRoom, time.Time{})
+	n := 0
+	for {
+		_, err := it.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n == 0 || atomic.LoadInt32(&queries) != 1 {
+		t.Errorf("iterated over %d messages in %d queries, want one page", n, atomic.LoadInt32(&queries))
+	}
+}
+
+// sink records the pages written by Backfill.
+type sink struct {
+	written chan time.Time
+}
+
+func (s *sink) Checkpoint(roomJid string) (string, error) {
+	return "", nil
+}
+
+func (s *sink) Write(roomJid string, page *hipchat.HistoryPage) error {
+	select {
+	case s.written <- time.Now():
+	default:
+	}
+	return nil
+}
+
+func TestBackfillThrottled(t *testing.T) {
+	defer func(backoff time.Duration) { hipchat.RateLimitBackoff = backoff }(hipchat.RateLimitBackoff)
+	hipchat.RateLimitBackoff = 300 * time.Millisecond
+
+	srv := newServer(t)
+	srv.AddArchive(testRoom, xmpptest.ArchivedMessage{ID: "a1", From: testRoom + "/Alice", Body: "one", Stamp: time.Now().Add(-time.Hour)})
+	c := newClient(t, srv, "bot")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.JoinContext(ctx, testRoom, "Bot", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Sessions()[0].Send(xmpptest.NewStanza("", "message", "type", "error", "from", testRoom, "to", c.JID().Full()).Add(
+		xmpptest.NewStanza("", "error", "type", "wait").Add(xmpptest.NewStanza(xmpp.NsStanzas, "resource-constraint"))))
+	var limited time.Time
+	select {
+	case <-c.RateLimits():
+		limited = time.Now()
+	case <-ctx.Done():
+		t.Fatal("client not rate limited")
+	}
+
+	s := &sink{written: make(chan time.Time, 1)}
+	go c.Backfill(ctx, s, 10*time.Millisecond)
+	select {
+	case written := <-s.written:
+		if wait := written.Sub(limited); wait < 250*time.Millisecond {
+			t.Errorf("backfilled %v after being rate limited, want it to wait", wait)
+		}
+	case <-ctx.Done():
+		t.Fatal("nothing backfilled")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	srv := newServer(t)
+	c := newClient(t, srv, "bot")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.JoinContext(ctx, testRoom, "Bot", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Sessions()[0].Close()
+	select {
+	case <-c.OnReconnect:
+	case <-ctx.Done():
+		t.Fatal("client did not reconnect")
+	}
+
+	// the room is joined again
+	alice := newClient(t, srv, "alice")
+	if err := alice.JoinContext(ctx, testRoom, "Alice", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.SayAck(ctx, testRoom, "Alice", "still there?", nil); err != nil {
+		t.Fatal(err)
+	}
+	if m := receive(t, c); m.Body != "still there?" {
+		t.Errorf("received %q after reconnecting", m.Body)
+	}
+}
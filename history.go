@@ -0,0 +1,467 @@
+package hipchat
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyalex/hipchat/xmpp"
+)
+
+// HistoryTimeout is how long LoadHistory waits for the server to send a
+// complete batch of archived messages, unless the Config says otherwise.
+var HistoryTimeout = 30 * time.Second
+
+// historyPageSize is the number of messages requested per page when paging
+// through an archive.
+const historyPageSize = 100
+
+type historyQuery struct {
+	id       string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	messages chan *Message
+	wake     chan struct{}
+
+	// pending holds the messages received but not yet sent on messages, so
+	// the connection's reader never waits for the query's reader
+	pending  []*Message
+	finished bool
+	fin      *xmpp.Fin
+	err      error
+	mutex    sync.Mutex
+}
+
+// result returns the fin element and error the query finished with. It must
+// only be called once the messages channel is closed.
+func (q *historyQuery) result() (*xmpp.Fin, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.fin, q.err
+}
+
+// finish records how the query finished unless it already is. It reports
+// whether it was not.
+func (q *historyQuery) finish(fin *xmpp.Fin, err error) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.finished {
+		return false
+	}
+	q.finished = true
+	q.fin = fin
+	q.err = err
+	return true
+}
+
+// A HistoryPage is a batch of archived messages along with the paging
+// information sent by the server. First and Last are the archive ids of the
+// first and last message of the page, Count is the total number of messages
+// matching the query or -1 if the server did not tell, and Complete is set
+// when there are no more messages after this page.
+type HistoryPage struct {
+	Messages []Message
+	First    string
+	Last     string
+	Count    int
+	Complete bool
+}
+
+// LoadHistory requests up to limit archived messages exchanged with jid
+// starting at start and blocks until the whole batch has been received or
+// the history timeout has passed. The jid is either a room or, for private chats,
+// a user.
+func (c *Client) LoadHistory(jid string, start time.Time, limit int) (*HistoryPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeouts.History)
+	defer cancel()
+	return c.LoadHistoryContext(ctx, jid, start, limit)
+}
+
+// LoadHistoryContext is like LoadHistory but gives up when ctx is done. The
+// messages received so far are returned along with the context's error.
+//
+// If the client has a HistoryStore it is consulted first. Only full pages are
+// put into the store since a shorter page may still grow. If the archive query
+// of a room fails or times out and the client has a fallback, see
+// WithFallback, the page is loaded from the fallback instead; it has no
+// archive ids. The archive query then only gets half the time left before
+// ctx's deadline, so the fallback has the other half.
+func (c *Client) LoadHistoryContext(ctx context.Context, jid string, start time.Time, limit int) (*HistoryPage, error) {
+	if c.HistoryStore != nil {
+		if page, ok := c.HistoryStore.Get(jid, start, limit); ok {
+			return page, nil
+		}
+	}
+
+	query := ctx
+	if c.fallback != nil && c.isRoom(jid) {
+		var cancel context.CancelFunc
+		query, cancel = context.WithTimeout(ctx, c.archiveTimeout(ctx))
+		defer cancel()
+	}
+	page, err := c.loadHistoryPage(query, xmpp.HistoryQuery{With: jid, Start: start, Max: limit})
+	if err != nil {
+		if fallback, ferr := c.historyFallback(ctx, jid, start, limit, err); ferr == nil {
+			return fallback, nil
+		}
+		return page, err
+	}
+
+	if c.HistoryStore != nil && len(page.Messages) == limit {
+		c.HistoryStore.Put(jid, start, limit, page)
+	}
+	return page, nil
+}
+
+// archiveTimeout returns how long an archive query that may be followed by
+// the fallback can take: half the time left before ctx's deadline, or the
+// history timeout if ctx has none.
+func (c *Client) archiveTimeout(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline) / 2
+	}
+	return c.config.Timeouts.History
+}
+
+// LoadHistoryToday returns the messages exchanged with jid since midnight.
+func (c *Client) LoadHistoryToday(ctx context.Context, jid string) ([]Message, error) {
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return c.LoadHistoryBetween(ctx, jid, midnight, now)
+}
+
+// LoadHistoryBetween returns the messages exchanged with jid between from and
+// to, paging through the archive as needed.
+func (c *Client) LoadHistoryBetween(ctx context.Context, jid string, from, to time.Time) ([]Message, error) {
+	messages := make([]Message, 0)
+	err := c.walkHistory(ctx, xmpp.HistoryQuery{With: jid, Start: from, End: to}, func(m *Message) error {
+		messages = append(messages, *m)
+		return nil
+	})
+	return messages, err
+}
+
+// LoadLastN returns the n most recent messages exchanged with jid, oldest
+// first, paging backwards through the archive as needed.
+func (c *Client) LoadLastN(ctx context.Context, jid string, n int) ([]Message, error) {
+	messages := make([]Message, 0, n)
+	hq := xmpp.HistoryQuery{With: jid, FromEnd: true}
+	for len(messages) < n {
+		hq.Max = n - len(messages)
+		if hq.Max > historyPageSize {
+			hq.Max = historyPageSize
+		}
+
+		page, err := c.loadHistoryPage(ctx, hq)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(page.Messages, messages...)
+
+		// without a result set there is nothing to page back from
+		if page.Complete || len(page.Messages) < hq.Max || page.First == "" {
+			break
+		}
+		hq.Before = page.First
+	}
+	return messages, nil
+}
+
+// CatchUp fetches the messages sent to a room after the message with the id
+// lastSeenMid, page by page, and replays them on the Messages channel with
+// IsHistorical set. It is meant to fill the gap left while the client was
+// offline. Messages already delivered on the channel, e.g. by the history
+// replay of Join, are skipped.
+func (c *Client) CatchUp(ctx context.Context, roomJid, lastSeenMid string) error {
+	hq := xmpp.HistoryQuery{With: roomJid, After: lastSeenMid}
+	return c.walkHistory(ctx, hq, func(m *Message) error {
+		if !c.seen.add(m.Mid) {
+			return nil
+		}
+
+		m.IsHistorical = true
+		return c.deliver(ctx, m)
+	})
+}
+
+// walkHistory pages through the archived messages selected by hq and calls fn
+// for each of them in order. It stops at the first error returned by fn.
+func (c *Client) walkHistory(ctx context.Context, hq xmpp.HistoryQuery, fn func(*Message) error) error {
+	it := c.iterateHistory(hq)
+	for {
+		m, err := it.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+}
+
+// loadHistoryPage requests the page of archived messages selected by hq and
+// waits for all of it.
+func (c *Client) loadHistoryPage(ctx context.Context, hq xmpp.HistoryQuery) (page *HistoryPage, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ctx, span := c.startSpan(ctx, "hipchat.history", map[string]string{"with": hq.With})
+	start := time.Now()
+	defer func() {
+		span.End(err)
+		c.metrics.ObserveDuration("hipchat_history_duration", time.Since(start), nil)
+	}()
+
+	q, err := c.queryHistory(ctx, hq)
+	if err != nil {
+		return nil, err
+	}
+
+	page = &HistoryPage{Messages: make([]Message, 0), Count: -1}
+	for m := range q.messages {
+		page.Messages = append(page.Messages, *m)
+	}
+	fin, err := q.result()
+	if err != nil {
+		return page, err
+	}
+
+	if fin != nil {
+		page.First = fin.Set.First
+		page.Last = fin.Set.Last
+		page.Complete = fin.Complete
+		if fin.Set.Count != nil {
+			page.Count = *fin.Set.Count
+		}
+	}
+	return page, nil
+}
+
+// A HistoryStream is a history request started by StreamHistory.
+type HistoryStream struct {
+	q *historyQuery
+}
+
+// Messages returns the channel the archived messages are sent on as soon as
+// they arrive. It is closed once the server has sent the whole batch, the
+// server rejected the query or the stream's context is done; Err tells which.
+func (s *HistoryStream) Messages() <-chan *Message {
+	return s.q.messages
+}
+
+// Err tells how the stream ended: nil if the server sent the whole batch, a
+// *StanzaError if it rejected the query, or the context's error if the
+// stream was given up, wrapping ErrTimeout if it timed out. It must only be
+// called once the Messages channel is closed.
+func (s *HistoryStream) Err() error {
+	_, err := s.q.result()
+	return err
+}
+
+// StreamHistory requests up to limit archived messages exchanged with jid
+// starting at start and returns a HistoryStream the messages are sent on as
+// they arrive. If ctx has no deadline, the stream times out after the
+// history timeout. Several history requests may be in flight at once.
+func (c *Client) StreamHistory(ctx context.Context, jid string, start time.Time, limit int) (*HistoryStream, error) {
+	q, err := c.queryHistory(ctx, xmpp.HistoryQuery{With: jid, Start: start, Max: limit})
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryStream{q: q}, nil
+}
+
+// queryHistory sends the archive query hq. Unless ctx has a deadline the
+// query times out after the history timeout.
+func (c *Client) queryHistory(ctx context.Context, hq xmpp.HistoryQuery) (*historyQuery, error) {
+	if ctx.Err() != nil {
+		return nil, contextError(ctx)
+	}
+	if c.Closed() {
+		return nil, ErrNotConnected
+	}
+
+	hq.With = c.archiveWith(hq.With)
+	q := &historyQuery{
+		id:       xmpp.ID(),
+		messages: make(chan *Message),
+		wake:     make(chan struct{}, 1),
+	}
+	if _, ok := ctx.Deadline(); ok {
+		q.ctx, q.cancel = context.WithCancel(ctx)
+	} else {
+		q.ctx, q.cancel = context.WithTimeout(ctx, c.config.Timeouts.History)
+	}
+
+	c.historyMutex.Lock()
+	c.history[q.id] = q
+	hq.Namespace = c.archiveNs
+	c.historyMutex.Unlock()
+
+	c.goLabeled("history", hq.With, func() { c.forwardHistory(q) })
+
+	if err := c.conn().History(q.id, hq); err != nil {
+		c.finishHistory(q.id, nil, err)
+		return nil, err
+	}
+	return q, nil
+}
+
+// forwardHistory sends the messages received for the query on its channel
+// and closes the channel once the query is finished and all of them are
+// sent, or once the query's context is done.
+func (c *Client) forwardHistory(q *historyQuery) {
+	defer close(q.messages)
+	defer q.cancel()
+
+	for {
+		q.mutex.Lock()
+		pending, finished := q.pending, q.finished
+		q.pending = nil
+		q.mutex.Unlock()
+
+		for _, m := range pending {
+			select {
+			case q.messages <- m:
+			case <-q.ctx.Done():
+				c.abandonHistory(q)
+				return
+			}
+		}
+		if finished {
+			return
+		}
+
+		select {
+		case <-q.wake:
+		case <-q.ctx.Done():
+			c.abandonHistory(q)
+			return
+		}
+	}
+}
+
+// abandonHistory finishes the query with its context's error. Messages
+// received but not sent on its channel are dropped, so the context's error
+// replaces a fin received already.
+func (c *Client) abandonHistory(q *historyQuery) {
+	c.historyMutex.Lock()
+	if c.history[q.id] == q {
+		delete(c.history, q.id)
+	}
+	c.historyMutex.Unlock()
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.finished = true
+	q.fin = nil
+	q.err = contextError(q.ctx)
+	q.pending = nil
+}
+
+// archiveWith returns the with filter matching the conversation with jid.
+// Rooms are matched as given. Users are matched by their bare JID so the
+// private chat with any of their resources is included.
+func (c *Client) archiveWith(jid string) string {
+	if c.isRoom(jid) {
+		return jid
+	}
+	if i := strings.Index(jid, "/"); i >= 0 {
+		return jid[:i]
+	}
+	return jid
+}
+
+// isRoom reports whether jid belongs to the conference service.
+func (c *Client) isRoom(jid string) bool {
+	domain := jid[strings.Index(jid, "@")+1:]
+	if i := strings.Index(domain, "/"); i >= 0 {
+		domain = domain[:i]
+	}
+	return domain == c.config.ConfHost
+}
+
+// discoverArchive asks the server which MAM versions it supports for the
+// client's archive and picks the newest one.
+func (c *Client) discoverArchive() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeouts.IQ)
+	defer cancel()
+
+	iq, err := c.sendIQ(ctx, func(id string) error {
+		return c.conn().DiscoverInfo(id, c.Id+"/"+c.Resource, c.Id)
+	})
+	if err != nil || iq.Query == nil {
+		return
+	}
+
+	c.historyMutex.Lock()
+	defer c.historyMutex.Unlock()
+
+	if iq.Query.HasFeature(xmpp.NsMam2) {
+		c.archiveNs = xmpp.NsMam2
+	} else {
+		c.archiveNs = xmpp.NsMam
+	}
+}
+
+// pendingHistory returns the pending history query with the given id. Servers
+// that do not echo the query id get the only pending query, if there is one.
+// The caller must hold historyMutex.
+func (c *Client) pendingHistory(id string) *historyQuery {
+	if id == "" && len(c.history) == 1 {
+		for _, q := range c.history {
+			return q
+		}
+	}
+	return c.history[id]
+}
+
+// deliverHistory queues an archived message for the pending history query
+// it belongs to. It never waits for the query's reader, so the connection is
+// read on while a slow reader catches up.
+func (c *Client) deliverHistory(id string, m *Message) {
+	c.historyMutex.Lock()
+	q := c.pendingHistory(id)
+	c.historyMutex.Unlock()
+	if q == nil {
+		return
+	}
+
+	q.mutex.Lock()
+	if !q.finished {
+		q.pending = append(q.pending, m)
+	}
+	q.mutex.Unlock()
+	q.notify()
+}
+
+// finishHistory finishes the pending history query with the given id. Its
+// channel is closed once the messages received before are sent. The fin
+// element is nil when the query failed with err.
+func (c *Client) finishHistory(id string, fin *xmpp.Fin, err error) {
+	c.historyMutex.Lock()
+	q := c.pendingHistory(id)
+	if q != nil {
+		delete(c.history, q.id)
+	}
+	c.historyMutex.Unlock()
+	if q == nil {
+		return
+	}
+
+	if q.finish(fin, err) {
+		q.notify()
+	}
+}
+
+// notify wakes up forwardHistory.
+func (q *historyQuery) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,81 @@
+package hipchat
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// A HistoryStore keeps batches of archived messages so repeated history
+// requests for the same window do not have to hit the server. Set it on
+// Client.HistoryStore to have LoadHistory consult it.
+type HistoryStore interface {
+	// Get returns the page stored for the window, if any.
+	Get(roomJid string, start time.Time, limit int) (*HistoryPage, bool)
+	// Put stores the page of a window.
+	Put(roomJid string, start time.Time, limit int, page *HistoryPage)
+}
+
+type historyKey struct {
+	room  string
+	start string
+	limit int
+}
+
+type historyEntry struct {
+	key  historyKey
+	page *HistoryPage
+}
+
+// A HistoryCache is an in-memory HistoryStore that evicts the least recently
+// used windows once it holds more than its size.
+type HistoryCache struct {
+	size    int
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[historyKey]*list.Element
+}
+
+// NewHistoryCache creates a HistoryCache holding at most size windows.
+func NewHistoryCache(size int) *HistoryCache {
+	return &HistoryCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[historyKey]*list.Element),
+	}
+}
+
+// Get returns the page stored for the window and marks it as recently used.
+func (h *HistoryCache) Get(roomJid string, start time.Time, limit int) (*HistoryPage, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	e, ok := h.entries[historyKey{roomJid, start.UTC().Format(time.RFC3339Nano), limit}]
+	if !ok {
+		return nil, false
+	}
+
+	h.order.MoveToFront(e)
+	return e.Value.(*historyEntry).page, true
+}
+
+// Put stores the page of a window, evicting the least recently used one if
+// the cache is full.
+func (h *HistoryCache) Put(roomJid string, start time.Time, limit int, page *HistoryPage) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := historyKey{roomJid, start.UTC().Format(time.RFC3339Nano), limit}
+	if e, ok := h.entries[key]; ok {
+		e.Value.(*historyEntry).page = page
+		h.order.MoveToFront(e)
+		return
+	}
+
+	h.entries[key] = h.order.PushFront(&historyEntry{key, page})
+	for h.order.Len() > h.size {
+		e := h.order.Back()
+		h.order.Remove(e)
+		delete(h.entries, e.Value.(*historyEntry).key)
+	}
+}
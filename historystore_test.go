@@ -0,0 +1,100 @@
+package hipchat_test
+
+import (
+	"github.com/pyalex/hipchat"
+	"github.com/pyalex/hipchat/xmpptest"
+	"testing"
+	"time"
+)
+
+func TestHistoryCacheEviction(t *testing.T) {
+	start := time.Date(2024, time.March, 5, 9, 0, 0, 0, time.UTC)
+	h := hipchat.NewHistoryCache(2)
+	one, two, three := &hipchat.HistoryPage{First: "1"}, &hipchat.HistoryPage{First: "2"}, &hipchat.HistoryPage{First: "3"}
+
+	h.Put(testRoom, start, 1, one)
+	h.Put(testRoom, start, 2, two)
+	if page, ok := h.Get(testRoom, start, 1); !ok || page != one {
+		t.Fatal("lost the first window")
+	}
+
+	// the second window is the least recently used now
+	h.Put(testRoom, start, 3, three)
+	if _, ok := h.Get(testRoom, start, 2); ok {
+		t.Error("kept the least recently used window")
+	}
+	if page, ok := h.Get(testRoom, start, 1); !ok || page != one {
+		t.Error("evicted a recently used window")
+	}
+	if page, ok := h.Get(testRoom, start, 3); !ok || page != three {
+		t.Error("evicted the newest window")
+	}
+
+	// storing a window again replaces it and marks it as used
+	h.Put(testRoom, start, 1, two)
+	h.Put(testRoom, start, 4, three)
+	if page, ok := h.Get(testRoom, start, 1); !ok || page != two {
+		t.Error("did not replace the stored page")
+	}
+	if _, ok := h.Get(testRoom, start, 3); ok {
+		t.Error("kept the least recently used window after a replacement")
+	}
+}
+
+func TestHistoryCacheKeys(t *testing.T) {
+	start := time.Date(2024, time.March, 5, 9, 0, 0, 0, time.UTC)
+	page := &hipchat.HistoryPage{First: "1"}
+	h := hipchat.NewHistoryCache(10)
+	h.Put(testRoom, start, 10, page)
+
+	tests := []struct {
+		name  string
+		room  string
+		start time.Time
+		limit int
+		hit   bool
+	}{
+		{"same window", testRoom, start, 10, true},
+		{"same instant in another zone", testRoom, start.In(time.FixedZone("CET", 3600)), 10, true},
+		{"other room", "1_other@" + xmpptest.ConfDomain, start, 10, false},
+		{"other start", testRoom, start.Add(time.Nanosecond), 10, false},
+		{"other limit", testRoom, start, 20, false},
+	}
+	for _, tt := range tests {
+		got, ok := h.Get(tt.room, tt.start, tt.limit)
+		if ok != tt.hit || ok && got != page {
+			t.Errorf("%s: got %v, %v, want a hit %v", tt.name, got, ok, tt.hit)
+		}
+	}
+}
+
+func TestLoadHistoryUsesStore(t *testing.T) {
+	srv := newServer(t)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	srv.AddArchive(testRoom,
+		xmpptest.ArchivedMessage{From: testRoom + "/Alice", Body: "one", Stamp: start},
+		xmpptest.ArchivedMessage{From: testRoom + "/Alice", Body: "two", Stamp: start.Add(time.Minute)},
+	)
+	c := newClient(t, srv, "bot")
+	c.HistoryStore = hipchat.NewHistoryCache(10)
+
+	full, err := c.LoadHistory(testRoom, start, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the full page is served from the store once the server rejects queries
+	srv.Handle(rejectArchive)
+	again, err := c.LoadHistory(testRoom, start, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != full {
+		t.Error("full page not served from the store")
+	}
+
+	// pages that may still grow are not stored
+	if _, err := c.LoadHistory(testRoom, start, 5); err == nil {
+		t.Error("short page served from the store")
+	}
+}
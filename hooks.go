@@ -0,0 +1,41 @@
+package hipchat
+
+// Hooks are functions called when a client reaches a milestone of its
+// lifecycle, e.g. to record metrics or to join rooms after every
+// (re)connect. Nil hooks are skipped. Hooks are called from the goroutine
+// that reached the milestone, often the one reading from the connection, so
+// they must not wait for the server; use Join rather than JoinContext.
+type Hooks struct {
+	// Connected is called once the TCP connection to addr is established,
+	// on the first connect and on every reconnect.
+	Connected func(c *Client, addr string)
+	// Authenticated is called once the server accepted the credentials and
+	// bound the resource. c.JID returns the bound JID.
+	Authenticated func(c *Client)
+	// Joined is called whenever the client joins a room, including the
+	// rejoins after a reconnect.
+	Joined func(c *Client, room RoomID)
+	// Closed is called once the client is closed for good and its channels
+	// are closed. c.DisconnectReason tells why, if the connection was lost.
+	Closed func(c *Client)
+}
+
+// subscribeHooks calls the hooks from lifecycle events.
+func (c *Client) subscribeHooks() {
+	h := c.hooks
+	if h.Connected == nil && h.Authenticated == nil && h.Joined == nil && h.Closed == nil {
+		return
+	}
+	c.Subscribe(TopicLifecycle, func(e *Event) {
+		switch {
+		case e.Name == EventConnected && h.Connected != nil:
+			h.Connected(c, e.Addr)
+		case e.Name == EventAuthenticated && h.Authenticated != nil:
+			h.Authenticated(c)
+		case e.Name == EventJoined && h.Joined != nil:
+			h.Joined(c, e.Room)
+		case e.Name == EventClosed && h.Closed != nil:
+			h.Closed(c)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package hipchat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pyalex/hipchat/xmpp"
+	"image"
+	_ "image/gif" // decoders for the images SayWithImage sends
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// A fileSharer is a Fallback that can share files in rooms. The rest
+// package's Client is one.
+type fileSharer interface {
+	ShareRoomFile(ctx context.Context, room RoomID, path, message string) (string, error)
+}
+
+// SayWithImage sends the body along with the image at imagePath to the room.
+// See SayWithImageContext.
+func (c *Client) SayWithImage(roomId RoomID, name, body, imagePath string) error {
+	return c.SayWithImageContext(context.Background(), roomId, name, body, imagePath)
+}
+
+// SayWithImageContext uploads the image at imagePath, a GIF, JPEG or PNG
+// file, with the HTTP upload service of the server and sends it to the room
+// as an attachment of the body. If the server has no upload service and the
+// client has a fallback that can share files, see WithFallback, the image is
+// shared in the room with the body as its message instead.
+func (c *Client) SayWithImageContext(ctx context.Context, roomId RoomID, name, body, imagePath string) error {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	config, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("%s is not a supported image: %w", imagePath, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	filename := filepath.Base(imagePath)
+	url, err := c.Upload(ctx, filename, f, info.Size(), mime.TypeByExtension("."+format))
+	if errors.Is(err, ErrUploadUnsupported) {
+		if sharer, ok := c.fallback.(fileSharer); ok {
+			_, err = sharer.ShareRoomFile(ctx, roomId, imagePath, body)
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.Say(roomId, name, body, []xmpp.Attachment{{
+		ImageURL:      url,
+		ImageFilename: filename,
+		ThumbnailSize: fmt.Sprintf("%dx%d", config.Width, config.Height),
+		ThumbnailURL:  url,
+	}})
+}
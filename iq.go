@@ -0,0 +1,66 @@
+package hipchat
+
+import (
+	"context"
+	"github.com/pyalex/hipchat/xmpp"
+	"time"
+)
+
+// IQTimeout is how long requests that do not take a context wait for the
+// server to answer an iq, unless the Config says otherwise.
+var IQTimeout = 30 * time.Second
+
+// sendIQ registers a pending request under a new id, sends it with send and
+// waits for the matching result or error from the server.
+func (c *Client) sendIQ(ctx context.Context, send func(id string) error) (iq *xmpp.IncomingIQ, err error) {
+	id := xmpp.ID()
+	ctx, span := c.startSpan(ctx, "hipchat.iq", map[string]string{"id": id})
+	defer func() {
+		span.End(err)
+	}()
+	response := make(chan *xmpp.IncomingIQ, 1)
+
+	c.iqMutex.Lock()
+	c.iqs[id] = response
+	c.iqMutex.Unlock()
+
+	defer func() {
+		c.iqMutex.Lock()
+		delete(c.iqs, id)
+		c.iqMutex.Unlock()
+	}()
+
+	if err := send(id); err != nil {
+		return nil, err
+	}
+
+	select {
+	case iq = <-response:
+		if iq.Type == "error" {
+			return iq, newStanzaError(iq.From, iq.Error)
+		}
+		return iq, nil
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	case <-c.done:
+		return nil, ErrNotConnected
+	}
+}
+
+// resolveIQ hands an iq result or error to the request waiting for it and
+// reports whether there was one.
+func (c *Client) resolveIQ(iq *xmpp.IncomingIQ) bool {
+	if iq.Type != "result" && iq.Type != "error" {
+		return false
+	}
+
+	c.iqMutex.Lock()
+	response, ok := c.iqs[iq.ID]
+	delete(c.iqs, iq.ID)
+	c.iqMutex.Unlock()
+
+	if ok {
+		response <- iq
+	}
+	return ok
+}
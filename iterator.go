@@ -0,0 +1,61 @@
+package hipchat
+
+import (
+	"context"
+	"github.com/pyalex/hipchat/xmpp"
+	"io"
+	"time"
+)
+
+// A HistoryIterator walks through an archive one message at a time, fetching
+// the next page from the server whenever the current one is used up.
+type HistoryIterator struct {
+	client *Client
+	query  xmpp.HistoryQuery
+	page   []Message
+	done   bool
+}
+
+// IterateHistory returns a HistoryIterator over the messages exchanged with
+// jid, a room or a user, starting at start.
+func (c *Client) IterateHistory(jid string, start time.Time) *HistoryIterator {
+	return c.iterateHistory(xmpp.HistoryQuery{With: jid, Start: start})
+}
+
+func (c *Client) iterateHistory(hq xmpp.HistoryQuery) *HistoryIterator {
+	hq.Max = historyPageSize
+	return &HistoryIterator{client: c, query: hq}
+}
+
+// Next returns the next archived message. It returns io.EOF once the archive
+// has been read completely.
+func (it *HistoryIterator) Next(ctx context.Context) (*Message, error) {
+	if len(it.page) == 0 {
+		if it.done {
+			return nil, io.EOF
+		}
+		if err := it.fetch(ctx); err != nil {
+			return nil, err
+		}
+		if len(it.page) == 0 {
+			return nil, io.EOF
+		}
+	}
+
+	m := &it.page[0]
+	it.page = it.page[1:]
+	return m, nil
+}
+
+func (it *HistoryIterator) fetch(ctx context.Context) error {
+	page, err := it.client.loadHistoryPage(ctx, it.query)
+	if err != nil {
+		return err
+	}
+
+	// without a result set there is nothing to page on from
+	it.query.After = page.Last
+	it.done = page.Complete || len(page.Messages) < it.query.Max || page.Last == ""
+	it.page = page.Messages
+	return nil
+}
@@ -0,0 +1,82 @@
+package hipchat
+
+import "strings"
+
+// A JID is an XMPP address of the form local@domain/resource. HipChat user
+// and room JIDs have local parts of the form orgid_name, e.g.
+// 11111_22222@chat.hipchat.com or 11111_room_name@conf.hipchat.com. In room
+// messages the resource is the nickname of the sender.
+type JID struct {
+	Local    string
+	Domain   string
+	Resource string
+}
+
+// A RoomID is the bare JID of a room, e.g.
+// 11111_room_name@conf.hipchat.com. A UserID is the bare JID of a user, e.g.
+// 11111_22222@chat.hipchat.com. They are distinct types so the two can not
+// be mixed up.
+type (
+	RoomID string
+	UserID string
+)
+
+// ParseJID splits s into the parts of a JID.
+func ParseJID(s string) JID {
+	var j JID
+	if i := strings.Index(s, "/"); i >= 0 {
+		s, j.Resource = s[:i], s[i+1:]
+	}
+	if i := strings.Index(s, "@"); i >= 0 {
+		j.Local, s = s[:i], s[i+1:]
+	}
+	j.Domain = s
+	return j
+}
+
+// Bare returns the JID without its resource.
+func (j JID) Bare() string {
+	if j.Local == "" {
+		return j.Domain
+	}
+	return j.Local + "@" + j.Domain
+}
+
+// Full returns the JID including its resource, if it has one.
+func (j JID) Full() string {
+	if j.Resource == "" {
+		return j.Bare()
+	}
+	return j.Bare() + "/" + j.Resource
+}
+
+// RoomID returns the bare JID as a RoomID.
+func (j JID) RoomID() RoomID {
+	return RoomID(j.Bare())
+}
+
+// UserID returns the bare JID as a UserID.
+func (j JID) UserID() UserID {
+	return UserID(j.Bare())
+}
+
+func (j JID) String() string {
+	return j.Full()
+}
+
+// OrgID returns the HipChat organization id of the local part, or "" if the
+// local part does not follow the orgid_name convention.
+func (j JID) OrgID() string {
+	if i := strings.Index(j.Local, "_"); i >= 0 {
+		return j.Local[:i]
+	}
+	return ""
+}
+
+// Name returns the local part without the HipChat organization id.
+func (j JID) Name() string {
+	if i := strings.Index(j.Local, "_"); i >= 0 {
+		return j.Local[i+1:]
+	}
+	return j.Local
+}
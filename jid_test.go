@@ -0,0 +1,65 @@
+package hipchat_test
+
+import (
+	"github.com/pyalex/hipchat"
+	"testing"
+)
+
+func TestParseJID(t *testing.T) {
+	tests := []struct {
+		in    string
+		jid   hipchat.JID
+		org   string
+		name  string
+		bare  string
+		round bool // whether Full returns in again
+	}{
+		{"11111_22222@chat.hipchat.com/bot", hipchat.JID{"11111_22222", "chat.hipchat.com", "bot"}, "11111", "22222", "11111_22222@chat.hipchat.com", true},
+		{"11111_22222@chat.hipchat.com", hipchat.JID{"11111_22222", "chat.hipchat.com", ""}, "11111", "22222", "11111_22222@chat.hipchat.com", true},
+		{"11111_room_name@conf.hipchat.com/Alice Smith", hipchat.JID{"11111_room_name", "conf.hipchat.com", "Alice Smith"}, "11111", "room_name", "11111_room_name@conf.hipchat.com", true},
+		{"11111_ops@conf.hipchat.com", hipchat.JID{"11111_ops", "conf.hipchat.com", ""}, "11111", "ops", "11111_ops@conf.hipchat.com", true},
+		{"room@conf.example.com/nick/with/slashes", hipchat.JID{"room", "conf.example.com", "nick/with/slashes"}, "", "room", "room@conf.example.com", true},
+		{"room@conf.example.com/nick@home", hipchat.JID{"room", "conf.example.com", "nick@home"}, "", "room", "room@conf.example.com", true},
+		{"chat.hipchat.com", hipchat.JID{"", "chat.hipchat.com", ""}, "", "", "chat.hipchat.com", true},
+		{"chat.hipchat.com/resource", hipchat.JID{"", "chat.hipchat.com", "resource"}, "", "", "chat.hipchat.com", true},
+		{"", hipchat.JID{}, "", "", "", true},
+		{"@chat.hipchat.com", hipchat.JID{"", "chat.hipchat.com", ""}, "", "", "chat.hipchat.com", false},
+		{"user@", hipchat.JID{"user", "", ""}, "", "user", "user@", true},
+		{"user@chat.hipchat.com/", hipchat.JID{"user", "chat.hipchat.com", ""}, "", "user", "user@chat.hipchat.com", false},
+	}
+	for _, tt := range tests {
+		jid := hipchat.ParseJID(tt.in)
+		if jid != tt.jid {
+			t.Errorf("ParseJID(%q) = %#v, want %#v", tt.in, jid, tt.jid)
+			continue
+		}
+		if org, name := jid.OrgID(), jid.Name(); org != tt.org || name != tt.name {
+			t.Errorf("ParseJID(%q) has org %q and name %q, want %q and %q", tt.in, org, name, tt.org, tt.name)
+		}
+		if bare := jid.Bare(); bare != tt.bare {
+			t.Errorf("ParseJID(%q).Bare() = %q, want %q", tt.in, bare, tt.bare)
+		}
+		if full := jid.Full(); (full == tt.in) != tt.round {
+			t.Errorf("ParseJID(%q).Full() = %q", tt.in, full)
+		}
+		if again := hipchat.ParseJID(jid.Full()); again != jid {
+			t.Errorf("ParseJID(%q) = %#v does not survive Full", tt.in, jid)
+		}
+		if again := hipchat.ParseJID(jid.Bare()); again.Local != jid.Local || again.Domain != jid.Domain || again.Resource != "" {
+			t.Errorf("ParseJID(%q) = %#v does not survive Bare", tt.in, jid)
+		}
+	}
+}
+
+func TestJIDIDs(t *testing.T) {
+	jid := hipchat.ParseJID("11111_ops@conf.hipchat.com/Alice")
+	if id := jid.RoomID(); id != "11111_ops@conf.hipchat.com" {
+		t.Errorf("RoomID() = %q", id)
+	}
+	if id := jid.UserID(); id != "11111_ops@conf.hipchat.com" {
+		t.Errorf("UserID() = %q", id)
+	}
+	if s := jid.String(); s != "11111_ops@conf.hipchat.com/Alice" {
+		t.Errorf("String() = %q", s)
+	}
+}
@@ -0,0 +1,235 @@
+package hipchat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// An AccountMessage is a message received by one of the clients of a
+// Manager, tagged with the account the client was added as.
+type AccountMessage struct {
+	Account string
+	*Message
+}
+
+// An AccountEvent is something that happened to one of the clients of a
+// Manager. Exactly one of Invite, Error, Disconnect, Reconnected and Closed
+// is set.
+type AccountEvent struct {
+	Account string
+
+	Invite      *Room
+	Error       *StanzaError
+	Disconnect  *DisconnectReason
+	Reconnected bool
+	Closed      bool // the client gave up and was removed from the manager
+}
+
+// ReconnectStagger is how far apart the clients of a Manager reconnect, so a
+// server coming back is not hit by all of them at once.
+var ReconnectStagger = 2 * time.Second
+
+// A Manager owns the clients of several bot accounts running in one process.
+// It merges their messages and events into single channels tagged with the
+// account and closes all of them together. When connections drop, the clients
+// take turns reconnecting, ReconnectStagger apart, each still backing off on
+// failed attempts; the Manager reports the disconnects and reconnects and
+// forgets the clients that give up.
+//
+// The channels of a client added to a Manager must not be read elsewhere, and
+// the client must not have message handlers.
+type Manager struct {
+	messages chan *AccountMessage
+	events   chan *AccountEvent
+
+	mutex         sync.Mutex
+	clients       map[string]*Client
+	nextReconnect time.Time // when the next client may reconnect
+
+	wg        sync.WaitGroup
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewManager creates a Manager without clients.
+func NewManager() *Manager {
+	return &Manager{
+		messages: make(chan *AccountMessage, 20),
+		events:   make(chan *AccountEvent, 10),
+		clients:  make(map[string]*Client),
+		done:     make(chan struct{}),
+	}
+}
+
+// Add hands the client over to the manager under the given account name.
+func (m *Manager) Add(account string, c *Client) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	select {
+	case <-m.done:
+		return ErrNotConnected
+	default:
+	}
+	if _, ok := m.clients[account]; ok {
+		return fmt.Errorf("account %q already added", account)
+	}
+
+	m.clients[account] = c
+	turn := m.reconnectTurn
+	c.reconnectGate.Store(&turn)
+	m.wg.Add(1)
+	go m.forward(account, c)
+	return nil
+}
+
+// reconnectTurn blocks until it is the turn of a client to reconnect and
+// reports false if done or the manager is closed first. Turns are handed out
+// ReconnectStagger apart.
+func (m *Manager) reconnectTurn(done <-chan struct{}) bool {
+	m.mutex.Lock()
+	turn := time.Now()
+	if m.nextReconnect.After(turn) {
+		turn = m.nextReconnect
+	}
+	m.nextReconnect = turn.Add(ReconnectStagger)
+	m.mutex.Unlock()
+
+	t := time.NewTimer(time.Until(turn))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-done:
+		return false
+	case <-m.done:
+		return false
+	}
+}
+
+// Client returns the client of the account, or nil if there is none.
+func (m *Manager) Client(account string) *Client {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.clients[account]
+}
+
+// Accounts returns the names of the accounts the manager has clients for.
+func (m *Manager) Accounts() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	accounts := make([]string, 0, len(m.clients))
+	for account := range m.clients {
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+// Messages returns the messages received by all clients. The channel is
+// closed by Close.
+func (m *Manager) Messages() <-chan *AccountMessage {
+	return m.messages
+}
+
+// Events returns the invites, errors, disconnects and reconnects of all
+// clients. The channel is closed by Close.
+func (m *Manager) Events() <-chan *AccountEvent {
+	return m.events
+}
+
+// Close closes all clients, waits until everything they received has been
+// forwarded or dropped and closes the manager's channels.
+func (m *Manager) Close() {
+	m.closeOnce.Do(func() {
+		m.mutex.Lock()
+		close(m.done)
+		clients := make([]*Client, 0, len(m.clients))
+		for _, c := range m.clients {
+			clients = append(clients, c)
+		}
+		m.mutex.Unlock()
+
+		var wg sync.WaitGroup
+		for _, c := range clients {
+			wg.Add(1)
+			go func(c *Client) {
+				defer wg.Done()
+				c.Close()
+			}(c)
+		}
+		wg.Wait()
+
+		m.wg.Wait()
+		close(m.messages)
+		close(m.events)
+	})
+}
+
+// forward copies the messages and events of a client to the manager's
+// channels until the client or the manager is closed.
+func (m *Manager) forward(account string, c *Client) {
+	defer m.wg.Done()
+
+	for {
+		var event *AccountEvent
+		select {
+		case <-m.done:
+			return
+		case msg, ok := <-c.Messages():
+			if !ok {
+				m.remove(account, c)
+				return
+			}
+			select {
+			case m.messages <- &AccountMessage{Account: account, Message: msg}:
+			case <-m.done:
+				return
+			}
+			continue
+		case r, ok := <-c.Invites():
+			if !ok {
+				m.remove(account, c)
+				return
+			}
+			event = &AccountEvent{Account: account, Invite: r}
+		case err, ok := <-c.Errors():
+			if !ok {
+				m.remove(account, c)
+				return
+			}
+			event = &AccountEvent{Account: account, Error: err}
+		case r := <-c.OnDisconnect:
+			event = &AccountEvent{Account: account, Disconnect: r}
+		case <-c.OnReconnect:
+			event = &AccountEvent{Account: account, Reconnected: true}
+		}
+
+		if !m.sendEvent(event) {
+			return
+		}
+	}
+}
+
+// remove forgets a client that closed on its own and reports it.
+func (m *Manager) remove(account string, c *Client) {
+	m.mutex.Lock()
+	if m.clients[account] == c {
+		delete(m.clients, account)
+	}
+	m.mutex.Unlock()
+
+	m.sendEvent(&AccountEvent{Account: account, Closed: true})
+}
+
+// sendEvent delivers the event unless the manager is closed first and reports
+// whether it was delivered.
+func (m *Manager) sendEvent(event *AccountEvent) bool {
+	select {
+	case m.events <- event:
+		return true
+	case <-m.done:
+		return false
+	}
+}
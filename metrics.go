@@ -0,0 +1,20 @@
+package hipchat
+
+import "time"
+
+// A MetricsCollector receives counters and durations, e.g. to export them to
+// a monitoring system. Names are snake case and labels are given as a map from
+// label name to value; a metric is always reported with the same label names.
+// Implementations must be safe for concurrent use.
+type MetricsCollector interface {
+	// IncCounter adds one to the counter.
+	IncCounter(name string, labels map[string]string)
+	// ObserveDuration records a duration in the histogram.
+	ObserveDuration(name string, d time.Duration, labels map[string]string)
+}
+
+// NopMetrics is a MetricsCollector discarding everything.
+type NopMetrics struct{}
+
+func (NopMetrics) IncCounter(string, map[string]string)                     {}
+func (NopMetrics) ObserveDuration(string, time.Duration, map[string]string) {}
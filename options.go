@@ -0,0 +1,199 @@
+package hipchat
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// An Option configures a Client created by NewClient.
+type Option func(*Client) error
+
+// WithDeadTimeout sets how long the connection may stay silent before it is
+// considered dead, closed and reestablished. Keepalive pings are answered by
+// healthy servers, so silence means the connection is gone. The default is five
+// minutes.
+func WithDeadTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return errors.New("dead timeout must be positive")
+		}
+		c.deadTimeout = timeout
+		return nil
+	}
+}
+
+// WithKeepAliveInterval sets how often the client pings the server to keep the
+// connection from idling. It must be shorter than the dead timeout and than
+// the idle time after which the server drops connections. The default is two
+// minutes.
+func WithKeepAliveInterval(interval time.Duration) Option {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return errors.New("keepalive interval must be positive")
+		}
+		c.keepAliveInterval = interval
+		return nil
+	}
+}
+
+// WithMessageBuffer sets the capacity of the Messages channel. The default is
+// 20.
+func WithMessageBuffer(size int) Option {
+	return func(c *Client) error {
+		if size < 0 {
+			return errors.New("message buffer size must not be negative")
+		}
+		c.receivedMessage = make(chan *Message, size)
+		return nil
+	}
+}
+
+// WithInviteBuffer sets the capacity of the Invites channel. The default is
+// 10.
+func WithInviteBuffer(size int) Option {
+	return func(c *Client) error {
+		if size < 0 {
+			return errors.New("invite buffer size must not be negative")
+		}
+		c.receivedInvites = make(chan *Room, size)
+		return nil
+	}
+}
+
+// WithOverflowPolicy sets what happens to incoming messages when the Messages
+// channel is full. The default is OverflowBlock, which stalls the connection
+// until the consumer catches up.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(c *Client) error {
+		switch policy {
+		case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+		default:
+			return errors.New("unknown overflow policy")
+		}
+		c.overflow = policy
+		return nil
+	}
+}
+
+// WithHandler makes the client dispatch incoming messages to h on a pool of
+// that many goroutines instead of leaving them on the Messages channel, so a
+// slow handler never stalls the connection. Messages are queued in the
+// Messages buffer and the overflow policy applies when it is full. Handlers
+// may be called concurrently and in any order.
+func WithHandler(h func(*Message), workers int) Option {
+	return func(c *Client) error {
+		if h == nil {
+			return errors.New("handler must not be nil")
+		}
+		if workers <= 0 {
+			return errors.New("number of workers must be positive")
+		}
+		c.messageHandlers = append(c.messageHandlers, h)
+		c.workers = workers
+		return nil
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used after StartTLS. By default the
+// server certificate is verified against the system roots and the name of the
+// host the client connects to. On-premises servers with a certificate issued by
+// a private CA need a config whose RootCAs contains that CA; ServerName may be
+// set if the certificate does not match the host name.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) error {
+		if config == nil {
+			return errors.New("tls config must not be nil")
+		}
+		c.tlsConfig = config
+		return nil
+	}
+}
+
+// WithLogger makes the client write its log records as text to logger. See
+// WithSlog.
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Client) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		c.logger = slog.New(slog.NewTextHandler(logger.Writer(), nil))
+		return nil
+	}
+}
+
+// WithSlog sets the structured logger the client reports its connection
+// lifecycle, dropped messages and skipped stanzas to. Every record has an
+// "event" attribute naming what happened and, where they apply, "room", "id"
+// and "error" attributes. The default is slog.Default().
+func WithSlog(logger *slog.Logger) Option {
+	return func(c *Client) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithWireLog makes the client copy all XML it sends to and receives from the
+// server to w, with the password redacted. It is meant for debugging protocol
+// issues.
+func WithWireLog(w io.Writer) Option {
+	return func(c *Client) error {
+		if w == nil {
+			return errors.New("wire log writer must not be nil")
+		}
+		c.wireLog = w
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client files are uploaded and downloaded with.
+// The default is http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) error {
+		if httpClient == nil {
+			return errors.New("http client must not be nil")
+		}
+		c.httpClient = httpClient
+		return nil
+	}
+}
+
+// WithMetrics makes the client report to m the stanzas it sends and receives
+// by type, reconnects, authentication failures, how long sent messages take
+// to be echoed by SayAck and how long history queries take. See the prom
+// package for a collector exporting them to Prometheus.
+func WithMetrics(m MetricsCollector) Option {
+	return func(c *Client) error {
+		if m == nil {
+			return errors.New("metrics collector must not be nil")
+		}
+		c.metrics = m
+		return nil
+	}
+}
+
+// WithHooks sets the functions called at the milestones of the client's
+// lifecycle. See Hooks.
+func WithHooks(hooks Hooks) Option {
+	return func(c *Client) error {
+		c.hooks = hooks
+		return nil
+	}
+}
+
+// WithPlainTextOnly makes Say and SayAck send attachments as links appended
+// to the plain text body instead of in an XHTML-IM body, which some
+// on-premises servers mangle.
+func WithPlainTextOnly() Option {
+	return func(c *Client) error {
+		c.plainText = true
+		return nil
+	}
+}
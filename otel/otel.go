@@ -0,0 +1,53 @@
+// Package otel reports the round trips of hipchat clients with the server as
+// OpenTelemetry spans:
+//
+//	tracer := hipchatotel.NewTracer(otel.GetTracerProvider())
+//	client, err := hipchat.NewClient(user, pass, resource, hipchat.WithTracer(tracer))
+//
+// where hipchatotel is this package and otel is go.opentelemetry.io/otel.
+package otel
+
+import (
+	"context"
+	"github.com/pyalex/hipchat"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation is the name spans are reported under.
+const instrumentation = "github.com/pyalex/hipchat"
+
+var _ hipchat.Tracer = (*Tracer)(nil)
+
+// A Tracer is a hipchat.Tracer starting OpenTelemetry spans.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer creates a Tracer starting spans with a tracer of the provider.
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return &Tracer{tracer: provider.Tracer(instrumentation)}
+}
+
+// Start starts a client span with the attributes as string attributes.
+func (t *Tracer) Start(ctx context.Context, name string, attrs map[string]string) (context.Context, hipchat.Span) {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		kvs = append(kvs, attribute.String("hipchat."+k, v))
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(kvs...))
+	return ctx, &otelSpan{span: span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,196 @@
+// Package prom exports the metrics reported by hipchat clients and bots to
+// Prometheus. A Collector is a hipchat.MetricsCollector serving what it
+// collected in the Prometheus text format:
+//
+//	metrics := prom.NewCollector()
+//	client, err := hipchat.NewClient(user, pass, resource, hipchat.WithMetrics(metrics))
+//	...
+//	http.Handle("/metrics", metrics)
+//
+// Counters are exported as they are named, durations as histograms in seconds
+// with a _seconds suffix.
+package prom
+
+import (
+	"fmt"
+	"github.com/pyalex/hipchat"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ hipchat.MetricsCollector = (*Collector)(nil)
+
+// DefaultBuckets are the upper bounds in seconds of the histogram buckets
+// durations are sorted into unless NewCollector is given others.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// A Collector collects counters and durations in memory and serves them to
+// Prometheus. It is safe for concurrent use.
+type Collector struct {
+	buckets []float64
+
+	mutex      sync.Mutex
+	counters   map[string]map[string]float64    // by name and labels
+	histograms map[string]map[string]*histogram // by name and labels
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewCollector creates a Collector sorting durations into buckets with the
+// given upper bounds in seconds, or into DefaultBuckets if there are none.
+func NewCollector(buckets ...float64) *Collector {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Collector{
+		buckets:    buckets,
+		counters:   make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogram),
+	}
+}
+
+// IncCounter adds one to the counter.
+func (c *Collector) IncCounter(name string, labels map[string]string) {
+	key := formatLabels(labels)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	series, ok := c.counters[name]
+	if !ok {
+		series = make(map[string]float64)
+		c.counters[name] = series
+	}
+	series[key]++
+}
+
+// ObserveDuration records the duration in the histogram.
+func (c *Collector) ObserveDuration(name string, d time.Duration, labels map[string]string) {
+	key := formatLabels(labels)
+	seconds := d.Seconds()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	series, ok := c.histograms[name]
+	if !ok {
+		series = make(map[string]*histogram)
+		c.histograms[name] = series
+	}
+	h, ok := series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(c.buckets))}
+		series[key] = h
+	}
+	for i, le := range c.buckets {
+		if seconds <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format to w.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var b strings.Builder
+	names := make([]string, 0, len(c.counters))
+	for name := range c.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+		series := c.counters[name]
+		keys := make([]string, 0, len(series))
+		for labels := range series {
+			keys = append(keys, labels)
+		}
+		sort.Strings(keys)
+		for _, labels := range keys {
+			fmt.Fprintf(&b, "%s%s %s\n", name, labels, formatFloat(series[labels]))
+		}
+	}
+	names = names[:0]
+	for name := range c.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metric := name + "_seconds"
+		fmt.Fprintf(&b, "# TYPE %s histogram\n", metric)
+		series := c.histograms[name]
+		keys := make([]string, 0, len(series))
+		for labels := range series {
+			keys = append(keys, labels)
+		}
+		sort.Strings(keys)
+		for _, labels := range keys {
+			h := series[labels]
+			var cumulative uint64
+			for i, le := range c.buckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", metric, withLabel(labels, "le", formatFloat(le)), cumulative)
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", metric, withLabel(labels, "le", "+Inf"), h.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", metric, labels, formatFloat(h.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", metric, labels, h.count)
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// formatLabels renders the labels sorted by name, e.g. {command="ping"}, or
+// "" if there are none.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+quote(value))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds a label to labels rendered by formatLabels.
+func withLabel(labels, name, value string) string {
+	pair := name + "=" + quote(value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+// labelEscaper escapes label values as the text format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,78 @@
+package hipchat
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimitBackoff is how long the client holds back outgoing messages after
+// the server throttled it without saying for how long.
+var RateLimitBackoff = 30 * time.Second
+
+// A RateLimited event is sent on the RateLimits channel when the server
+// throttles the client, either by rejecting a stanza or by closing the stream
+// with a policy violation. Say, SayAck and Status wait for RetryAfter before
+// sending anything else.
+type RateLimited struct {
+	From       string
+	Condition  string
+	Text       string
+	RetryAfter time.Duration
+}
+
+// RateLimits returns a read-only channel of the times the server throttled the
+// client.
+func (c *Client) RateLimits() <-chan *RateLimited {
+	return c.rateLimits
+}
+
+// isRateLimit reports whether a stanza error means the client sent too much.
+func isRateLimit(err *StanzaError) bool {
+	switch err.Condition {
+	case "policy-violation", "resource-constraint":
+		return true
+	}
+	return false
+}
+
+// rateLimited makes outgoing messages wait for the given event's RetryAfter
+// and reports the event on the RateLimits channel.
+func (c *Client) rateLimited(r *RateLimited) {
+	until := time.Now().Add(r.RetryAfter).UnixNano()
+	if until > c.throttledUntil.Load() {
+		c.throttledUntil.Store(until)
+	}
+	c.logger.Warn("rate limited", "event", "rate_limit", "from", r.From, "condition", r.Condition, "retry_after", r.RetryAfter)
+
+	c.deliverMutex.RLock()
+	defer c.deliverMutex.RUnlock()
+
+	if c.Closed() {
+		return
+	}
+
+	select {
+	case c.rateLimits <- r:
+	default:
+	}
+}
+
+// throttle waits until the server allows the client to send again.
+func (c *Client) throttle(ctx context.Context) error {
+	wait := time.Until(time.Unix(0, c.throttledUntil.Load()))
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx)
+	case <-c.done:
+		return ErrNotConnected
+	}
+}
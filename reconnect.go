@@ -0,0 +1,140 @@
+package hipchat
+
+import (
+	"errors"
+	"github.com/pyalex/hipchat/xmpp"
+	"time"
+)
+
+// maxReconnectDelay caps the delay between two reconnection attempts.
+const maxReconnectDelay = time.Minute
+
+// touch records that a stanza has just been received.
+func (c *Client) touch() {
+	c.lastReceived.Store(time.Now().UnixNano())
+}
+
+// watchdog closes the connection once nothing has been received for longer
+// than the dead timeout, which makes listen reconnect. It returns when the
+// client is closed.
+func (c *Client) watchdog() {
+	tick := time.NewTicker(c.deadTimeout / 4)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-tick.C:
+		}
+
+		silence := time.Since(time.Unix(0, c.lastReceived.Load()))
+		if silence > c.deadTimeout {
+			c.logger.Warn("connection dead", "event", "dead", "silence", silence)
+			c.conn().Close()
+		}
+	}
+}
+
+// reconnectLoop reconnects to the configured server until it succeeds or the
+// client is closed, backing off between attempts. It reports whether the
+// client is connected again. Rejected credentials are not retried: the client
+// is closed with ErrAuthFailed as the reason it disconnected.
+func (c *Client) reconnectLoop() bool {
+	delay := time.Second
+	for {
+		if !c.reconnectTurn() {
+			return false
+		}
+		err := c.reconnect(c.config.addr())
+		if err == nil {
+			return true
+		}
+		c.logger.Error("reconnect failed", "event", "reconnect", "error", err)
+		if errors.Is(err, ErrAuthFailed) {
+			c.setDisconnectReason(&DisconnectReason{Err: err})
+			c.Close()
+			return false
+		}
+
+		select {
+		case <-c.done:
+			return false
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// reconnectTurn waits until the Manager the client belongs to, if any, lets
+// it reconnect and reports false if the client or the manager is closed
+// first.
+func (c *Client) reconnectTurn() bool {
+	if turn := c.reconnectGate.Load(); turn != nil {
+		return (*turn)(c.done)
+	}
+	return true
+}
+
+// dial connects to the XMPP server at addr and sets the connection up to log
+// the wire and count the stanzas sent.
+func (c *Client) dial(addr string) (*xmpp.Conn, error) {
+	connection, err := xmpp.Dial(addr)
+	if err != nil {
+		return connection, err
+	}
+	if c.wireLog != nil {
+		connection.SetDebug(c.wireLog)
+	}
+	connection.SetSendHook(func(stanza string) {
+		if stanza == "message" {
+			c.messagesSent.Add(1)
+		}
+		c.metrics.IncCounter("hipchat_stanzas_sent_total", map[string]string{"type": stanza})
+	})
+	return connection, nil
+}
+
+// reconnect replaces the connection with a new one to host, authenticates
+// and rejoins the rooms the client was in.
+func (c *Client) reconnect(host string) error {
+	c.conn().Close()
+
+	connection, err := c.dial(host)
+	if err != nil {
+		return err
+	}
+
+	c.countBytes()
+	c.setConn(connection)
+	c.publishLifecycle(EventConnected, Event{Addr: host})
+	if err := c.authenticate(); err != nil {
+		return err
+	}
+	c.touch()
+	c.online.Store(true)
+	c.logger.Info("reconnected", "event", "reconnect", "host", host)
+	c.publishLifecycle(EventAuthenticated, Event{})
+
+	c.joinedMutex.Lock()
+	joined := make(map[RoomID]string, len(c.joined))
+	for room, resource := range c.joined {
+		joined[room] = resource
+	}
+	c.joinedMutex.Unlock()
+
+	for room, resource := range joined {
+		c.Join(room, resource, 0)
+	}
+
+	c.publishLifecycle(EventReconnected, Event{})
+
+	select {
+	case c.OnReconnect <- true:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package hipchat
+
+import "context"
+
+// A replyWaiter is a caller of AwaitReply waiting for a message.
+type replyWaiter struct {
+	room  RoomID
+	nick  string
+	reply chan *Message
+}
+
+// AwaitReply waits for the next message sent by the user with the nickname
+// fromNick to the room and returns it, e.g. to have them confirm an action.
+// The message is delivered on the Messages channel and to the handlers as
+// well. AwaitReply returns the context's error if ctx is done first.
+func (c *Client) AwaitReply(ctx context.Context, roomJid RoomID, fromNick string) (*Message, error) {
+	if c.Closed() {
+		return nil, ErrNotConnected
+	}
+
+	w := &replyWaiter{room: roomJid, nick: fromNick, reply: make(chan *Message, 1)}
+	c.repliesMutex.Lock()
+	c.replies = append(c.replies, w)
+	c.repliesMutex.Unlock()
+
+	defer c.removeReplyWaiter(w)
+
+	select {
+	case m := <-w.reply:
+		return m, nil
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	case <-c.done:
+		return nil, ErrNotConnected
+	}
+}
+
+func (c *Client) removeReplyWaiter(w *replyWaiter) {
+	c.repliesMutex.Lock()
+	defer c.repliesMutex.Unlock()
+
+	for i, other := range c.replies {
+		if other == w {
+			c.replies = append(c.replies[:i], c.replies[i+1:]...)
+			return
+		}
+	}
+}
+
+// resolveReplies hands a live room message to the callers of AwaitReply
+// waiting for it.
+func (c *Client) resolveReplies(m *Message) {
+	c.repliesMutex.Lock()
+	defer c.repliesMutex.Unlock()
+
+	waiting := c.replies[:0]
+	for _, w := range c.replies {
+		if w.room == m.RoomJID.RoomID() && w.nick == m.SenderNick {
+			w.reply <- m
+			continue
+		}
+		waiting = append(waiting, w)
+	}
+	c.replies = waiting
+}
@@ -0,0 +1,132 @@
+package rest
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// An Emoticon is a shortcut, e.g. "thumbsup" for (thumbsup), and
+// the URL of its image.
+type Emoticon struct {
+	Shortcut string `json:"shortcut"`
+	URL      string `json:"url"`
+}
+
+// Emoticons returns the global emoticons and the ones added by the group.
+func (c *Client) Emoticons(ctx context.Context) ([]Emoticon, error) {
+	emoticons := make([]Emoticon, 0)
+	err := c.list(ctx, "/emoticon?type=all&max-results=1000", func(items []byte) error {
+		var page []Emoticon
+		if err := unmarshal(items, &page); err != nil {
+			return err
+		}
+		emoticons = append(emoticons, page...)
+		return nil
+	})
+	return emoticons, err
+}
+
+// regexpEmoticon matches emoticon shortcuts in message bodies.
+var regexpEmoticon = regexp.MustCompile(`\(([a-zA-Z0-9]{1,32})\)`)
+
+// An EmoticonCatalog caches the emoticons of the group to resolve the
+// shortcuts in messages to images. It is refreshed when it is older than the
+// refresh interval or by Run.
+type EmoticonCatalog struct {
+	client  *Client
+	refresh time.Duration
+
+	mutex   sync.Mutex
+	urls    map[string]string
+	fetched time.Time
+}
+
+// NewEmoticonCatalog creates an EmoticonCatalog fetching the emoticons with
+// client at most once per refresh interval.
+func NewEmoticonCatalog(client *Client, refresh time.Duration) *EmoticonCatalog {
+	return &EmoticonCatalog{client: client, refresh: refresh}
+}
+
+// Lookup returns the image URL of the emoticon with the shortcut.
+func (cat *EmoticonCatalog) Lookup(ctx context.Context, shortcut string) (string, bool, error) {
+	urls, err := cat.load(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	url, ok := urls[shortcut]
+	return url, ok, nil
+}
+
+// Resolve returns the known emoticons used in a message body in the order
+// they first appear.
+func (cat *EmoticonCatalog) Resolve(ctx context.Context, body string) ([]Emoticon, error) {
+	urls, err := cat.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	emoticons := make([]Emoticon, 0)
+	seen := make(map[string]bool)
+	for _, match := range regexpEmoticon.FindAllStringSubmatch(body, -1) {
+		shortcut := match[1]
+		if url, ok := urls[shortcut]; ok && !seen[shortcut] {
+			seen[shortcut] = true
+			emoticons = append(emoticons, Emoticon{Shortcut: shortcut, URL: url})
+		}
+	}
+	return emoticons, nil
+}
+
+// Run refreshes the catalog every refresh interval until ctx is done, so
+// lookups never wait for the server.
+func (cat *EmoticonCatalog) Run(ctx context.Context) error {
+	tick := time.NewTicker(cat.refresh)
+	defer tick.Stop()
+
+	for {
+		cat.fetch(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+// load returns the cached emoticons, fetching them first if they are stale.
+// Stale emoticons are returned if they can not be fetched.
+func (cat *EmoticonCatalog) load(ctx context.Context) (map[string]string, error) {
+	cat.mutex.Lock()
+	urls, fetched := cat.urls, cat.fetched
+	cat.mutex.Unlock()
+
+	if urls != nil && time.Since(fetched) < cat.refresh {
+		return urls, nil
+	}
+	if err := cat.fetch(ctx); err != nil && urls == nil {
+		return nil, err
+	}
+
+	cat.mutex.Lock()
+	defer cat.mutex.Unlock()
+	return cat.urls, nil
+}
+
+func (cat *EmoticonCatalog) fetch(ctx context.Context) error {
+	emoticons, err := cat.client.Emoticons(ctx)
+	if err != nil {
+		return err
+	}
+
+	urls := make(map[string]string, len(emoticons))
+	for _, e := range emoticons {
+		urls[e.Shortcut] = e.URL
+	}
+
+	cat.mutex.Lock()
+	defer cat.mutex.Unlock()
+	cat.urls, cat.fetched = urls, time.Now()
+	return nil
+}
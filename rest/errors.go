@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+var (
+	// ErrNoToken is returned when a call has no token to authenticate with.
+	ErrNoToken = errors.New("no token")
+	// ErrUnauthorized matches errors reporting that the token is invalid or
+	// expired.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrMissingScope matches errors reporting that the token lacks the scope
+	// the call requires. The Error's Scope names it.
+	ErrMissingScope = errors.New("token lacks the required scope")
+	// ErrRateLimited matches errors reporting that the token sent too many
+	// requests, once retrying did not help.
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// regexpScope finds the scope named in an error message.
+var regexpScope = regexp.MustCompile(`'([a-z_]+)' scope`)
+
+// An Error is an error response of the API.
+type Error struct {
+	StatusCode int
+	Type       string // e.g. "Unauthorized" or "Not Found"
+	Message    string
+	Scope      string // the scope the token lacks, if that is the problem
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return strconv.Itoa(e.StatusCode) + " " + e.Type + ": " + e.Message
+	}
+	return strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode)
+}
+
+// Is makes 401 errors match ErrUnauthorized, errors naming a missing scope
+// match ErrMissingScope and 429 errors match ErrRateLimited.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized && e.Scope == ""
+	case ErrMissingScope:
+		return e.Scope != ""
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// newError reads the error from a response with an error status code.
+func newError(resp *http.Response) error {
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		} `json:"error"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	json.Unmarshal(b, &body)
+
+	e := &Error{StatusCode: resp.StatusCode, Type: body.Error.Type, Message: body.Error.Message}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		if m := regexpScope.FindStringSubmatch(e.Message); m != nil {
+			e.Scope = m[1]
+		}
+	}
+	return e
+}
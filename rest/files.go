@@ -0,0 +1,123 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pyalex/hipchat"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+)
+
+// ErrFileURLNotFound is returned by ShareFile when the file was shared but
+// its URL could not be found in the room's latest history.
+var ErrFileURLNotFound = errors.New("shared file not found in the room history")
+
+// A File is a file shared in a room.
+type File struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	ThumbURL string `json:"thumb_url"`
+	Size     int64  `json:"size"`
+}
+
+// ShareFile uploads the content of r as the file name to the room with the
+// given id or name, along with an optional message, and returns the URL the
+// file can be downloaded from, e.g. to link it in follow-up messages. The
+// content is streamed, not buffered.
+func (c *Client) ShareFile(ctx context.Context, room, name string, r io.Reader, message string) (string, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeShareFile(mw, name, r, message))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/room/"+escape(room)+"/share/file", pr)
+	if err != nil {
+		pr.Close()
+		return "", err
+	}
+	req.Header.Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+	if err := c.send(req, nil); err != nil {
+		pr.CloseWithError(err)
+		return "", err
+	}
+	return c.sharedFileURL(ctx, room, name)
+}
+
+// ShareFilePath is like ShareFile but uploads the file at path under its base
+// name.
+func (c *Client) ShareFilePath(ctx context.Context, room, path, message string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return c.ShareFile(ctx, room, filepath.Base(path), f, message)
+}
+
+// ShareRoomFile is like ShareFilePath but looks the room up by its JID.
+func (c *Client) ShareRoomFile(ctx context.Context, room hipchat.RoomID, path, message string) (string, error) {
+	id, err := c.roomID(ctx, room)
+	if err != nil {
+		return "", err
+	}
+	return c.ShareFilePath(ctx, id, path, message)
+}
+
+// writeShareFile writes the multipart/related body of the share file endpoint:
+// the JSON metadata followed by the file.
+func writeShareFile(mw *multipart.Writer, name string, r io.Reader, message string) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "application/json; charset=UTF-8")
+	h.Set("Content-Disposition", `attachment; name="metadata"`)
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(part).Encode(map[string]string{"message": message}); err != nil {
+		return err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h = make(textproto.MIMEHeader)
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"name": "file", "filename": name}))
+	if part, err = mw.CreatePart(h); err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// sharedFileURL looks up the URL of the file most recently shared under name
+// in the room, since the share file endpoint does not return it.
+func (c *Client) sharedFileURL(ctx context.Context, room, name string) (string, error) {
+	var page struct {
+		Items []struct {
+			File *File `json:"file"`
+		} `json:"items"`
+	}
+	path := fmt.Sprintf("/room/%s/history/latest?max-results=%d", escape(room), 20)
+	if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+		return "", err
+	}
+
+	for i := len(page.Items) - 1; i >= 0; i-- {
+		if f := page.Items[i].File; f != nil && f.Name == name {
+			return f.URL, nil
+		}
+	}
+	return "", ErrFileURLNotFound
+}
@@ -0,0 +1,105 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// A Glance is a status tile an add-on shows in the sidebar of rooms. Glances
+// are registered in the glance section of the add-on's capabilities
+// descriptor, which a Glance marshals to; their content is then served from
+// QueryURL and pushed with UpdateGlance.
+type Glance struct {
+	Key        string
+	Name       string
+	IconURL    string
+	IconURL2x  string
+	QueryURL   string
+	Target     string // key of the web panel or dialog opened on click
+	Conditions []GlanceCondition
+}
+
+// MarshalJSON encodes the glance as the descriptor expects it.
+func (g Glance) MarshalJSON() ([]byte, error) {
+	type icon struct {
+		URL   string `json:"url"`
+		URL2x string `json:"url@2x,omitempty"`
+	}
+	type name struct {
+		Value string `json:"value"`
+	}
+	return json.Marshal(struct {
+		Key        string            `json:"key"`
+		Name       name              `json:"name"`
+		Icon       icon              `json:"icon"`
+		QueryURL   string            `json:"queryUrl,omitempty"`
+		Target     string            `json:"target,omitempty"`
+		Conditions []GlanceCondition `json:"conditions,omitempty"`
+	}{g.Key, name{g.Name}, icon{g.IconURL, g.IconURL2x}, g.QueryURL, g.Target, g.Conditions})
+}
+
+// A GlanceCondition decides whether a glance is shown, e.g. only in rooms
+// where the add-on has been configured.
+type GlanceCondition struct {
+	Condition string                 `json:"condition"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+	Invert    bool                   `json:"invert,omitempty"`
+}
+
+// GlanceMatches returns a condition showing the glance only when the metadata
+// attribute of its content equals value.
+func GlanceMatches(attr string, value interface{}) GlanceCondition {
+	return GlanceCondition{
+		Condition: "glance_matches",
+		Params: map[string]interface{}{
+			"metadata": []map[string]interface{}{{"attr": attr, "eq": value}},
+		},
+	}
+}
+
+// GlanceContent is what a glance shows: an HTML label, an optional status
+// lozenge or icon, and metadata the glance's conditions can match.
+type GlanceContent struct {
+	Label    GlanceLabel            `json:"label"`
+	Status   *GlanceStatus          `json:"status,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// A GlanceLabel is the text of a glance.
+type GlanceLabel struct {
+	Type  string `json:"type"` // "html"
+	Value string `json:"value"`
+}
+
+// A GlanceStatus is the lozenge or icon next to a glance's label. Create it
+// with Lozenge or StatusIcon.
+type GlanceStatus struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// HTMLLabel returns a glance label with the HTML.
+func HTMLLabel(html string) GlanceLabel {
+	return GlanceLabel{Type: "html", Value: html}
+}
+
+// Lozenge returns a status showing label in a lozenge of the type, e.g.
+// "success", "error", "current", "complete", "moved" or "new".
+func Lozenge(label, lozengeType string) *GlanceStatus {
+	return &GlanceStatus{Type: "lozenge", Value: map[string]string{"label": label, "type": lozengeType}}
+}
+
+// StatusIcon returns a status showing an icon.
+func StatusIcon(url, url2x string) *GlanceStatus {
+	return &GlanceStatus{Type: "icon", Value: map[string]string{"url": url, "url@2x": url2x}}
+}
+
+// UpdateGlance pushes new content for the glance with the key to the room
+// with the given id. The call must be made with the add-on's token.
+func (c *Client) UpdateGlance(ctx context.Context, roomID, key string, content *GlanceContent) error {
+	body := map[string]interface{}{
+		"glance": []interface{}{map[string]interface{}{"key": key, "content": content}},
+	}
+	return c.do(ctx, http.MethodPost, "/addon/ui/room/"+escape(roomID), body, nil)
+}
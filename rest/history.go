@@ -0,0 +1,192 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pyalex/hipchat"
+	"github.com/pyalex/hipchat/xmpp"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+type restSender struct {
+	Name        string `json:"name"`
+	MentionName string `json:"mention_name"`
+}
+
+// UnmarshalJSON accepts the sender of a notification, which is a plain label,
+// as well as a user.
+func (s *restSender) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		s.Name = name
+		return nil
+	}
+	type sender restSender
+	return json.Unmarshal(b, (*sender)(s))
+}
+
+type restMessage struct {
+	ID      string          `json:"id"`
+	Date    time.Time       `json:"date"`
+	From    restSender      `json:"from"`
+	Message string          `json:"message"`
+	File    *File           `json:"file"`
+	Card    json.RawMessage `json:"card"` // a card, or a string holding one
+}
+
+// message converts m, sent to the room, into the shape of a message received
+// over XMPP.
+func (m *restMessage) message(room hipchat.RoomID) hipchat.Message {
+	jid := hipchat.ParseJID(string(room))
+	message := hipchat.Message{
+		From:         hipchat.JID{Local: jid.Local, Domain: jid.Domain, Resource: m.From.Name},
+		Body:         m.Message,
+		MentionName:  m.From.MentionName,
+		Stamp:        m.Date,
+		Mid:          m.ID,
+		RoomJID:      hipchat.JID{Local: jid.Local, Domain: jid.Domain},
+		SenderNick:   m.From.Name,
+		IsHistorical: true,
+	}
+	if m.File != nil {
+		message.Attachments = []xmpp.Attachment{{
+			ImageURL:      m.File.URL,
+			ImageFilename: m.File.Name,
+			ThumbnailURL:  m.File.ThumbURL,
+			Size:          m.File.Size,
+			MIMEType:      mime.TypeByExtension(path.Ext(m.File.Name)),
+			Uploaded:      m.Date,
+		}}
+	}
+	if len(m.Card) > 0 {
+		b := []byte(m.Card)
+		var s string
+		if json.Unmarshal(b, &s) == nil {
+			b = []byte(s)
+		}
+		var card hipchat.Card
+		if json.Unmarshal(b, &card) == nil && card.Style != "" {
+			message.Card = &card
+		}
+	}
+	return message
+}
+
+// historyWindow is the span of time RoomHistory reads at first. The API only
+// returns the newest messages of a span, so the history is read span by span
+// starting at start, doubling the span while it holds no messages up to
+// maxHistoryWindow.
+const (
+	historyWindow    = 24 * time.Hour
+	maxHistoryWindow = 365 * 24 * time.Hour
+)
+
+// historyEpoch is when HipChat launched; no room has older messages, so
+// RoomHistory starts there if start is earlier, e.g. zero.
+var historyEpoch = time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// RoomHistory returns the first limit messages sent to the room since start,
+// oldest first, in the shape of messages received over XMPP, or all of them
+// if limit is not positive. The room is looked up by its JID among the rooms
+// the token can see.
+func (c *Client) RoomHistory(ctx context.Context, room hipchat.RoomID, start time.Time, limit int) ([]hipchat.Message, error) {
+	id, err := c.roomID(ctx, room)
+	if err != nil {
+		return nil, err
+	}
+
+	if start.Before(historyEpoch) {
+		start = historyEpoch
+	}
+
+	messages := make([]hipchat.Message, 0)
+	now := time.Now()
+	window := historyWindow
+	for from := start; from.Before(now) && (limit <= 0 || len(messages) < limit); {
+		to := from.Add(window)
+		if to.Before(from) || to.After(now) {
+			to = now
+		}
+		items, err := c.roomHistoryBetween(ctx, id, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			if window *= 2; window > maxHistoryWindow {
+				window = maxHistoryWindow
+			}
+		}
+
+		// items are newest first
+		for i := len(items) - 1; i >= 0 && (limit <= 0 || len(messages) < limit); i-- {
+			// the bounds are inclusive, a message on one belongs to the later span
+			if items[i].Date.Before(from) || (to != now && !items[i].Date.Before(to)) {
+				continue
+			}
+			messages = append(messages, items[i].message(room))
+		}
+		from = to
+	}
+	return messages, nil
+}
+
+// roomHistoryBetween returns all messages sent to the room with the REST id
+// between from and to, newest first.
+func (c *Client) roomHistoryBetween(ctx context.Context, id string, from, to time.Time) ([]restMessage, error) {
+	q := url.Values{}
+	q.Set("date", to.UTC().Format(time.RFC3339Nano))
+	q.Set("end-date", from.UTC().Format(time.RFC3339Nano))
+	q.Set("max-results", "1000")
+	q.Set("reverse", "false") // newest first pages consistently
+	var messages []restMessage
+	err := c.list(ctx, "/room/"+id+"/history?"+q.Encode(), func(items []byte) error {
+		var page []restMessage
+		if err := unmarshal(items, &page); err != nil {
+			return err
+		}
+		messages = append(messages, page...)
+		return nil
+	})
+	return messages, err
+}
+
+// roomID returns the REST id of the room with the JID. The ids of all rooms
+// are cached the first time one is looked up, and again when a room is
+// missing from the cache.
+func (c *Client) roomID(ctx context.Context, room hipchat.RoomID) (string, error) {
+	c.roomIDsMutex.Lock()
+	id, ok := c.roomIDs[string(room)]
+	c.roomIDsMutex.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	ids := make(map[string]string)
+	err := c.list(ctx, "/room?expand=items&max-results=1000", func(items []byte) error {
+		var page []restRoom
+		if err := unmarshal(items, &page); err != nil {
+			return err
+		}
+		for _, r := range page {
+			ids[r.XMPPJID] = strconv.Itoa(r.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	c.roomIDsMutex.Lock()
+	c.roomIDs = ids
+	c.roomIDsMutex.Unlock()
+
+	if id, ok = ids[string(room)]; !ok {
+		return "", &Error{StatusCode: http.StatusNotFound, Type: "Not Found", Message: "no room with the JID " + string(room)}
+	}
+	return id, nil
+}
@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRoomJID = "1_ops@conf.hipchat.com"
+
+// historyServer serves the room testRoomJID with the messages sent at the
+// stamps and counts the history requests.
+func historyServer(t *testing.T, stamps ...time.Time) (*Client, *int32) {
+	t.Helper()
+
+	var requests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/room", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"items": []restRoom{{ID: 1, Name: "Ops", XMPPJID: testRoomJID}},
+		})
+	})
+	mux.HandleFunc("/room/1/history", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		to, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("date"))
+		if err != nil {
+			t.Errorf("date %q: %v", r.URL.Query().Get("date"), err)
+		}
+		from, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("end-date"))
+		if err != nil {
+			t.Errorf("end-date %q: %v", r.URL.Query().Get("end-date"), err)
+		}
+		if to.Before(from) {
+			t.Errorf("history requested from %v back to %v", to, from)
+		}
+
+		items := make([]restMessage, 0)
+		for i := len(stamps) - 1; i >= 0; i-- {
+			if !stamps[i].Before(from) && !stamps[i].After(to) {
+				items = append(items, restMessage{ID: stamps[i].String(), Date: stamps[i], Message: "hi"})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient("token", WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &requests
+}
+
+func TestRoomHistoryZeroStart(t *testing.T) {
+	first := time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second)
+	c, requests := historyServer(t, first, first.Add(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	messages, err := c.RoomHistory(ctx, testRoomJID, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || !messages[0].Stamp.Equal(first) {
+		t.Errorf("read %d messages, want the 2 sent", len(messages))
+	}
+	if n := atomic.LoadInt32(requests); n > 50 {
+		t.Errorf("read the history with %d requests", n)
+	}
+}
+
+func TestRoomHistoryLongGap(t *testing.T) {
+	first := time.Now().AddDate(-3, 0, 0).UTC().Truncate(time.Second)
+	last := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	c, requests := historyServer(t, first, last)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	messages, err := c.RoomHistory(ctx, testRoomJID, first, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || !messages[0].Stamp.Equal(first) || !messages[1].Stamp.Equal(last) {
+		t.Fatalf("read %+v, want the messages at %v and %v", messages, first, last)
+	}
+	if messages[0].RoomJID.Bare() != testRoomJID || !messages[0].IsHistorical {
+		t.Errorf("read message of %v, historical %v", messages[0].RoomJID, messages[0].IsHistorical)
+	}
+	if n := atomic.LoadInt32(requests); n > 20 {
+		t.Errorf("read the history with %d requests", n)
+	}
+
+	// a limit stops before the gap
+	messages, err = c.RoomHistory(ctx, testRoomJID, first, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || !messages[0].Stamp.Equal(first) {
+		t.Errorf("read %+v with limit 1, want the first message", messages)
+	}
+}
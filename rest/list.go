@@ -0,0 +1,38 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// A listPage is a page of a paginated collection.
+type listPage struct {
+	Items json.RawMessage `json:"items"`
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
+
+// list calls fn with the items of every page of the collection at path,
+// following the links to the next pages.
+func (c *Client) list(ctx context.Context, path string, fn func(items []byte) error) error {
+	for path != "" {
+		var page listPage
+		if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return err
+		}
+		if err := fn(page.Items); err != nil {
+			return err
+		}
+		path = page.Links.Next
+	}
+	return nil
+}
+
+func unmarshal(items []byte, v interface{}) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return json.Unmarshal(items, v)
+}
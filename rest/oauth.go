@@ -0,0 +1,139 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Add-ons get their tokens from the client credentials they receive when they
+// are installed: the client id and secret are exchanged at the token endpoint
+// for an access token with the scopes the add-on asked for, which expires and
+// is then exchanged for again.
+
+// expiryMargin is how long before it expires a token is renewed.
+const expiryMargin = time.Minute
+
+// A Token is an access token obtained with client credentials. GroupID and
+// GroupName tell the group the add-on was installed in.
+type Token struct {
+	AccessToken string
+	Expiry      time.Time
+	Scopes      []string
+	GroupID     int
+	GroupName   string
+}
+
+// credentials are the client credentials of an add-on and the last token
+// obtained with them.
+type credentials struct {
+	id     string
+	secret string
+	scopes []string
+
+	token *Token
+	mutex sync.Mutex
+}
+
+// WithClientCredentials makes the client authenticate with access tokens it
+// obtains with the OAuth2 client credentials of an add-on installation and
+// renews before they expire. Tokens passed to NewClient or set with WithToken
+// or WithRoomToken still take precedence.
+func WithClientCredentials(clientID, secret string, scopes ...string) Option {
+	return func(c *Client) error {
+		if clientID == "" || secret == "" {
+			return errors.New("client id and secret must not be empty")
+		}
+		c.credentials = &credentials{id: clientID, secret: secret, scopes: scopes}
+		return nil
+	}
+}
+
+// AccessToken returns the token obtained with the client credentials set with
+// WithClientCredentials, requesting a new one if there is none yet or it is
+// about to expire.
+func (c *Client) AccessToken(ctx context.Context) (*Token, error) {
+	cr := c.credentials
+	if cr == nil {
+		return nil, ErrNoToken
+	}
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if cr.token != nil && time.Until(cr.token.Expiry) > expiryMargin {
+		return cr.token, nil
+	}
+
+	token, err := c.requestToken(ctx, cr)
+	if err != nil {
+		return nil, err
+	}
+	cr.token = token
+	return token, nil
+}
+
+// expireToken drops the token obtained with the client credentials, e.g.
+// after the server rejected it, so the next call requests a new one.
+func (c *Client) expireToken(accessToken string) {
+	cr := c.credentials
+	if cr == nil {
+		return
+	}
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if cr.token != nil && cr.token.AccessToken == accessToken {
+		cr.token = nil
+	}
+}
+
+// requestToken exchanges the client credentials for an access token.
+func (c *Client) requestToken(ctx context.Context, cr *credentials) (*Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(cr.scopes) > 0 {
+		form.Set("scope", strings.Join(cr.scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(cr.id, cr.secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, newError(resp)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Scope       string `json:"scope"`
+		GroupID     int    `json:"group_id"`
+		GroupName   string `json:"group_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if body.AccessToken == "" {
+		return nil, errors.New("token endpoint returned no access token")
+	}
+	return &Token{
+		AccessToken: body.AccessToken,
+		Expiry:      time.Now().Add(time.Duration(body.ExpiresIn) * time.Second),
+		Scopes:      strings.Fields(body.Scope),
+		GroupID:     body.GroupID,
+		GroupName:   body.GroupName,
+	}, nil
+}
@@ -0,0 +1,171 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer issues tokens valid for expiresIn seconds to the client
+// credentials id and secret and counts them. The API at /room accepts the
+// latest token only.
+func tokenServer(t *testing.T, expiresIn int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var issued int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "id" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost || r.FormValue("grant_type") != "client_credentials" {
+			t.Errorf("%s with grant type %q", r.Method, r.FormValue("grant_type"))
+		}
+		n := atomic.AddInt32(&issued, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token" + strconv.Itoa(int(n)),
+			"expires_in":   expiresIn,
+			"scope":        r.FormValue("scope"),
+			"group_id":     42,
+			"group_name":   "Example",
+		})
+	})
+	mux.HandleFunc("/room", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token"+strconv.Itoa(int(atomic.LoadInt32(&issued))) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &issued
+}
+
+func TestAccessToken(t *testing.T) {
+	srv, issued := tokenServer(t, 3600)
+	c, err := NewClient("", WithBaseURL(srv.URL), WithClientCredentials("id", "secret", "send_notification", "view_group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := c.AccessToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "token1" || token.GroupID != 42 || token.GroupName != "Example" {
+		t.Errorf("got %+v", token)
+	}
+	if len(token.Scopes) != 2 || token.Scopes[0] != "send_notification" || token.Scopes[1] != "view_group" {
+		t.Errorf("got the scopes %q, want send_notification and view_group", token.Scopes)
+	}
+	if d := time.Until(token.Expiry); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("token expires in %v, want an hour", d)
+	}
+
+	// the token is used by calls and kept while it is valid
+	if err := get(c); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(issued); n != 1 {
+		t.Errorf("issued %d tokens, want 1", n)
+	}
+}
+
+func TestAccessTokenWrongSecret(t *testing.T) {
+	srv, _ := tokenServer(t, 3600)
+	c, err := NewClient("", WithBaseURL(srv.URL), WithClientCredentials("id", "wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AccessToken(context.Background()); err == nil {
+		t.Error("got a token with the wrong secret")
+	}
+	if err := get(c); err == nil {
+		t.Error("called the API without a token")
+	}
+}
+
+func TestAccessTokenRenewal(t *testing.T) {
+	// a token expiring within expiryMargin is renewed before every use
+	srv, issued := tokenServer(t, 30)
+	c, err := NewClient("", WithBaseURL(srv.URL), WithClientCredentials("id", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := get(c); err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(issued); n != int32(i) {
+			t.Errorf("issued %d tokens for %d calls", n, i)
+		}
+	}
+}
+
+func TestAccessTokenRenewedWhenRejected(t *testing.T) {
+	srv, issued := tokenServer(t, 3600)
+	c, err := NewClient("", WithBaseURL(srv.URL), WithClientCredentials("id", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server revokes the token
+	atomic.AddInt32(issued, 1)
+	if err := get(c); err == nil {
+		t.Fatal("revoked token accepted")
+	}
+	if err := get(c); err != nil {
+		t.Errorf("call after the revocation failed with %v", err)
+	}
+}
+
+func TestAccessTokenConcurrent(t *testing.T) {
+	srv, issued := tokenServer(t, 3600)
+	c, err := NewClient("", WithBaseURL(srv.URL), WithClientCredentials("id", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := get(c); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(issued); n != 1 {
+		t.Errorf("issued %d tokens for concurrent calls, want 1", n)
+	}
+}
+
+func TestClientCredentialsPrecedence(t *testing.T) {
+	srv, issued := tokenServer(t, 3600)
+	c, err := NewClient("personal", WithBaseURL(srv.URL), WithClientCredentials("id", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := c.tokenFor(context.Background()); err != nil || token != "personal" {
+		t.Errorf("authenticated with %q and %v, want the personal token", token, err)
+	}
+	if token, err := c.tokenFor(WithToken(context.Background(), "call")); err != nil || token != "call" {
+		t.Errorf("authenticated with %q and %v, want the call's token", token, err)
+	}
+	if n := atomic.LoadInt32(issued); n != 0 {
+		t.Errorf("issued %d tokens", n)
+	}
+}
@@ -0,0 +1,59 @@
+package rest
+
+import (
+	"context"
+	"github.com/pyalex/hipchat"
+	"net/http"
+)
+
+type restPresence struct {
+	Show     string `json:"show"`
+	Status   string `json:"status"`
+	IsOnline bool   `json:"is_online"`
+}
+
+type restUserPresence struct {
+	restUser
+	Presence *restPresence `json:"presence"`
+}
+
+// UserPresence returns the presence of the user as the REST API reports it,
+// in the shape of an XMPP presence: Type is "unavailable" for users who are
+// offline and Show is "" for users who are available.
+func (c *Client) UserPresence(ctx context.Context, user hipchat.UserID) (*hipchat.Presence, error) {
+	jid := hipchat.ParseJID(string(user))
+	var u restUserPresence
+	if err := c.do(ctx, http.MethodGet, "/user/"+escape(jid.Name()), nil, &u); err != nil {
+		return nil, err
+	}
+
+	p := &hipchat.Presence{From: jid}
+	if u.XMPPJID != "" {
+		p.From = hipchat.ParseJID(u.XMPPJID)
+	}
+	if u.Presence == nil || !u.Presence.IsOnline {
+		p.Type = "unavailable"
+		return p, nil
+	}
+	if u.Presence.Show != "chat" {
+		p.Show = u.Presence.Show
+	}
+	p.Status = u.Presence.Status
+	return p, nil
+}
+
+// RoomParticipants returns the users in the room with the given id or name.
+func (c *Client) RoomParticipants(ctx context.Context, room string) ([]*hipchat.User, error) {
+	users := make([]*hipchat.User, 0)
+	err := c.list(ctx, "/room/"+escape(room)+"/participant?expand=items&max-results=1000", func(items []byte) error {
+		var page []restUser
+		if err := unmarshal(items, &page); err != nil {
+			return err
+		}
+		for i := range page {
+			users = append(users, page[i].user())
+		}
+		return nil
+	})
+	return users, err
+}
@@ -0,0 +1,101 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The API limits how many requests a token may send per five minutes and
+// reports what is left in the X-Ratelimit-Remaining and X-Ratelimit-Reset
+// headers. Once a token has no requests left, its requests wait for the reset
+// instead of being rejected, and requests rejected with 429 anyway are retried
+// after the server's Retry-After.
+
+// maxBackoff caps how long a rejected request waits before a retry when the
+// server does not tell.
+const maxBackoff = 30 * time.Second
+
+// WithRetries sets how often a request rejected by the rate limit is retried.
+// Zero disables retries. The default is 3.
+func WithRetries(retries int) Option {
+	return func(c *Client) error {
+		if retries < 0 {
+			return errors.New("retries must not be negative")
+		}
+		c.retries = retries
+		return nil
+	}
+}
+
+// waitLimit blocks until the token may send again.
+func (c *Client) waitLimit(ctx context.Context, token string) error {
+	c.limitMutex.Lock()
+	resetAt := c.resetAt[token]
+	c.limitMutex.Unlock()
+
+	d := time.Until(resetAt)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// holdUntil makes the requests of the token wait until resetAt.
+func (c *Client) holdUntil(token string, resetAt time.Time) {
+	c.limitMutex.Lock()
+	defer c.limitMutex.Unlock()
+	if resetAt.After(c.resetAt[token]) {
+		c.resetAt[token] = resetAt
+	}
+}
+
+// observeLimit records the rate limit headers of a response.
+func (c *Client) observeLimit(token string, resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-Ratelimit-Remaining"))
+	if err != nil || remaining > 0 {
+		return
+	}
+	if resetAt, ok := parseReset(resp.Header.Get("X-Ratelimit-Reset")); ok {
+		c.holdUntil(token, resetAt)
+	}
+}
+
+// retryDelay returns how long to wait before retrying a request rejected with
+// 429: what Retry-After or X-Ratelimit-Reset tell, or an exponential backoff.
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if s, err := strconv.Atoi(v); err == nil {
+			return time.Duration(s) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return time.Until(t)
+		}
+	}
+	if resetAt, ok := parseReset(resp.Header.Get("X-Ratelimit-Reset")); ok {
+		return time.Until(resetAt)
+	}
+	d := time.Second << attempt
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return d
+}
+
+// parseReset parses an X-Ratelimit-Reset header, a Unix time in seconds.
+func parseReset(v string) (time.Time, bool) {
+	s, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(s, 0), true
+}
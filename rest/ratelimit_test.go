@@ -0,0 +1,158 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// limitServer serves requests with respond, passing the number of the
+// request, counted from 0, and records when they arrive.
+func limitServer(t *testing.T, respond func(w http.ResponseWriter, n int), options ...Option) (*Client, func() []time.Time) {
+	t.Helper()
+
+	var mutex sync.Mutex
+	var arrivals []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		n := len(arrivals)
+		arrivals = append(arrivals, time.Now())
+		mutex.Unlock()
+		respond(w, n)
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient("token", append([]Option{WithBaseURL(srv.URL)}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() []time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]time.Time(nil), arrivals...)
+	}
+}
+
+func get(c *Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.do(ctx, http.MethodGet, "/room", nil, nil)
+}
+
+func TestRateLimitQueuesUntilReset(t *testing.T) {
+	resetAt := time.Unix(time.Now().Add(time.Second).Unix(), 0)
+	c, arrivals := limitServer(t, func(w http.ResponseWriter, n int) {
+		if n == 0 {
+			w.Header().Set("X-Ratelimit-Remaining", "0")
+			w.Header().Set("X-Ratelimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+		} else {
+			w.Header().Set("X-Ratelimit-Remaining", "99")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if err := get(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// the requests sent while the token is out of requests wait for the reset
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := get(c); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	times := arrivals()
+	if len(times) != 4 {
+		t.Fatalf("server got %d requests, want 4", len(times))
+	}
+	for _, at := range times[1:] {
+		if at.Before(resetAt) {
+			t.Errorf("request sent %v before the reset", resetAt.Sub(at))
+		}
+	}
+}
+
+func TestRateLimitRetriesAfter(t *testing.T) {
+	c, arrivals := limitServer(t, func(w http.ResponseWriter, n int) {
+		if n == 0 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if err := get(c); err != nil {
+		t.Fatal(err)
+	}
+	times := arrivals()
+	if len(times) != 2 {
+		t.Fatalf("server got %d requests, want 2", len(times))
+	}
+	if d := times[1].Sub(times[0]); d < time.Second {
+		t.Errorf("retried after %v, want the Retry-After of 1s", d)
+	}
+}
+
+func TestRateLimitRetryLimit(t *testing.T) {
+	tests := []struct {
+		retries  int
+		requests int
+	}{
+		{0, 1},
+		{2, 3},
+	}
+	for _, tt := range tests {
+		c, arrivals := limitServer(t, func(w http.ResponseWriter, n int) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}, WithRetries(tt.retries))
+
+		if err := get(c); !errors.Is(err, ErrRateLimited) {
+			t.Errorf("%d retries: request failed with %v, want ErrRateLimited", tt.retries, err)
+		}
+		if n := len(arrivals()); n != tt.requests {
+			t.Errorf("%d retries: server got %d requests, want %d", tt.retries, n, tt.requests)
+		}
+	}
+
+	if _, err := NewClient("token", WithRetries(-1)); err == nil {
+		t.Error("accepted negative retries")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	reset := time.Now().Add(10 * time.Second)
+	tests := []struct {
+		name   string
+		header http.Header
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"seconds", http.Header{"Retry-After": {"3"}}, 3 * time.Second, 3 * time.Second},
+		{"date", http.Header{"Retry-After": {reset.UTC().Format(http.TimeFormat)}}, 8 * time.Second, 10 * time.Second},
+		{"reset", http.Header{"X-Ratelimit-Reset": {strconv.FormatInt(reset.Unix(), 10)}}, 8 * time.Second, 10 * time.Second},
+		{"backoff", http.Header{}, 4 * time.Second, 4 * time.Second},
+	}
+	for _, tt := range tests {
+		d := retryDelay(&http.Response{Header: tt.header}, 2)
+		if d < tt.min || d > tt.max {
+			t.Errorf("%s: delay %v, want between %v and %v", tt.name, d, tt.min, tt.max)
+		}
+	}
+	if d := retryDelay(&http.Response{Header: http.Header{}}, 10); d != maxBackoff {
+		t.Errorf("delay %v after 10 attempts, want %v", d, maxBackoff)
+	}
+}
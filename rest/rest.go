@@ -0,0 +1,182 @@
+// Package rest is a client for the HipChat REST API v2. It complements the
+// XMPP client of the hipchat package with what XMPP can not do, e.g. colored
+// room notifications, and returns rooms and users as the hipchat package's
+// types:
+//
+//	api, err := rest.NewClient(token)
+//	if err != nil {
+//		return err
+//	}
+//	err = api.SendNotification(ctx, "Ops", &rest.Notification{Message: "deployed", Color: rest.ColorGreen})
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/pyalex/hipchat"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ hipchat.Fallback = (*Client)(nil)
+
+// DefaultBaseURL is the API endpoint of hipchat.com.
+const DefaultBaseURL = "https://api.hipchat.com/v2"
+
+// A Client calls the HipChat REST API with an access token. It is safe for
+// concurrent use.
+type Client struct {
+	baseURL     string
+	token       string
+	roomTokens  map[string]string
+	credentials *credentials
+	httpClient  *http.Client
+	retries     int
+
+	resetAt    map[string]time.Time // by token, see waitLimit
+	limitMutex sync.Mutex
+
+	roomIDs      map[string]string // by room JID, see roomID
+	roomIDsMutex sync.Mutex
+}
+
+// An Option configures a Client created by NewClient.
+type Option func(*Client) error
+
+// NewClient creates a Client authenticating with the access token, usually a
+// personal access token. It may be empty if every call is made with a room
+// token or a token set with WithToken, or if the client authenticates with
+// client credentials, see WithClientCredentials.
+func NewClient(token string, options ...Option) (*Client, error) {
+	c := &Client{
+		baseURL:    DefaultBaseURL,
+		token:      token,
+		roomTokens: make(map[string]string),
+		httpClient: http.DefaultClient,
+		retries:    3,
+		resetAt:    make(map[string]time.Time),
+	}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// WithBaseURL sets the API endpoint, e.g. https://hipchat.example.com/v2 for
+// an on-premises server. The default is DefaultBaseURL.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) error {
+		if _, err := url.Parse(baseURL); err != nil {
+			return err
+		}
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client requests are sent with. The default is
+// http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) error {
+		if httpClient == nil {
+			return errors.New("http client must not be nil")
+		}
+		c.httpClient = httpClient
+		return nil
+	}
+}
+
+// do sends a request with the JSON encoding of in, if it is not nil, as body
+// and decodes the JSON response into out, if it is not nil. The path is
+// relative to the base URL unless it is an absolute URL, e.g. a link to the
+// next page returned by the server.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		path = c.baseURL + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+// send authenticates and sends the request and decodes the JSON response into
+// out, if it is not nil. It waits while the token is out of requests and
+// retries requests rejected by the rate limit if their body can be sent again.
+func (c *Client) send(req *http.Request, out interface{}) error {
+	ctx := req.Context()
+	token, err := c.tokenFor(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	for attempt := 0; ; attempt++ {
+		if err := c.waitLimit(ctx, token); err != nil {
+			return err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		c.observeLimit(token, resp)
+		if resp.StatusCode == http.StatusUnauthorized {
+			c.expireToken(token)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < c.retries && (req.Body == nil || req.GetBody != nil) {
+			c.holdUntil(token, time.Now().Add(retryDelay(resp, attempt)))
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if req.GetBody != nil {
+				if req.Body, err = req.GetBody(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		return decode(resp, out)
+	}
+}
+
+// decode closes the response after decoding its JSON body into out, if it is
+// not nil, or reading the error it reports.
+func decode(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return newError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// escape escapes a room or user id or name for use in a path.
+func escape(idOrName string) string {
+	return url.PathEscape(idOrName)
+}
@@ -0,0 +1,102 @@
+package rest
+
+import (
+	"context"
+	"github.com/pyalex/hipchat"
+	"net/http"
+)
+
+// Notification colors.
+const (
+	ColorYellow = "yellow"
+	ColorGreen  = "green"
+	ColorRed    = "red"
+	ColorPurple = "purple"
+	ColorGray   = "gray"
+	ColorRandom = "random"
+)
+
+// A Notification is a message sent to a room by an integration rather than by
+// a user. Format is "text" or "html", the default; Notify makes the room's
+// members get notified.
+type Notification struct {
+	Message string        `json:"message"`
+	Format  string        `json:"message_format,omitempty"`
+	Color   string        `json:"color,omitempty"`
+	Notify  bool          `json:"notify,omitempty"`
+	From    string        `json:"from,omitempty"` // label shown next to the sender
+	Card    *hipchat.Card `json:"card,omitempty"`
+}
+
+// SendNotification sends a notification to the room with the given id or
+// name. It authenticates with the room's notification token if the client
+// has one, see WithRoomToken.
+func (c *Client) SendNotification(ctx context.Context, room string, n *Notification) error {
+	return c.do(c.roomContext(ctx, room), http.MethodPost, "/room/"+escape(room)+"/notification", n, nil)
+}
+
+type restOwner struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	MentionName string `json:"mention_name"`
+}
+
+type restRoom struct {
+	ID      int        `json:"id"`
+	Name    string     `json:"name"`
+	XMPPJID string     `json:"xmpp_jid"`
+	Topic   string     `json:"topic"`
+	Owner   *restOwner `json:"owner"`
+}
+
+func (r *restRoom) room() *hipchat.Room {
+	room := &hipchat.Room{Id: hipchat.ParseJID(r.XMPPJID), Name: r.Name, Topic: r.Topic}
+	if r.Owner != nil {
+		room.Owner = r.Owner.MentionName
+	}
+	return room
+}
+
+// Rooms returns all rooms the token can see. The REST API does not tell the
+// JID of room owners, so Owner is the owner's mention name.
+func (c *Client) Rooms(ctx context.Context) ([]*hipchat.Room, error) {
+	rooms := make([]*hipchat.Room, 0)
+	err := c.list(ctx, "/room?expand=items&max-results=1000", func(items []byte) error {
+		var page []restRoom
+		if err := unmarshal(items, &page); err != nil {
+			return err
+		}
+		for i := range page {
+			rooms = append(rooms, page[i].room())
+		}
+		return nil
+	})
+	return rooms, err
+}
+
+// SendMessage sends a plain text message to the room with the given id or
+// name as the user the token belongs to, unlike SendNotification.
+func (c *Client) SendMessage(ctx context.Context, room, message string) error {
+	body := struct {
+		Message string `json:"message"`
+	}{message}
+	return c.do(ctx, http.MethodPost, "/room/"+escape(room)+"/message", &body, nil)
+}
+
+// SendRoomMessage is like SendMessage but looks the room up by its JID.
+func (c *Client) SendRoomMessage(ctx context.Context, room hipchat.RoomID, message string) error {
+	id, err := c.roomID(ctx, room)
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(ctx, id, message)
+}
+
+// SendCard sends a notification with the card to the room with the given id
+// or name. The text is shown by clients that can not render cards.
+func (c *Client) SendCard(ctx context.Context, room, text string, card *hipchat.Card) error {
+	if err := card.Validate(); err != nil {
+		return err
+	}
+	return c.SendNotification(ctx, room, &Notification{Message: text, Format: "text", Card: card})
+}
@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"context"
+	"github.com/pyalex/hipchat"
+	"net/http"
+)
+
+// Room privacy settings.
+const (
+	PrivacyPublic  = "public"
+	PrivacyPrivate = "private"
+)
+
+// RoomSettings are the settings of a room that can be changed with
+// UpdateRoom. Privacy is PrivacyPublic or PrivacyPrivate; Owner is the id of
+// the owner.
+type RoomSettings struct {
+	Name              string `json:"name"`
+	Topic             string `json:"topic"`
+	Privacy           string `json:"privacy"`
+	IsArchived        bool   `json:"is_archived"`
+	IsGuestAccessible bool   `json:"is_guest_accessible"`
+	Owner             int    `json:"-"`
+}
+
+// RoomSettings returns the settings of the room with the given id or name.
+func (c *Client) RoomSettings(ctx context.Context, room string) (*RoomSettings, error) {
+	var r struct {
+		RoomSettings
+		Owner *restOwner `json:"owner"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/room/"+escape(room), nil, &r); err != nil {
+		return nil, err
+	}
+	if r.Owner != nil {
+		r.RoomSettings.Owner = r.Owner.ID
+	}
+	return &r.RoomSettings, nil
+}
+
+// UpdateRoom reads the settings of the room with the given id or name, lets
+// update change them and saves them. The API only replaces all settings at
+// once.
+func (c *Client) UpdateRoom(ctx context.Context, room string, update func(*RoomSettings)) error {
+	s, err := c.RoomSettings(ctx, room)
+	if err != nil {
+		return err
+	}
+	update(s)
+
+	type owner struct {
+		ID int `json:"id"`
+	}
+	body := struct {
+		*RoomSettings
+		Owner owner `json:"owner"`
+	}{s, owner{s.Owner}}
+	return c.do(ctx, http.MethodPut, "/room/"+escape(room), &body, nil)
+}
+
+// SetTopic sets the topic of the room with the given id or name.
+func (c *Client) SetTopic(ctx context.Context, room, topic string) error {
+	body := struct {
+		Topic string `json:"topic"`
+	}{topic}
+	return c.do(ctx, http.MethodPut, "/room/"+escape(room)+"/topic", &body, nil)
+}
+
+// SetRoomTopic is like SetTopic but looks the room up by its JID.
+func (c *Client) SetRoomTopic(ctx context.Context, room hipchat.RoomID, topic string) error {
+	id, err := c.roomID(ctx, room)
+	if err != nil {
+		return err
+	}
+	return c.SetTopic(ctx, id, topic)
+}
+
+// RenameRoom renames the room with the given id or name.
+func (c *Client) RenameRoom(ctx context.Context, room, name string) error {
+	return c.UpdateRoom(ctx, room, func(s *RoomSettings) {
+		s.Name = name
+	})
+}
+
+// SetPrivacy makes the room with the given id or name public or private.
+func (c *Client) SetPrivacy(ctx context.Context, room, privacy string) error {
+	return c.UpdateRoom(ctx, room, func(s *RoomSettings) {
+		s.Privacy = privacy
+	})
+}
+
+// SetGuestAccess allows or forbids guests in the room with the given id or
+// name.
+func (c *Client) SetGuestAccess(ctx context.Context, room string, allowed bool) error {
+	return c.UpdateRoom(ctx, room, func(s *RoomSettings) {
+		s.IsGuestAccessible = allowed
+	})
+}
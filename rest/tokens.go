@@ -0,0 +1,63 @@
+package rest
+
+import (
+	"context"
+	"errors"
+)
+
+// HipChat has personal access tokens, which act as a user with the scopes
+// chosen when the token was created, and room notification tokens, which can
+// only send notifications to the room they were created for. NewClient takes
+// the token used by default; WithRoomToken adds room tokens, and WithToken
+// overrides the token of a single call. Add-ons obtain tokens with client
+// credentials instead, see WithClientCredentials.
+
+type tokenKey struct{}
+
+// WithToken returns a context making the calls it is passed to authenticate
+// with token instead of the client's tokens.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// WithRoomToken makes SendNotification authenticate with the room
+// notification token when it sends to the room with the given id or name.
+func WithRoomToken(room, token string) Option {
+	return func(c *Client) error {
+		if room == "" || token == "" {
+			return errors.New("room and token must not be empty")
+		}
+		c.roomTokens[room] = token
+		return nil
+	}
+}
+
+// tokenFor returns the token a request with ctx authenticates with.
+func (c *Client) tokenFor(ctx context.Context) (string, error) {
+	if token, ok := ctx.Value(tokenKey{}).(string); ok && token != "" {
+		return token, nil
+	}
+	if c.token != "" {
+		return c.token, nil
+	}
+	if c.credentials == nil {
+		return "", ErrNoToken
+	}
+	token, err := c.AccessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// roomContext returns ctx with the notification token of the room, unless
+// the call's token is overridden or the room has none.
+func (c *Client) roomContext(ctx context.Context, room string) context.Context {
+	if _, ok := ctx.Value(tokenKey{}).(string); ok {
+		return ctx
+	}
+	if token, ok := c.roomTokens[room]; ok {
+		return WithToken(ctx, token)
+	}
+	return ctx
+}
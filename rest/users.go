@@ -0,0 +1,55 @@
+package rest
+
+import (
+	"context"
+	"github.com/pyalex/hipchat"
+	"net/http"
+)
+
+type restUser struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	MentionName string `json:"mention_name"`
+	XMPPJID     string `json:"xmpp_jid"`
+	Email       string `json:"email"`
+}
+
+func (u *restUser) user() *hipchat.User {
+	return &hipchat.User{Id: hipchat.ParseJID(u.XMPPJID), Name: u.Name, MentionName: u.MentionName}
+}
+
+// Users returns all users of the group.
+func (c *Client) Users(ctx context.Context) ([]*hipchat.User, error) {
+	users := make([]*hipchat.User, 0)
+	err := c.list(ctx, "/user?expand=items&max-results=1000", func(items []byte) error {
+		var page []restUser
+		if err := unmarshal(items, &page); err != nil {
+			return err
+		}
+		for i := range page {
+			users = append(users, page[i].user())
+		}
+		return nil
+	})
+	return users, err
+}
+
+// A PrivateMessage is a message sent to a user in a one to one chat. Format is
+// "text", the default, or "html"; Notify makes the user get notified.
+type PrivateMessage struct {
+	Message string `json:"message"`
+	Format  string `json:"message_format,omitempty"`
+	Notify  bool   `json:"notify,omitempty"`
+}
+
+// SendPrivateMessage sends a plain text message to the user with the given
+// id, email address or @mention name, without the XMPP session the hipchat
+// package's SendPrivate needs.
+func (c *Client) SendPrivateMessage(ctx context.Context, user, message string) error {
+	return c.SendPrivate(ctx, user, &PrivateMessage{Message: message, Format: "text"})
+}
+
+// SendPrivate is like SendPrivateMessage but sends m as it is.
+func (c *Client) SendPrivate(ctx context.Context, user string, m *PrivateMessage) error {
+	return c.do(ctx, http.MethodPost, "/user/"+escape(user)+"/message", m, nil)
+}
@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// Webhook events.
+const (
+	EventRoomMessage      = "room_message"
+	EventRoomNotification = "room_notification"
+	EventRoomEnter        = "room_enter"
+	EventRoomExit         = "room_exit"
+	EventRoomTopicChange  = "room_topic_change"
+)
+
+// A Webhook makes HipChat POST the events of a room to URL. Pattern, a regular
+// expression, limits room_message webhooks to matching messages.
+type Webhook struct {
+	ID      int    `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Event   string `json:"event"`
+	Pattern string `json:"pattern,omitempty"`
+	URL     string `json:"url"`
+}
+
+// CreateWebhook adds the webhook to the room with the given id or name and
+// returns its id.
+func (c *Client) CreateWebhook(ctx context.Context, room string, w *Webhook) (int, error) {
+	var created struct {
+		ID int `json:"id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/room/"+escape(room)+"/webhook", w, &created)
+	return created.ID, err
+}
+
+// Webhooks returns the webhooks of the room with the given id or name.
+func (c *Client) Webhooks(ctx context.Context, room string) ([]*Webhook, error) {
+	webhooks := make([]*Webhook, 0)
+	err := c.list(ctx, "/room/"+escape(room)+"/webhook?max-results=1000", func(items []byte) error {
+		var page []*Webhook
+		if err := unmarshal(items, &page); err != nil {
+			return err
+		}
+		webhooks = append(webhooks, page...)
+		return nil
+	})
+	return webhooks, err
+}
+
+// DeleteWebhook removes the webhook with the id from the room with the given
+// id or name.
+func (c *Client) DeleteWebhook(ctx context.Context, room string, id int) error {
+	return c.do(ctx, http.MethodDelete, "/room/"+escape(room)+"/webhook/"+strconv.Itoa(id), nil, nil)
+}
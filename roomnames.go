@@ -0,0 +1,34 @@
+package hipchat
+
+// cacheRoomNames remembers the names of the rooms so messages can be tagged
+// with the name of their room.
+func (c *Client) cacheRoomNames(rooms []*Room) {
+	c.roomNamesMutex.Lock()
+	defer c.roomNamesMutex.Unlock()
+
+	for _, r := range rooms {
+		c.roomNames[r.Id.Bare()] = r.Name
+	}
+}
+
+// roomName returns the cached name of the room, or "" if it is not known.
+func (c *Client) roomName(roomJid string) string {
+	c.roomNamesMutex.RLock()
+	defer c.roomNamesMutex.RUnlock()
+	return c.roomNames[roomJid]
+}
+
+// address fills in the room and sender fields of m from its From address and
+// the sender's real JID, if the server sent it.
+func (c *Client) address(m *Message, senderJid string) {
+	if c.isRoom(m.From.Bare()) {
+		m.RoomJID = JID{Local: m.From.Local, Domain: m.From.Domain}
+		m.RoomName = c.roomName(m.RoomJID.Bare())
+		m.SenderNick = m.From.Resource
+		if senderJid != "" {
+			m.SenderJID = ParseJID(senderJid)
+		}
+		return
+	}
+	m.SenderJID = m.From
+}
@@ -0,0 +1,43 @@
+package hipchat
+
+import "sync"
+
+// seenSize is the number of message ids remembered to drop messages that are
+// delivered twice, e.g. by the join history replay and by CatchUp.
+const seenSize = 1000
+
+// seenSet remembers the most recent message ids up to a fixed size.
+type seenSet struct {
+	mutex sync.Mutex
+	ids   map[string]bool
+	order []string
+	next  int
+}
+
+func newSeenSet(size int) *seenSet {
+	return &seenSet{
+		ids:   make(map[string]bool, size),
+		order: make([]string, size),
+	}
+}
+
+// add records the id and reports whether it had not been seen before. Empty
+// ids are never considered duplicates.
+func (s *seenSet) add(id string) bool {
+	if id == "" {
+		return true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ids[id] {
+		return false
+	}
+
+	delete(s.ids, s.order[s.next])
+	s.order[s.next] = id
+	s.next = (s.next + 1) % len(s.order)
+	s.ids[id] = true
+	return true
+}
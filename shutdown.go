@@ -0,0 +1,85 @@
+package hipchat
+
+import (
+	"context"
+	"time"
+)
+
+// drainInterval is how often Shutdown checks whether the received messages
+// have been consumed.
+const drainInterval = 10 * time.Millisecond
+
+// Shutdown closes the client gracefully. It stops accepting new sends, waits
+// for the sends in flight, waits until the messages already received have
+// been read from the Messages channel or handled, leaves the joined rooms and
+// ends the stream before closing the connection and the channels like Close.
+// If ctx is done first, the client is closed right away and the context's
+// error is returned.
+func (c *Client) Shutdown(ctx context.Context) error {
+	if c.Closed() {
+		return nil
+	}
+	defer c.Close()
+
+	c.sendMutex.Lock()
+	c.draining = true
+	c.sendMutex.Unlock()
+	c.logger.Info("shutting down", "event", "shutdown")
+
+	flushed := make(chan struct{})
+	go func() {
+		c.sends.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+
+	tick := time.NewTicker(drainInterval)
+	defer tick.Stop()
+	for len(c.receivedMessage) > 0 || c.busy.Load() > 0 {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return contextError(ctx)
+		case <-c.done:
+			return nil
+		}
+	}
+
+	c.joinedMutex.Lock()
+	joined := make(map[RoomID]string, len(c.joined))
+	for room, resource := range c.joined {
+		joined[room] = resource
+	}
+	c.joinedMutex.Unlock()
+
+	for room, resource := range joined {
+		c.Leave(room, resource)
+	}
+	c.conn().EndStream()
+	return nil
+}
+
+// shuttingDown reports whether Shutdown was called.
+func (c *Client) shuttingDown() bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	return c.draining
+}
+
+// beginSend registers a send with Shutdown, which waits for it to finish.
+// The caller must call c.sends.Done once the send is over. It returns
+// ErrNotConnected once the client is closed or shutting down.
+func (c *Client) beginSend() error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.Closed() || c.draining {
+		return ErrNotConnected
+	}
+	c.sends.Add(1)
+	return nil
+}
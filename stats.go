@@ -0,0 +1,43 @@
+package hipchat
+
+import "time"
+
+// Stats is a snapshot of what a client has done since it was created, e.g.
+// for a health endpoint. Bytes are counted on the network, after encryption.
+type Stats struct {
+	MessagesReceived uint64 // live messages, not replayed history
+	MessagesSent     uint64
+	MessagesDropped  uint64 // see DroppedMessages
+	BytesReceived    int64
+	BytesSent        int64
+	Reconnects       uint64
+	JoinedRooms      []RoomID
+	LastStanza       time.Time // when the last stanza was received
+	Uptime           time.Duration
+	Connected        bool
+}
+
+// Stats returns the current statistics of the client.
+func (c *Client) Stats() Stats {
+	read, written := c.conn().BytesTransferred()
+	return Stats{
+		MessagesReceived: c.messagesReceived.Load(),
+		MessagesSent:     c.messagesSent.Load(),
+		MessagesDropped:  c.dropped.Load(),
+		BytesReceived:    c.bytesReceived.Load() + read,
+		BytesSent:        c.bytesSent.Load() + written,
+		Reconnects:       c.reconnects.Load(),
+		JoinedRooms:      c.JoinedRooms(),
+		LastStanza:       time.Unix(0, c.lastReceived.Load()),
+		Uptime:           time.Since(c.started),
+		Connected:        c.Connected(),
+	}
+}
+
+// countBytes adds the bytes transferred by a connection about to be replaced
+// to the totals.
+func (c *Client) countBytes() {
+	read, written := c.conn().BytesTransferred()
+	c.bytesReceived.Add(read)
+	c.bytesSent.Add(written)
+}
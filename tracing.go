@@ -0,0 +1,40 @@
+package hipchat
+
+import "context"
+
+// A Tracer traces round trips with the server: IQ requests and their
+// responses, messages sent with SayAck until the room echoes them and history
+// queries. Spans are started from the context of the call, so they nest in the
+// caller's trace. See the otel package for a Tracer reporting to
+// OpenTelemetry. Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start starts a span with the name and attributes and returns it along
+	// with a context carrying it.
+	Start(ctx context.Context, name string, attrs map[string]string) (context.Context, Span)
+}
+
+// A Span is a traced operation.
+type Span interface {
+	// End ends the span, marking it as failed if err is not nil.
+	End(err error)
+}
+
+// WithTracer makes the client trace its round trips with the server with t.
+func WithTracer(t Tracer) Option {
+	return func(c *Client) error {
+		c.tracer = t
+		return nil
+	}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) End(error) {}
+
+// startSpan starts a span with the client's tracer, if it has one.
+func (c *Client) startSpan(ctx context.Context, name string, attrs map[string]string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, nopSpan{}
+	}
+	return c.tracer.Start(ctx, name, attrs)
+}
@@ -0,0 +1,142 @@
+package hipchat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pyalex/hipchat/xmpp"
+	"time"
+)
+
+// A Transport is a way of talking to HipChat: the XMPP session of the client
+// or the fallback set with WithFallback, usually the REST API.
+type Transport int
+
+// The transports SendMessage, GetHistory and ListRooms choose from.
+const (
+	TransportXMPP Transport = iota
+	TransportREST
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TransportXMPP:
+		return "xmpp"
+	case TransportREST:
+		return "rest"
+	}
+	return fmt.Sprintf("Transport(%d)", int(t))
+}
+
+// WithPreferredTransport sets the transport SendMessage, GetHistory and
+// ListRooms try first. The default is TransportXMPP.
+func WithPreferredTransport(t Transport) Option {
+	return func(c *Client) error {
+		switch t {
+		case TransportXMPP, TransportREST:
+		default:
+			return errors.New("unknown transport")
+		}
+		c.preferred = t
+		return nil
+	}
+}
+
+// An operation is a call that can be served by either transport. An
+// implementation is nil if its transport can not serve the call.
+type operation struct {
+	name string
+	xmpp func(ctx context.Context) error
+	rest func(ctx context.Context, f Fallback) error
+}
+
+// perform runs op over the preferred transport if it is available and can
+// serve op, and over the other one if not or if the preferred one fails. The
+// XMPP transport is available while the client is connected, the REST one if
+// the client has a fallback.
+func (c *Client) perform(ctx context.Context, op operation) error {
+	order := []Transport{TransportXMPP, TransportREST}
+	if c.preferred == TransportREST {
+		order[0], order[1] = order[1], order[0]
+	}
+
+	err := ErrNotConnected
+	for _, t := range order {
+		switch {
+		case t == TransportXMPP && op.xmpp != nil && c.Connected():
+			err = op.xmpp(ctx)
+		case t == TransportREST && op.rest != nil && c.fallback != nil:
+			err = op.rest(ctx, c.fallback)
+		default:
+			continue
+		}
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		c.logger.Debug("transport failed", "event", "transport", "op", op.name, "transport", t.String(), "error", err)
+	}
+	return err
+}
+
+// SendMessage sends a plain text message to the room over whichever transport
+// is available, see WithPreferredTransport.
+func (c *Client) SendMessage(ctx context.Context, roomId RoomID, body string) error {
+	return c.perform(ctx, operation{
+		name: "send",
+		xmpp: func(ctx context.Context) error {
+			return c.Say(roomId, "", body, nil)
+		},
+		rest: func(ctx context.Context, f Fallback) error {
+			return f.SendRoomMessage(ctx, roomId, body)
+		},
+	})
+}
+
+// GetHistory returns up to limit messages exchanged with jid since start over
+// whichever transport is available, see WithPreferredTransport. Only the
+// history of rooms can be loaded over REST.
+func (c *Client) GetHistory(ctx context.Context, jid string, start time.Time, limit int) ([]Message, error) {
+	var messages []Message
+	op := operation{
+		name: "history",
+		xmpp: func(ctx context.Context) error {
+			page, err := c.loadHistoryPage(ctx, xmpp.HistoryQuery{With: jid, Start: start, Max: limit})
+			if err != nil {
+				return err
+			}
+			messages = page.Messages
+			return nil
+		},
+	}
+	if c.isRoom(jid) {
+		op.rest = func(ctx context.Context, f Fallback) error {
+			var err error
+			messages, err = c.fallbackHistory(ctx, f, RoomID(jid), start, limit)
+			return err
+		}
+	}
+	err := c.perform(ctx, op)
+	return messages, err
+}
+
+// ListRooms returns the rooms of the group over whichever transport is
+// available, see WithPreferredTransport.
+func (c *Client) ListRooms(ctx context.Context) ([]*Room, error) {
+	var rooms []*Room
+	err := c.perform(ctx, operation{
+		name: "rooms",
+		xmpp: func(ctx context.Context) error {
+			var err error
+			rooms, err = c.RoomsContext(ctx)
+			return err
+		},
+		rest: func(ctx context.Context, f Fallback) error {
+			var err error
+			if rooms, err = f.Rooms(ctx); err == nil {
+				c.cacheRoomNames(rooms)
+			}
+			return err
+		},
+	})
+	return rooms, err
+}
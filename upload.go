@@ -0,0 +1,122 @@
+package hipchat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pyalex/hipchat/xmpp"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ErrUploadUnsupported is returned by Upload when the server advertises no
+// HTTP upload service (XEP-0363).
+var ErrUploadUnsupported = errors.New("server does not support http upload")
+
+// UploadFile uploads the file at path under its base name and returns the URL
+// it can be downloaded from, ready to be attached to a message.
+func (c *Client) UploadFile(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return c.Upload(ctx, filepath.Base(path), f, info.Size(), mime.TypeByExtension(filepath.Ext(path)))
+}
+
+// Upload uploads size bytes read from r as the file name with the HTTP upload
+// service of the server (XEP-0363): it asks the service for a slot, PUTs the
+// content to it and returns the URL the file can be downloaded from. The
+// content type may be "".
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader, size int64, contentType string) (string, error) {
+	service, err := c.uploadService(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	iq, err := c.sendIQ(ctx, func(id string) error {
+		return c.conn().RequestUploadSlot(id, c.Id+"/"+c.Resource, service, name, size, contentType)
+	})
+	if err != nil {
+		return "", err
+	}
+	if iq.Slot == nil || iq.Slot.Put.URL == "" || iq.Slot.Get.URL == "" {
+		return "", errors.New("upload service returned no slot")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, iq.Slot.Put.URL, r)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for _, h := range iq.Slot.Put.Headers {
+		switch h.Name {
+		case "Authorization", "Cookie", "Expires": // the only headers XEP-0363 allows
+			req.Header.Set(h.Name, h.Value)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("upload of %s failed: %s", name, resp.Status)
+	}
+
+	c.logger.Debug("uploaded file", "event", "upload", "name", name, "size", size, "url", iq.Slot.Get.URL)
+	return iq.Slot.Get.URL, nil
+}
+
+// uploadService returns the JID of the HTTP upload service, discovering it
+// among the services of the server the first time.
+func (c *Client) uploadService(ctx context.Context) (string, error) {
+	c.uploadMutex.Lock()
+	defer c.uploadMutex.Unlock()
+	if c.uploadJid != "" {
+		return c.uploadJid, nil
+	}
+
+	iq, err := c.sendIQ(ctx, func(id string) error {
+		return c.conn().Discover(id, c.Id+"/"+c.Resource, c.config.XMPPHost)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	candidates := []string{c.config.XMPPHost}
+	if iq.Query != nil {
+		for _, item := range iq.Query.Items {
+			candidates = append(candidates, item.Jid)
+		}
+	}
+	for _, jid := range candidates {
+		info, err := c.sendIQ(ctx, func(id string) error {
+			return c.conn().DiscoverInfo(id, c.Id+"/"+c.Resource, jid)
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", err
+			}
+			continue
+		}
+		if info.Query != nil && info.Query.HasFeature(xmpp.NsHTTPUpload) {
+			c.uploadJid = jid
+			return jid, nil
+		}
+	}
+	return "", ErrUploadUnsupported
+}
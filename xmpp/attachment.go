@@ -0,0 +1,216 @@
+package xmpp
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif" // decoders for SniffDimensions
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ThumbnailDimensions returns the width and height of the thumbnail in pixels
+// parsed from ThumbnailSize, or false if it does not tell.
+func (a *Attachment) ThumbnailDimensions() (width, height int, ok bool) {
+	return parseDimensions(a.ThumbnailSize)
+}
+
+// DownloadThumbnail writes the thumbnail of the attachment to w. It uses
+// http.DefaultClient if client is nil.
+func (a *Attachment) DownloadThumbnail(ctx context.Context, client *http.Client, w io.Writer) error {
+	if a.ThumbnailURL == "" {
+		return fmt.Errorf("attachment %s has no thumbnail", a.ImageFilename)
+	}
+	_, err := download(ctx, a.ThumbnailURL, w, &DownloadOptions{Client: client})
+	return err
+}
+
+// DownloadOptions configure Attachment.Download. The zero value downloads the
+// whole file with http.DefaultClient.
+type DownloadOptions struct {
+	Client *http.Client
+
+	// Progress, if set, is called after each chunk written with the number
+	// of bytes written so far, including Offset, and the size of the file,
+	// or -1 if the server does not tell.
+	Progress func(written, total int64)
+
+	// Offset is where to start, e.g. the size of a partial file left by an
+	// earlier download that is to be resumed.
+	Offset int64
+
+	// Retries is how often a download that broke off is resumed where it
+	// stopped with a ranged request.
+	Retries int
+}
+
+// Download streams the file of the attachment to w without buffering it and
+// returns the number of bytes written. See DownloadOptions for reporting
+// progress and resuming. opts may be nil.
+func (a *Attachment) Download(ctx context.Context, w io.Writer, opts *DownloadOptions) (int64, error) {
+	return download(ctx, a.ImageURL, w, opts)
+}
+
+// sniffLength is how many bytes SniffDimensions reads at most. The header of
+// an image is at its start, and the dimensions of common formats are found in
+// the first few kilobytes.
+const sniffLength = 64 << 10
+
+// SniffDimensions sets Width and Height, unless the server declared them, by
+// reading the header of the image, so oversized images can be rejected
+// before they are downloaded. Only the start of the file is requested. GIF,
+// JPEG and PNG images are understood. It uses http.DefaultClient if client is
+// nil.
+func (a *Attachment) SniffDimensions(ctx context.Context, client *http.Client) error {
+	if a.Width > 0 && a.Height > 0 {
+		return nil
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.ImageURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes=0-"+strconv.Itoa(sniffLength-1))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("download of %s failed: %s", a.ImageURL, resp.Status)
+	}
+
+	config, _, err := image.DecodeConfig(io.LimitReader(resp.Body, sniffLength))
+	if err != nil {
+		return err
+	}
+	a.Width, a.Height = config.Width, config.Height
+	return nil
+}
+
+// parseDimensions parses dimensions given as width and height separated by
+// an x, e.g. "200x150".
+func parseDimensions(s string) (width, height int, ok bool) {
+	w, h, found := strings.Cut(strings.ToLower(s), "x")
+	if !found {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(strings.TrimSpace(w))
+	if err != nil || width <= 0 {
+		return 0, 0, false
+	}
+	height, err = strconv.Atoi(strings.TrimSpace(h))
+	if err != nil || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// download streams the file at url to w, resuming it with ranged requests
+// as opts allow, and returns the number of bytes written.
+func download(ctx context.Context, url string, w io.Writer, opts *DownloadOptions) (int64, error) {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	offset, total := opts.Offset, int64(-1)
+	for attempt := 0; ; attempt++ {
+		n, size, err := downloadFrom(ctx, client, url, w, offset, total, opts.Progress)
+		offset += n
+		if size >= 0 {
+			total = size
+		}
+		if err == nil || ctx.Err() != nil || attempt >= opts.Retries || !resumable(err) {
+			return offset - opts.Offset, err
+		}
+	}
+}
+
+// downloadFrom writes the file at url from offset on to w. It returns the
+// number of bytes written and the size of the file, or -1 if unknown.
+func downloadFrom(ctx context.Context, client *http.Client, url string, w io.Writer, offset, total int64, progress func(written, total int64)) (int64, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, total, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, total, &brokenError{err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		if resp.ContentLength >= 0 {
+			total = offset + resp.ContentLength
+		}
+	case resp.StatusCode == http.StatusOK:
+		// the server ignored the range, so skip what was written already
+		total = resp.ContentLength
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			return 0, total, &brokenError{err}
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		return 0, offset, nil // nothing left
+	default:
+		return 0, total, fmt.Errorf("download of %s failed: %s", url, resp.Status)
+	}
+
+	var written int64
+	buf := make([]byte, 32<<10)
+	for {
+		n, rerr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return written, total, err
+			}
+			written += int64(n)
+			if progress != nil {
+				progress(offset+written, total)
+			}
+		}
+		if rerr == io.EOF {
+			if total >= 0 && offset+written < total {
+				return written, total, &brokenError{io.ErrUnexpectedEOF}
+			}
+			return written, total, nil
+		}
+		if rerr != nil {
+			return written, total, &brokenError{rerr}
+		}
+	}
+}
+
+// A brokenError is an error of the connection during a download, after which
+// the download can be resumed.
+type brokenError struct {
+	err error
+}
+
+func (e *brokenError) Error() string {
+	return "download broke off: " + e.err.Error()
+}
+
+func (e *brokenError) Unwrap() error {
+	return e.err
+}
+
+// resumable reports whether a download that failed with err can be resumed.
+func resumable(err error) bool {
+	_, ok := err.(*brokenError)
+	return ok
+}
@@ -0,0 +1,168 @@
+package xmpp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const content = "0123456789abcdefghij"
+
+// flakyServer serves content. The first breaks requests send half of what
+// they ask for before the connection drops. Range requests are answered with
+// 206 unless ignoreRange is set. The Range headers of the requests are
+// recorded.
+type flakyServer struct {
+	breaks      int
+	ignoreRange bool
+
+	mutex  sync.Mutex
+	ranges []string
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	broken := len(s.ranges) <= s.breaks
+	s.mutex.Unlock()
+
+	body := content
+	if v := r.Header.Get("Range"); v != "" && !s.ignoreRange {
+		offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(v, "bytes="), "-"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if offset >= len(content) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		body = content[offset:]
+		w.Header().Set("Content-Range", "bytes "+strconv.Itoa(offset)+"-"+strconv.Itoa(len(content)-1)+"/"+strconv.Itoa(len(content)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	if broken {
+		w.Write([]byte(body[:len(body)/2]))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write([]byte(body))
+}
+
+func (s *flakyServer) requests() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func startFlaky(t *testing.T, s *flakyServer) string {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestDownloadResumes(t *testing.T) {
+	for _, ignoreRange := range []bool{false, true} {
+		s := &flakyServer{breaks: 2, ignoreRange: ignoreRange}
+		url := startFlaky(t, s)
+
+		var buf bytes.Buffer
+		var progress [][2]int64
+		opts := &DownloadOptions{Retries: 2, Progress: func(written, total int64) {
+			progress = append(progress, [2]int64{written, total})
+		}}
+		n, err := download(context.Background(), url, &buf, opts)
+		if err != nil {
+			t.Fatalf("ignoring range %v: %v", ignoreRange, err)
+		}
+		if buf.String() != content || n != int64(len(content)) {
+			t.Errorf("ignoring range %v: downloaded %q, %d bytes, want %q", ignoreRange, buf.String(), n, content)
+		}
+
+		// a server ignoring the range sends the first half again, so the
+		// second break leaves nothing new
+		resume := "bytes=15-"
+		if ignoreRange {
+			resume = "bytes=10-"
+		}
+		if ranges := s.requests(); len(ranges) != 3 || ranges[0] != "" || ranges[1] != "bytes=10-" || ranges[2] != resume {
+			t.Errorf("ignoring range %v: requested the ranges %q, want none, 10- and %s", ignoreRange, ranges, resume[6:])
+		}
+
+		var last int64
+		for _, p := range progress {
+			if p[0] <= last || p[1] != int64(len(content)) {
+				t.Errorf("ignoring range %v: reported progress %v", ignoreRange, progress)
+				break
+			}
+			last = p[0]
+		}
+		if last != int64(len(content)) {
+			t.Errorf("ignoring range %v: reported %d bytes at the end", ignoreRange, last)
+		}
+	}
+}
+
+func TestDownloadRetriesExhausted(t *testing.T) {
+	s := &flakyServer{breaks: 2}
+	url := startFlaky(t, s)
+
+	var buf bytes.Buffer
+	n, err := download(context.Background(), url, &buf, &DownloadOptions{Retries: 1})
+	if err == nil || !resumable(err) {
+		t.Fatalf("download failed with %v, want a resumable error", err)
+	}
+	if buf.String() != content[:15] || n != 15 {
+		t.Errorf("downloaded %q, %d bytes, want the first 15", buf.String(), n)
+	}
+	if len(s.requests()) != 2 {
+		t.Errorf("sent %d requests, want 2", len(s.requests()))
+	}
+
+	// the caller resumes where the download broke off
+	n, err = download(context.Background(), url, &buf, &DownloadOptions{Offset: n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != content || n != 5 {
+		t.Errorf("downloaded %q, %d more bytes, want %q", buf.String(), n, content)
+	}
+}
+
+func TestDownloadNothingLeft(t *testing.T) {
+	url := startFlaky(t, &flakyServer{})
+
+	var buf bytes.Buffer
+	n, err := download(context.Background(), url, &buf, &DownloadOptions{Offset: int64(len(content))})
+	if err != nil || n != 0 || buf.Len() != 0 {
+		t.Errorf("downloaded %d bytes with %v, want nothing", n, err)
+	}
+}
+
+func TestDownloadNotFound(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	_, err := download(context.Background(), srv.URL, &bytes.Buffer{}, &DownloadOptions{Retries: 3})
+	if err == nil || resumable(err) {
+		t.Errorf("download failed with %v, want a final error", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,53 @@
+package xmpp
+
+import (
+	"io"
+	"net"
+	"regexp"
+	"sync"
+)
+
+// regexpAuth matches the credentials sent with SASL PLAIN.
+var regexpAuth = regexp.MustCompile(`(<auth[^>]*>)[^<]*(</auth>)`)
+
+// SetDebug makes the connection copy all XML it sends and receives to w, with
+// the credentials redacted. It must be called before anything is read or
+// written.
+func (c *Conn) SetDebug(w io.Writer) {
+	c.outgoing = &teeConn{Conn: c.outgoing, w: &lockedWriter{w: w}}
+	c.setDecoder(c.outgoing)
+}
+
+// teeConn copies the data read from and written to a connection to w.
+type teeConn struct {
+	net.Conn
+	w *lockedWriter
+}
+
+func (t *teeConn) Read(b []byte) (int, error) {
+	n, err := t.Conn.Read(b)
+	if n > 0 {
+		t.w.dump("RECV ", b[:n])
+	}
+	return n, err
+}
+
+func (t *teeConn) Write(b []byte) (int, error) {
+	t.w.dump("SEND ", regexpAuth.ReplaceAll(b, []byte("$1[redacted]$2")))
+	return t.Conn.Write(b)
+}
+
+// lockedWriter serializes the dumps of the reading and writing goroutines.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) dump(prefix string, b []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	io.WriteString(l.w, prefix)
+	l.w.Write(b)
+	io.WriteString(l.w, "\n")
+}
@@ -0,0 +1,139 @@
+package xmpp
+
+import "encoding/xml"
+
+// Outgoing stanzas. They are marshaled with encoding/xml so every value sent
+// to the server is escaped properly.
+
+type outStartTLS struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+}
+
+type outAuth struct {
+	XMLName   xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl auth"`
+	Mechanism string   `xml:"mechanism,attr"`
+	Value     string   `xml:",chardata"`
+}
+
+type outIQ struct {
+	XMLName xml.Name `xml:"iq"`
+	From    string   `xml:"from,attr,omitempty"`
+	To      string   `xml:"to,attr,omitempty"`
+	ID      string   `xml:"id,attr"`
+	Type    string   `xml:"type,attr"`
+	Payload interface{}
+}
+
+type outUploadRequest struct {
+	XMLName     xml.Name `xml:"urn:xmpp:http:upload:0 request"`
+	Filename    string   `xml:"filename,attr"`
+	Size        int64    `xml:"size,attr"`
+	ContentType string   `xml:"content-type,attr,omitempty"`
+}
+
+type outBind struct {
+	XMLName  xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Resource string   `xml:"resource"`
+}
+
+type outSession struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-session session"`
+}
+
+// outQuery is an empty query element; its namespace is set in XMLName.
+type outQuery struct {
+	XMLName xml.Name
+}
+
+type outPing struct {
+	XMLName xml.Name `xml:"urn:xmpp:ping ping"`
+}
+
+type outPresence struct {
+	XMLName xml.Name `xml:"presence"`
+	ID      string   `xml:"id,attr,omitempty"`
+	From    string   `xml:"from,attr,omitempty"`
+	To      string   `xml:"to,attr,omitempty"`
+	Type    string   `xml:"type,attr,omitempty"`
+	Show    string   `xml:"show,omitempty"`
+	Status  string   `xml:"status,omitempty"`
+	MUC     *outMUC
+}
+
+type outMUC struct {
+	XMLName xml.Name      `xml:"http://jabber.org/protocol/muc x"`
+	History outMUCHistory `xml:"history"`
+}
+
+type outMUCHistory struct {
+	MaxStanzas int `xml:"maxstanzas,attr"`
+}
+
+type outMessage struct {
+	XMLName xml.Name `xml:"message"`
+	From    string   `xml:"from,attr,omitempty"`
+	ID      string   `xml:"id,attr"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr"`
+	Body    string   `xml:"body"`
+	HTML    *outHTML
+}
+
+type outSubject struct {
+	XMLName xml.Name `xml:"message"`
+	From    string   `xml:"from,attr,omitempty"`
+	ID      string   `xml:"id,attr"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr"`
+	Subject string   `xml:"subject"`
+}
+
+type outHTML struct {
+	XMLName xml.Name    `xml:"http://jabber.org/protocol/xhtml-im html"`
+	Body    outHTMLBody `xml:"http://www.w3.org/1999/xhtml body"`
+}
+
+type outHTMLBody struct {
+	Paragraphs []outHTMLParagraph `xml:"p"`
+}
+
+type outHTMLParagraph struct {
+	Text   string         `xml:",chardata"`
+	Images []outHTMLImage `xml:"img"`
+	Links  []outHTMLLink  `xml:"a"`
+}
+
+type outHTMLImage struct {
+	Src      string `xml:"src,attr"`
+	Title    string `xml:"title,attr"`
+	LongDesc string `xml:"longdesc,attr"`
+}
+
+type outHTMLLink struct {
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+type outMAMQuery struct {
+	XMLName xml.Name
+	QueryID string      `xml:"queryid,attr"`
+	Form    outDataForm `xml:"jabber:x:data x"`
+	Set     outRSMSet   `xml:"http://jabber.org/protocol/rsm set"`
+}
+
+type outDataForm struct {
+	Type   string         `xml:"type,attr"`
+	Fields []outFormField `xml:"field"`
+}
+
+type outFormField struct {
+	Var   string `xml:"var,attr"`
+	Value string `xml:"value"`
+}
+
+type outRSMSet struct {
+	Max    int     `xml:"max"`
+	After  string  `xml:"after,omitempty"`
+	Before *string `xml:"before"`
+}
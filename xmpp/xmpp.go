@@ -1,16 +1,19 @@
 package xmpp
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/rand"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/xml"
 	"errors"
 	"fmt"
-	"html"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,31 +26,23 @@ const (
 	NsSASL         = "urn:ietf:params:xml:ns:xmpp-sasl"
 	NsBind         = "urn:ietf:params:xml:ns:xmpp-bind"
 	NsSession      = "urn:ietf:params:xml:ns:xmpp-session"
+	NsStanzas      = "urn:ietf:params:xml:ns:xmpp-stanzas"
+	NsStreams      = "urn:ietf:params:xml:ns:xmpp-streams"
 	NsDisco        = "http://jabber.org/protocol/disco#items"
+	NsDiscoInfo    = "http://jabber.org/protocol/disco#info"
 	NsMuc          = "http://jabber.org/protocol/muc"
 	NsMucUser      = "http://jabber.org/protocol/muc#user"
 	NsMucRoom      = "http://hipchat.com/protocol/muc#room"
 	NsMamForward   = "urn:xmpp:forward:0"
 	NsMam          = "urn:xmpp:mam:0"
+	NsMam2         = "urn:xmpp:mam:2"
+	NsPing         = "urn:xmpp:ping"
 	NsHTML         = "http://jabber.org/protocol/xhtml-im"
 	NsXHTML        = "http://www.w3.org/1999/xhtml"
+	NsHipChat      = "http://hipchat.com"
+	NsHTTPUpload   = "urn:xmpp:http:upload:0"
 
-	xmlStream          = "<stream:stream from='%s' to='%s' version='1.0' xml:lang='en' xmlns='%s' xmlns:stream='%s'>"
-	xmlStartTLS        = "<starttls xmlns='%s'/>"
-	xmlStartSession    = "<iq type='set' id='%s'><session xmlns='%s'/></iq>"
-	xmlIqSet           = "<iq type='set' id='%s'><query xmlns='%s'><username>%s</username><password>%s</password><resource>%s</resource></query></iq>"
-	xmlAuth            = "<auth xmlns='%s' mechanism='PLAIN'>%s</auth>"
-	xmlIqBind          = "<iq type='set' id='%s'><bind xmlns='%s'><resource>%s</resource></bind></iq>"
-	xmlIqGet           = "<iq from='%s' to='%s' id='%s' type='get'><query xmlns='%s'/></iq>"
-	xmlPresence        = "<presence from='%s'><show>%s</show></presence>"
-	xmlMUCPresence     = "<presence id='%s' to='%s' from='%s'><x xmlns='%s'><history maxstanzas='%d'/></x></presence>"
-	xmlHTMLBody        = "<html xmlns='%s'><body xmlns='%s'><p>%s</p><p>%s</p></body></html>"
-	xmlHTMLImage       = "<img src='%s' title='%s' longdesc='%s##%s'/>"
-	xmlMUCUnavailable  = "<presence id='%s' from='%s' to='%s' type='unavailable'/>"
-	xmlMUCMessage      = "<message from='%s' id='%s' to='%s' type='groupchat'><body>%s</body>%s</message>"
-	xmlPing            = "<iq from='%s' id='%s' type='get'><ping xmlns='urn:xmpp:ping'/></iq>"
-	xmlIqHistoryFilter = "<field var='%s'><value>%s</value></field>"
-	xmlIqHistory       = "<iq type='set' id='%s'><query xmlns='urn:xmpp:mam:0'><x xmlns='jabber:x:data'>%s</x><set xmlns='http://jabber.org/protocol/rsm'><max>%d</max></set></query></iq>"
+	xmlStream = "<stream:stream from='%s' to='%s' version='1.0' xml:lang='en' xmlns='%s' xmlns:stream='%s'>"
 )
 
 type required struct{}
@@ -67,17 +62,103 @@ type item struct {
 }
 
 type query struct {
-	XMLName xml.Name `xml:"query"`
-	Items   []*item  `xml:"item"`
+	XMLName  xml.Name       `xml:"query"`
+	Items    []*item        `xml:"item"`
+	Features []discoFeature `xml:"feature"`
+}
+
+type discoFeature struct {
+	Var string `xml:"var,attr"`
+}
+
+// HasFeature reports whether the disco#info result lists the feature.
+func (q *query) HasFeature(ns string) bool {
+	for _, f := range q.Features {
+		if f.Var == ns {
+			return true
+		}
+	}
+	return false
 }
 
 type body struct {
 	Body string `xml:",innerxml"`
 }
 
+// A Conn is a connection to an XMPP server. Its methods writing stanzas may be
+// called from several goroutines; reading is not safe for concurrent use.
 type Conn struct {
 	incoming *xml.Decoder
+	raw      *recordingReader
+	stanza   int64 // input offset of the stanza returned by Next
 	outgoing net.Conn
+	host     string
+
+	// writeMutex keeps concurrent writes from interleaving on the socket
+	writeMutex sync.Mutex
+
+	onSend func(stanza string) // see SetSendHook
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+}
+
+// A MalformedError is returned when a stanza could not be decoded. The rest of
+// the stanza has been skipped, so the stream can still be read.
+type MalformedError struct {
+	Name xml.Name
+	Err  error
+}
+
+func (e *MalformedError) Error() string {
+	return "malformed " + e.Name.Local + " stanza: " + e.Err.Error()
+}
+
+func (e *MalformedError) Unwrap() error {
+	return e.Err
+}
+
+// recordingReader feeds the decoder and keeps the bytes it has read since the
+// start of the current stanza, so the decoder can be brought back in step when
+// it gave up on a stanza halfway through.
+type recordingReader struct {
+	r      *bufio.Reader
+	buf    []byte
+	offset int64 // input offset of buf[0]
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+// ReadByte keeps the decoder from wrapping the reader in a buffer of its own,
+// so the input offsets of the decoder and the reader match.
+func (r *recordingReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.buf = append(r.buf, b)
+	}
+	return b, err
+}
+
+// discard forgets the bytes before the input offset.
+func (r *recordingReader) discard(offset int64) {
+	n := copy(r.buf, r.buf[offset-r.offset:])
+	r.buf = r.buf[:n]
+	r.offset = offset
+}
+
+// since returns the bytes read from the input offset on.
+func (r *recordingReader) since(offset int64) []byte {
+	return r.buf[offset-r.offset:]
+}
+
+// setDecoder makes the connection decode what it reads from r.
+func (c *Conn) setDecoder(r io.Reader) {
+	c.raw = &recordingReader{r: bufio.NewReader(r)}
+	c.incoming = xml.NewDecoder(c.raw)
 }
 
 type Message struct {
@@ -86,11 +167,38 @@ type Message struct {
 	Body        string
 }
 
+// An AttachmentKind tells how an attachment is rendered in a message.
+type AttachmentKind int
+
+// The kinds of attachments.
+const (
+	KindImage AttachmentKind = iota // an inline image with a thumbnail
+	KindFile                        // a link to download a file
+	KindLink                        // a link to a web page
+)
+
+// An Attachment is an image, file or link attached to a message. ImageURL and
+// ImageFilename are the URL and name of the attachment whatever its kind.
+// ThumbnailSize is the size of the thumbnail as sent by the server, e.g.
+// "200x150", see ThumbnailDimensions. Size, MIMEType, Uploader, Uploaded,
+// Width and Height describe the file if the server tells, and are zero
+// otherwise.
 type Attachment struct {
+	Kind          AttachmentKind
 	ImageURL      string
 	ImageFilename string
 	ThumbnailSize string
 	ThumbnailURL  string
+
+	Size     int64     // in bytes
+	MIMEType string    // e.g. "image/png"
+	Uploader string    // JID of the user who uploaded the file
+	Uploaded time.Time // when the file was uploaded
+
+	// Width and Height are the dimensions of an image in pixels, see
+	// SniffDimensions for images the server does not describe.
+	Width  int
+	Height int
 }
 
 type MessageDelay struct {
@@ -101,14 +209,75 @@ type IncomingMessage struct {
 	XMLName  xml.Name     `xml:"message"`
 	From     string       `xml:"from,attr"`
 	To       string       `xml:"to,attr"`
+	FromJID  string       `xml:"from_jid,attr"` // real JID of the sender of a room message
 	MID      string       `xml:"id,attr"`
+	Type     string       `xml:"type,attr"`
 	Body     string       `xml:"body"`
 	Delay    MessageDelay `xml:"delay"`
 	HTMLBody body         `xml:"html>body"`
 
-	Invite *invite `xml:"x"`
-	Result body    `xml:"result"`
-	Fin    body    `xml:"fin"`
+	// LegacyDelay and Extension must come before Invite, which matches any
+	// x element
+	LegacyDelay MessageDelay `xml:"jabber:x:delay x"`
+	Extension   Extension    `xml:"http://hipchat.com x"`
+
+	Invite *invite  `xml:"x"`
+	Result archived `xml:"result"`
+	Fin    *Fin     `xml:"fin"`
+	Error  *Error   `xml:"error"`
+}
+
+// An Extension carries what HipChat adds to messages, e.g. the JSON of an
+// application card sent through its REST API or the description of a shared
+// file.
+type Extension struct {
+	Card string         `xml:"card"`
+	File *ExtensionFile `xml:"file"`
+}
+
+// An ExtensionFile describes a file shared in a message.
+type ExtensionFile struct {
+	Name       string `xml:"name"`
+	URL        string `xml:"url"`
+	ThumbURL   string `xml:"thumb_url"`
+	Size       int64  `xml:"size"`
+	MIMEType   string `xml:"mime_type"`
+	UploadedBy string `xml:"uploaded_by"`
+	UploadDate string `xml:"upload_date"`
+	Width      int    `xml:"width"`
+	Height     int    `xml:"height"`
+}
+
+type IncomingPresence struct {
+	XMLName xml.Name `xml:"presence"`
+	From    string   `xml:"from,attr"`
+	To      string   `xml:"to,attr"`
+	ID      string   `xml:"id,attr"`
+	Type    string   `xml:"type,attr"`
+	Show    string   `xml:"show"`
+	Status  string   `xml:"status"`
+	Error   *Error   `xml:"error"`
+}
+
+type archived struct {
+	QueryID string `xml:"queryid,attr"`
+	Body    string `xml:",innerxml"`
+}
+
+// Fin ends a MAM result set. Complete is set when the last page of the
+// archive has been sent.
+type Fin struct {
+	QueryID  string    `xml:"queryid,attr"`
+	Complete bool      `xml:"complete,attr"`
+	Set      ResultSet `xml:"set"`
+}
+
+// A ResultSet describes a page of results (XEP-0059). Count is nil when the
+// server did not send the total number of items.
+type ResultSet struct {
+	First string `xml:"first"`
+	Last  string `xml:"last"`
+	Count *int   `xml:"count"`
 }
 
 type invite struct {
@@ -122,35 +291,212 @@ type xroom struct {
 	Topic string `xml:"topic"`
 }
 
+// A HistoryQuery selects the archived messages returned by History. After
+// is the id of the message the result page starts after. FromEnd requests the
+// last page of the result set instead of the first one, or the page right
+// before the message with the id Before if it is set. Namespace is the MAM
+// version to speak and defaults to NsMam.
+type HistoryQuery struct {
+	Namespace string
+
+	With    string
+	Start   time.Time
+	End     time.Time
+	After   string
+	Before  string
+	FromEnd bool
+	Max     int
+}
+
+type IncomingIQ struct {
+	XMLName xml.Name `xml:"iq"`
+	ID      string   `xml:"id,attr"`
+	Type    string   `xml:"type,attr"`
+	From    string   `xml:"from,attr"`
+
+	Fin   *Fin      `xml:"fin"`
+	Query *query    `xml:"query"`
+	Ping  *required `xml:"urn:xmpp:ping ping"`
+	Bind  *Bound    `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Slot  *Slot     `xml:"urn:xmpp:http:upload:0 slot"`
+	Error *Error    `xml:"error"`
+}
+
+// A Slot is the answer to an HTTP upload request (XEP-0363): the file is to
+// be PUT to Put.URL with the headers of Put and can then be downloaded from
+// Get.URL.
+type Slot struct {
+	Put struct {
+		URL     string       `xml:"url,attr"`
+		Headers []SlotHeader `xml:"header"`
+	} `xml:"put"`
+	Get struct {
+		URL string `xml:"url,attr"`
+	} `xml:"get"`
+}
+
+// A SlotHeader is a header the PUT request of a Slot must carry.
+type SlotHeader struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Bound is the result of binding a resource: the full JID the server assigned.
+type Bound struct {
+	JID string `xml:"jid"`
+}
+
+// An Error is the error child of a stanza. Its condition is given by the
+// name of a child element in the NsStanzas namespace.
+type Error struct {
+	Type     string       `xml:"type,attr"`
+	Children []errorChild `xml:",any"`
+}
+
+type errorChild struct {
+	XMLName xml.Name
+	Text    string `xml:",chardata"`
+}
+
+// A StreamError is sent by the server right before it closes the stream. Its
+// condition is given by the name of a child element in the NsStreams
+// namespace.
+type StreamError struct {
+	Children []errorChild `xml:",any"`
+}
+
+// Condition returns the defined condition of the stream error.
+func (e *StreamError) Condition() string {
+	for _, child := range e.Children {
+		if child.XMLName.Space == NsStreams && child.XMLName.Local != "text" {
+			return child.XMLName.Local
+		}
+	}
+	return "undefined-condition"
+}
+
+// Text returns the human readable description of the stream error, if any.
+func (e *StreamError) Text() string {
+	for _, child := range e.Children {
+		if child.XMLName.Local == "text" {
+			return child.Text
+		}
+	}
+	return ""
+}
+
+// SeeOtherHost returns the host the client is redirected to, if any.
+func (e *StreamError) SeeOtherHost() string {
+	for _, child := range e.Children {
+		if child.XMLName.Space == NsStreams && child.XMLName.Local == "see-other-host" {
+			return strings.TrimSpace(child.Text)
+		}
+	}
+	return ""
+}
+
+// Condition returns the defined condition of the error.
+func (e *Error) Condition() string {
+	for _, child := range e.Children {
+		if child.XMLName.Space == NsStanzas && child.XMLName.Local != "text" {
+			return child.XMLName.Local
+		}
+	}
+	return "undefined-condition"
+}
+
+// Text returns the human readable description of the error, if any.
+func (e *Error) Text() string {
+	for _, child := range e.Children {
+		if child.XMLName.Local == "text" {
+			return child.Text
+		}
+	}
+	return ""
+}
+
+// Stamp returns the timestamp of the message's delay, falling back to the
+// legacy XEP-0091 delay. It is empty for messages that were not delayed.
+func (m *IncomingMessage) Stamp() string {
+	if m.Delay.Stamp != "" {
+		return m.Delay.Stamp
+	}
+	return m.LegacyDelay.Stamp
+}
+
 type ForwardedMessage struct {
 	XMLName xml.Name        `xml:"forwarded"`
 	Message IncomingMessage `xml:"message"`
 	Delay   MessageDelay    `xml:"delay"`
 }
 
-func (c *Conn) Stream(jid, host string) {
-	fmt.Fprintf(c.outgoing, xmlStream, jid, host, NsJabberClient, NsStream)
+func (c *Conn) Stream(jid, host string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	// the stream header stays open, so it can not be marshaled
+	_, err := fmt.Fprintf(c.outgoing, xmlStream, escape(jid), escape(host), NsJabberClient, NsStream)
+	return err
+}
+
+// EndStream closes the stream, telling the server the client is going away.
+func (c *Conn) EndStream() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	_, err := io.WriteString(c.outgoing, "</stream:stream>")
+	return err
 }
 
-func (c *Conn) StartTLS() {
-	fmt.Fprintf(c.outgoing, xmlStartTLS, NsTLS)
+func (c *Conn) StartTLS() error {
+	return c.send(&outStartTLS{})
 }
 
-func (c *Conn) UseTLS() {
-	c.outgoing = tls.Client(c.outgoing, &tls.Config{InsecureSkipVerify: true})
-	c.incoming = xml.NewDecoder(c.outgoing)
+// UseTLS upgrades the connection to TLS after the server agreed to StartTLS.
+// The certificate is verified against config.ServerName, which defaults to the
+// host passed to Dial. A nil config uses the system roots.
+func (c *Conn) UseTLS(config *tls.Config) error {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = c.host
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	// encrypt below the debug tee, so the dump stays readable
+	base := c.outgoing
+	tee, debug := base.(*teeConn)
+	if debug {
+		base = tee.Conn
+	}
+
+	var conn net.Conn = tls.Client(base, config)
+	if err := conn.(*tls.Conn).Handshake(); err != nil {
+		return err
+	}
+	if debug {
+		conn = &teeConn{Conn: conn, w: tee.w}
+	}
+
+	c.outgoing = conn
+	c.setDecoder(c.outgoing)
+	return nil
 }
 
-func (c *Conn) Auth(user string, pass string) {
+func (c *Conn) Auth(user string, pass string) error {
 	raw := "\x00" + user + "\x00" + pass
 	enc := make([]byte, base64.StdEncoding.EncodedLen(len(raw)))
 	base64.StdEncoding.Encode(enc, []byte(raw))
 
-	fmt.Fprintf(c.outgoing, xmlAuth, NsSASL, enc)
+	return c.send(&outAuth{Mechanism: "PLAIN", Value: string(enc)})
 }
 
-func (c *Conn) Bind(resource string) {
-	fmt.Fprintf(c.outgoing, xmlIqBind, id(), NsBind, resource)
+func (c *Conn) Bind(resource string) error {
+	return c.send(&outIQ{ID: id(), Type: "set", Payload: &outBind{Resource: resource}})
 }
 
 func (c *Conn) Features() *features {
@@ -163,6 +509,9 @@ func (c *Conn) Next() (xml.StartElement, error) {
 	var element xml.StartElement
 
 	for {
+		offset := c.incoming.InputOffset()
+		c.raw.discard(offset)
+
 		var err error
 		var t xml.Token
 		t, err = c.incoming.Token()
@@ -177,14 +526,19 @@ func (c *Conn) Next() (xml.StartElement, error) {
 				return element, errors.New("invalid xml response")
 			}
 
+			c.stanza = offset
 			return element, nil
 		}
 	}
 	panic("unreachable")
 }
 
-func (c *Conn) Discover(from, to string) {
-	fmt.Fprintf(c.outgoing, xmlIqGet, from, to, id(), NsDisco)
+func (c *Conn) Discover(id, from, to string) error {
+	return c.send(&outIQ{From: from, To: to, ID: id, Type: "get", Payload: newQuery(NsDisco)})
+}
+
+func (c *Conn) DiscoverInfo(id, from, to string) error {
+	return c.send(&outIQ{From: from, To: to, ID: id, Type: "get", Payload: newQuery(NsDiscoInfo)})
 }
 
 func (c *Conn) Body(start *xml.StartElement) string {
@@ -193,16 +547,82 @@ func (c *Conn) Body(start *xml.StartElement) string {
 	return b.Body
 }
 
-func (c *Conn) Message(start *xml.StartElement) *IncomingMessage {
+func (c *Conn) Message(start *xml.StartElement) (*IncomingMessage, error) {
 	m := new(IncomingMessage)
-	c.incoming.DecodeElement(&m, start)
-	return m
+	return m, c.decode(m, start)
+}
+
+func (c *Conn) IQ(start *xml.StartElement) (*IncomingIQ, error) {
+	iq := new(IncomingIQ)
+	return iq, c.decode(iq, start)
 }
 
-func (c *Conn) ForwardedMessage(start string) *ForwardedMessage {
+func (c *Conn) StreamError(start *xml.StartElement) (*StreamError, error) {
+	e := new(StreamError)
+	return e, c.decode(e, start)
+}
+
+func (c *Conn) DecodePresence(start *xml.StartElement) (*IncomingPresence, error) {
+	p := new(IncomingPresence)
+	return p, c.decode(p, start)
+}
+
+func (c *Conn) ForwardedMessage(start string) (*ForwardedMessage, error) {
 	m := new(ForwardedMessage)
-	xml.Unmarshal([]byte(start), &m)
-	return m
+	if err := xml.Unmarshal([]byte(start), m); err != nil {
+		return m, &MalformedError{Name: xml.Name{Local: "forwarded"}, Err: err}
+	}
+	return m, nil
+}
+
+// decode decodes the stanza starting at start into v. If the stanza is well
+// formed but does not fit v, the rest of it is skipped and a MalformedError is
+// returned. Other errors leave the decoder unusable.
+func (c *Conn) decode(v interface{}, start *xml.StartElement) error {
+	err := c.incoming.DecodeElement(v, start)
+	if err == nil {
+		return nil
+	}
+
+	var syntax *xml.SyntaxError
+	var netErr net.Error
+	if errors.As(err, &syntax) || errors.As(err, &netErr) || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return err
+	}
+
+	for depth := c.depth(); depth > 0; {
+		t, err := c.incoming.Token()
+		if err != nil {
+			return err
+		}
+		switch t.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return &MalformedError{Name: start.Name, Err: err}
+}
+
+// depth returns how many elements of the current stanza, the stanza itself
+// included, the decoder is inside of. It reads what the decoder has consumed
+// of the stanza again.
+func (c *Conn) depth() int {
+	d := xml.NewDecoder(bytes.NewReader(c.raw.since(c.stanza)[:c.incoming.InputOffset()-c.stanza]))
+	depth := 0
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			return depth
+		}
+		switch t.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
 }
 
 func (c *Conn) Query() *query {
@@ -220,74 +640,274 @@ func (c *Conn) Invite(start string) *invite {
 	return i
 }
 
-func (c *Conn) Presence(jid, pres string) {
-	fmt.Fprintf(c.outgoing, xmlPresence, jid, pres)
+func (c *Conn) Presence(jid, pres string) error {
+	return c.send(&outPresence{From: jid, Show: pres})
+}
+
+// PresenceStatus is like Presence but also sends a status message.
+func (c *Conn) PresenceStatus(jid, show, status string) error {
+	return c.send(&outPresence{From: jid, Show: show, Status: status})
 }
 
-func (c *Conn) MUCPresence(roomId, jid string, history int) {
-	fmt.Fprintf(c.outgoing, xmlMUCPresence, id(), roomId, jid, NsMuc, history)
+func (c *Conn) MUCPresence(id, roomId, jid string, history int) error {
+	return c.send(&outPresence{ID: id, To: roomId, From: jid, MUC: &outMUC{History: outMUCHistory{history}}})
 }
 
-func (c *Conn) MUCUnavailable(roomId, jid string) {
-	fmt.Fprintf(c.outgoing, xmlMUCUnavailable, id(), jid, roomId)
+func (c *Conn) MUCUnavailable(roomId, jid string) error {
+	return c.send(&outPresence{ID: id(), From: jid, To: roomId, Type: "unavailable"})
 }
 
-func (c *Conn) MUCSend(to, from, body string, attachments []Attachment) {
+// MUCSend sends a message to the room. The attachments are sent in an
+// XHTML-IM body after the text: the images together in one paragraph and
+// each file or link in a paragraph of its own.
+func (c *Conn) MUCSend(id, to, from, body string, attachments []Attachment) error {
+	m := &outMessage{From: from, ID: id, To: to, Type: "groupchat", Body: body}
 	if len(attachments) > 0 {
-		tags := []string{}
-		for _, a := range attachments {
-			tags = append(tags, fmt.Sprintf(xmlHTMLImage, a.ImageURL, a.ImageFilename, a.ThumbnailSize, a.ThumbnailURL))
+		m.HTML = &outHTML{Body: outHTMLBody{Paragraphs: htmlParagraphs(body, attachments)}}
+	}
+
+	return c.send(m)
+}
+
+// htmlParagraphs renders the body and the attachments as XHTML paragraphs.
+// The images come right after the text, where clients look for them.
+func htmlParagraphs(body string, attachments []Attachment) []outHTMLParagraph {
+	var images []outHTMLImage
+	var links []outHTMLParagraph
+	for _, a := range attachments {
+		switch a.Kind {
+		case KindFile, KindLink:
+			link := outHTMLLink{Href: a.ImageURL, Text: a.ImageFilename}
+			if link.Text == "" {
+				link.Text = a.ImageURL
+			}
+			if a.Kind == KindFile {
+				link.Type = a.MIMEType
+			}
+			links = append(links, outHTMLParagraph{Links: []outHTMLLink{link}})
+		default:
+			images = append(images, outHTMLImage{Src: a.ImageURL, Title: a.ImageFilename, LongDesc: a.ThumbnailSize + "##" + a.ThumbnailURL})
 		}
-		html_body := fmt.Sprintf(xmlHTMLBody, NsHTML, NsXHTML, html.EscapeString(body), strings.Join(tags, "\n"))
-		fmt.Fprintf(c.outgoing, xmlMUCMessage, from, id(), to, html.EscapeString(body), html_body)
+	}
 
-	} else {
-		fmt.Fprintf(c.outgoing, xmlMUCMessage, from, id(), to, html.EscapeString(body), "")
+	paragraphs := []outHTMLParagraph{{Text: body}}
+	if len(images) > 0 {
+		paragraphs = append(paragraphs, outHTMLParagraph{Images: images})
 	}
+	return append(paragraphs, links...)
 }
 
-func (c *Conn) Roster(from, to string) {
-	fmt.Fprintf(c.outgoing, xmlIqGet, from, to, id(), NsIqRoster)
+// MUCSubject changes the subject, i.e. the topic, of the room.
+func (c *Conn) MUCSubject(id, to, from, subject string) error {
+	return c.send(&outSubject{From: from, ID: id, To: to, Type: "groupchat", Subject: subject})
 }
 
-func (c *Conn) KeepAlive(from string) {
-	fmt.Fprintf(c.outgoing, " ")
+// RequestUploadSlot asks the upload service for a slot to upload the file
+// to (XEP-0363).
+func (c *Conn) RequestUploadSlot(id, from, to, filename string, size int64, contentType string) error {
+	return c.send(&outIQ{From: from, To: to, ID: id, Type: "get",
+		Payload: &outUploadRequest{Filename: filename, Size: size, ContentType: contentType}})
+}
+
+// Send sends a private chat message.
+func (c *Conn) Send(id, to, from, body string) error {
+	return c.send(&outMessage{From: from, ID: id, To: to, Type: "chat", Body: body})
+}
+
+func (c *Conn) Roster(id, from, to string) error {
+	return c.send(&outIQ{From: from, To: to, ID: id, Type: "get", Payload: newQuery(NsIqRoster)})
+}
+
+// Ping sends a ping iq (XEP-0199) with the given id.
+func (c *Conn) Ping(id, from, to string) error {
+	return c.send(&outIQ{From: from, To: to, ID: id, Type: "get", Payload: &outPing{}})
+}
+
+// Pong answers the ping iq with the given id.
+func (c *Conn) Pong(id, from, to string) error {
+	return c.send(&outIQ{From: from, To: to, ID: id, Type: "result"})
+}
+
+func (c *Conn) KeepAlive(from string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	_, err := io.WriteString(c.outgoing, " ")
+	return err
+}
+
+// SetSendHook makes the connection call f with the name of every stanza it
+// sends: "iq", "message", "presence" or, for stream negotiation, "other". It
+// must be called before anything is written.
+func (c *Conn) SetSendHook(f func(stanza string)) {
+	c.onSend = f
+}
+
+// send marshals a stanza and writes it to the server.
+func (c *Conn) send(v interface{}) error {
+	e := encoders.Get().(*stanzaEncoder)
+	if err := e.enc.Encode(v); err != nil {
+		// the encoder may be left in the middle of an element, drop it
+		return err
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	defer e.release()
+
+	if _, err := c.outgoing.Write(e.buf.Bytes()); err != nil {
+		return err
+	}
+	if c.onSend != nil {
+		c.onSend(stanzaName(v))
+	}
+	return nil
+}
+
+// maxPooledBuffer is the size above which encoding buffers are not kept
+// around, so one large message doesn't pin its memory for good.
+const maxPooledBuffer = 64 << 10
+
+// stanzaEncoder encodes a stanza into a buffer, so it is written to the
+// connection in one call and the encoder's own buffers are reused.
+type stanzaEncoder struct {
+	buf bytes.Buffer
+	enc *xml.Encoder
+}
+
+var encoders = sync.Pool{New: func() interface{} {
+	e := &stanzaEncoder{}
+	e.enc = xml.NewEncoder(&e.buf)
+	return e
+}}
+
+func (e *stanzaEncoder) release() {
+	if e.buf.Cap() > maxPooledBuffer {
+		return
+	}
+	e.buf.Reset()
+	encoders.Put(e)
+}
+
+// stanzaName returns the name of the stanza v.
+func stanzaName(v interface{}) string {
+	switch v.(type) {
+	case *outIQ:
+		return "iq"
+	case *outMessage, *outSubject:
+		return "message"
+	case *outPresence:
+		return "presence"
+	}
+	return "other"
+}
+
+func newQuery(ns string) *outQuery {
+	return &outQuery{XMLName: xml.Name{Space: ns, Local: "query"}}
+}
+
+// escape returns s escaped for use in an attribute value.
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
 }
 
 func (c *Conn) Close() error {
 	return c.outgoing.Close()
 }
 
-func (c *Conn) History(jid string, start time.Time, limit int) {
-	filters := []string{
-		fmt.Sprintf(xmlIqHistoryFilter, "FORM_TYPE", NsMam),
-		fmt.Sprintf(xmlIqHistoryFilter, "with", jid),
+// History requests archived messages. The query id is also used as the id of
+// the iq so the final result can be matched to the query on MAM versions that
+// deliver it in the iq response.
+func (c *Conn) History(queryId string, q HistoryQuery) error {
+	ns := q.Namespace
+	if ns == "" {
+		ns = NsMam
+	}
+
+	fields := []outFormField{
+		{"FORM_TYPE", ns},
+		{"with", q.With},
+	}
+	if !q.Start.IsZero() {
+		fields = append(fields, outFormField{"start", q.Start.UTC().Format("2006-01-02T15:04:05Z")})
+	}
+	if !q.End.IsZero() {
+		fields = append(fields, outFormField{"end", q.End.UTC().Format("2006-01-02T15:04:05Z")})
+	}
+
+	query := &outMAMQuery{
+		XMLName: xml.Name{Space: ns, Local: "query"},
+		QueryID: queryId,
+		Form:    outDataForm{Type: "submit", Fields: fields},
+		Set:     outRSMSet{Max: q.Max},
 	}
-	if !start.IsZero() {
-		filters = append(filters, fmt.Sprintf(xmlIqHistoryFilter, "start", start.Format("2006-01-02T15:04:05Z")))
+	if q.FromEnd {
+		query.Set.Before = &q.Before
+	} else {
+		query.Set.After = q.After
 	}
 
-	fmt.Fprintf(c.outgoing, xmlIqHistory, id(), strings.Join(filters, ""), limit)
+	return c.send(&outIQ{ID: queryId, Type: "set", Payload: query})
 }
 
-func (c *Conn) Session() {
-	fmt.Fprintf(c.outgoing, xmlStartSession, id(), NsSession)
+// ID returns a new random stanza id.
+func ID() string {
+	return id()
 }
 
+func (c *Conn) Session() error {
+	return c.send(&outIQ{ID: id(), Type: "set", Payload: &outSession{}})
+}
+
+// Dial connects to the XMPP server at host, which defaults to port 5222 if it
+// does not specify one.
 func Dial(host string) (*Conn, error) {
 	c := new(Conn)
-	outgoing, err := net.Dial("tcp", host+":5222")
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		c.host = name
+	} else {
+		c.host = host
+		host = net.JoinHostPort(host, "5222")
+	}
+	outgoing, err := net.Dial("tcp", host)
 
 	if err != nil {
 		return c, err
 	}
 
-	c.outgoing = outgoing
-	c.incoming = xml.NewDecoder(outgoing)
+	c.outgoing = &countingConn{Conn: outgoing, read: &c.bytesRead, written: &c.bytesWritten}
+	c.setDecoder(c.outgoing)
 
 	return c, nil
 }
 
+// BytesTransferred returns how many bytes were read from and written to the
+// network, after encryption.
+func (c *Conn) BytesTransferred() (read, written int64) {
+	return c.bytesRead.Load(), c.bytesWritten.Load()
+}
+
+// countingConn counts the bytes read from and written to a connection.
+type countingConn struct {
+	net.Conn
+	read    *atomic.Int64
+	written *atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
 func ToMap(attr []xml.Attr) map[string]string {
 	m := make(map[string]string)
 	for _, a := range attr {
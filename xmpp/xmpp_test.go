@@ -0,0 +1,115 @@
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// recordingConn is a net.Conn that keeps whatever is written to it.
+type recordingConn struct {
+	net.Conn
+	out bytes.Buffer
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+// discardConn is a net.Conn that drops whatever is written to it.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func TestSendReusesEncoders(t *testing.T) {
+	conn := &recordingConn{}
+	c := &Conn{outgoing: conn}
+
+	for _, body := range []string{"one", "two & <three>"} {
+		conn.out.Reset()
+		if err := c.MUCSend("id", "room@conf.example.com", "bot@example.com", body, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		var m struct {
+			Body string `xml:"body"`
+		}
+		if err := xml.Unmarshal(conn.out.Bytes(), &m); err != nil {
+			t.Fatalf("sent %q: %v", conn.out.String(), err)
+		}
+		if m.Body != body {
+			t.Errorf("sent body %q, want %q", m.Body, body)
+		}
+	}
+}
+
+func TestDecodeSkipsMalformedStanza(t *testing.T) {
+	c := &Conn{}
+	c.setDecoder(strings.NewReader(`<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'>` +
+		`<iq id="1" type="result"><fin xmlns="urn:xmpp:mam:2"><set><count>many</count></set></fin></iq> ` +
+		`<message from="room@conf/Bob" xml:lang="en"><body>hi</body>` +
+		`<html xmlns="http://jabber.org/protocol/xhtml-im"><body xmlns="http://www.w3.org/1999/xhtml"><p class="x">hi</p></body></html></message>`))
+
+	if _, err := c.Next(); err != nil { // stream
+		t.Fatal(err)
+	}
+	start, err := c.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var malformed *MalformedError
+	if _, err := c.IQ(&start); !errors.As(err, &malformed) {
+		t.Fatalf("IQ returned %v, want a MalformedError", err)
+	}
+
+	start, err = c.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := c.Message(&start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.From != "room@conf/Bob" || m.Body != "hi" {
+		t.Errorf("decoded from %q and body %q", m.From, m.Body)
+	}
+	if want := `<p class="x">hi</p>`; m.HTMLBody.Body != want {
+		t.Errorf("decoded HTML body %q, want %q", m.HTMLBody.Body, want)
+	}
+}
+
+func BenchmarkSend(b *testing.B) {
+	m := &outMessage{From: "bot@example.com", ID: "id", To: "room@conf.example.com", Type: "groupchat",
+		Body: "deploy of build 1234 to prod & staging <done>"}
+
+	b.Run("pooled", func(b *testing.B) {
+		c := &Conn{outgoing: discardConn{}}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := c.send(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// unpooled is how send worked before encoders were pooled.
+	b.Run("unpooled", func(b *testing.B) {
+		c := &Conn{outgoing: discardConn{}}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.writeMutex.Lock()
+			err := xml.NewEncoder(c.outgoing).Encode(m)
+			c.writeMutex.Unlock()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,336 @@
+package xmpptest
+
+import (
+	"encoding/base64"
+	"github.com/pyalex/hipchat/xmpp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auth handles SASL PLAIN authentication.
+func (s *Session) auth(stanza *Stanza) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stanza.Text))
+	parts := strings.Split(string(raw), "\x00")
+	if err != nil || stanza.Attr("mechanism") != "PLAIN" || len(parts) != 3 ||
+		!s.server.authenticate(parts[1], parts[2]) {
+		s.Send(NewStanza(xmpp.NsSASL, "failure").Add(NewStanza("", "not-authorized")))
+		return
+	}
+
+	s.mu.Lock()
+	s.user = parts[1]
+	s.mu.Unlock()
+	s.Send(NewStanza(xmpp.NsSASL, "success"))
+}
+
+func (s *Session) iq(stanza *Stanza) {
+	result := NewStanza("", "iq", "type", "result", "id", stanza.Attr("id"),
+		"from", stanza.Attr("to"), "to", s.JID())
+
+	switch {
+	case stanza.ChildNS(xmpp.NsBind, "bind") != nil:
+		resource := newID()
+		if r := stanza.ChildNS(xmpp.NsBind, "bind").Child("resource"); r != nil && r.Text != "" {
+			resource = r.Text
+		}
+		s.mu.Lock()
+		s.resource = resource
+		s.mu.Unlock()
+
+		result.Add(NewStanza(xmpp.NsBind, "bind").Add(NewStanza("", "jid").SetText(s.JID())))
+	case stanza.ChildNS(xmpp.NsSession, "session") != nil,
+		stanza.ChildNS(xmpp.NsPing, "ping") != nil:
+	case stanza.ChildNS(xmpp.NsDisco, "query") != nil:
+		result.Add(s.server.roomItems())
+	case stanza.ChildNS(xmpp.NsIqRoster, "query") != nil:
+		result.Add(s.server.rosterItems())
+	case stanza.ChildNS(xmpp.NsDiscoInfo, "query") != nil:
+		query := NewStanza(xmpp.NsDiscoInfo, "query")
+		for _, ns := range []string{xmpp.NsMam, xmpp.NsMam2, xmpp.NsPing, xmpp.NsMuc} {
+			query.Add(NewStanza("", "feature", "var", ns))
+		}
+		result.Add(query)
+	case stanza.ChildNS(xmpp.NsMam, "query") != nil:
+		s.mam(stanza, stanza.ChildNS(xmpp.NsMam, "query"))
+		return
+	case stanza.ChildNS(xmpp.NsMam2, "query") != nil:
+		s.mam(stanza, stanza.ChildNS(xmpp.NsMam2, "query"))
+		return
+	default:
+		if stanza.Attr("type") == "get" || stanza.Attr("type") == "set" {
+			s.Send(errorReply(stanza, s.JID(), "cancel", "service-unavailable"))
+		}
+		return
+	}
+	s.Send(result)
+}
+
+func (srv *Server) roomItems() *Stanza {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	query := NewStanza(xmpp.NsDisco, "query")
+	for _, r := range srv.rooms {
+		query.Add(NewStanza("", "item", "jid", r.JID, "name", r.Name).Add(
+			NewStanza("", "topic").SetText(r.Topic),
+			NewStanza("", "owner").SetText(r.Owner)))
+	}
+	return query
+}
+
+func (srv *Server) rosterItems() *Stanza {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	query := NewStanza(xmpp.NsIqRoster, "query")
+	for _, u := range srv.users {
+		query.Add(NewStanza("", "item", "jid", u.JID(), "name", u.FullName, "mention_name", u.MentionName))
+	}
+	return query
+}
+
+// presence handles joining and leaving rooms.
+func (s *Session) presence(stanza *Stanza) {
+	to := stanza.Attr("to")
+	i := strings.Index(to, "/")
+	if i < 0 {
+		return
+	}
+	roomJid, nick := to[:i], to[i+1:]
+
+	srv := s.server
+	srv.mu.Lock()
+	r, ok := srv.rooms[roomJid]
+	if !ok {
+		srv.mu.Unlock()
+		s.Send(errorReply(stanza, s.JID(), "cancel", "item-not-found"))
+		return
+	}
+
+	leaving := stanza.Attr("type") == "unavailable"
+	if leaving {
+		delete(r.occupants, s)
+	} else {
+		r.occupants[s] = nick
+	}
+
+	occupants := make(map[*Session]string, len(r.occupants))
+	for o, n := range r.occupants {
+		occupants[o] = n
+	}
+
+	var history []ArchivedMessage
+	if h := stanza.ChildNS(xmpp.NsMuc, "x"); h != nil && !leaving {
+		if h := h.Child("history"); h != nil {
+			history = srv.archive[roomJid]
+			if n, err := strconv.Atoi(h.Attr("maxstanzas")); err == nil && n < len(history) {
+				history = history[len(history)-n:]
+			}
+		}
+	}
+	srv.mu.Unlock()
+
+	typ := ""
+	if leaving {
+		typ = "unavailable"
+	}
+
+	// the others learn about the newcomer, the newcomer about the others
+	for o := range occupants {
+		if o != s {
+			o.Send(NewStanza("", "presence", "from", to, "to", o.JID(), "type", typ))
+			s.Send(NewStanza("", "presence", "from", roomJid+"/"+occupants[o], "to", s.JID()))
+		}
+	}
+	s.Send(NewStanza("", "presence", "id", stanza.Attr("id"), "from", to, "to", s.JID(), "type", typ))
+
+	for _, m := range history {
+		s.Send(NewStanza("", "message", "id", m.ID, "from", m.From, "to", s.JID(), "type", "groupchat").Add(
+			NewStanza("", "body").SetText(m.Body),
+			NewStanza("urn:xmpp:delay", "delay", "stamp", stamp(m.Stamp))))
+	}
+}
+
+// message relays messages to rooms and users and archives them.
+func (s *Session) message(stanza *Stanza) {
+	body := stanza.Child("body")
+	if body == nil {
+		return
+	}
+
+	id := stanza.Attr("id")
+	if id == "" {
+		id = newID()
+	}
+	to := stanza.Attr("to")
+
+	srv := s.server
+	srv.mu.Lock()
+
+	var recipients []*Session
+	var from string
+	if r, ok := srv.rooms[to]; ok {
+		nick, joined := r.occupants[s]
+		if !joined {
+			srv.mu.Unlock()
+			s.Send(errorReply(stanza, s.JID(), "modify", "not-acceptable"))
+			return
+		}
+		from = to + "/" + nick
+		for o := range r.occupants {
+			recipients = append(recipients, o)
+		}
+		srv.archive[to] = append(srv.archive[to], ArchivedMessage{ID: id, From: from, To: to, Body: body.Text, Stamp: time.Now()})
+	} else if strings.HasSuffix(to, "@"+ConfDomain) {
+		srv.mu.Unlock()
+		s.Send(errorReply(stanza, s.JID(), "cancel", "item-not-found"))
+		return
+	} else {
+		user := to
+		if i := strings.Index(user, "@"); i >= 0 {
+			user = user[:i]
+		}
+		from = s.JID()
+		for o := range srv.sessions {
+			if o.User() == user {
+				recipients = append(recipients, o)
+			}
+		}
+
+		m := ArchivedMessage{ID: id, From: from, To: to, Body: body.Text, Stamp: time.Now()}
+		bareFrom := s.User() + "@" + Domain
+		bareTo := user + "@" + Domain
+		srv.archive[bareFrom] = append(srv.archive[bareFrom], m)
+		if bareTo != bareFrom {
+			srv.archive[bareTo] = append(srv.archive[bareTo], m)
+		}
+	}
+	srv.mu.Unlock()
+
+	for _, o := range recipients {
+		m := NewStanza("", "message", "id", id, "from", from, "to", o.JID(), "type", stanza.Attr("type"))
+		m.Add(body)
+		if html := stanza.ChildNS(xmpp.NsHTML, "html"); html != nil {
+			m.Add(html)
+		}
+		o.Send(m)
+	}
+}
+
+// mam answers an archive query with the page of archived messages it selects
+// followed by the fin element.
+func (s *Session) mam(stanza, query *Stanza) {
+	ns := query.XMLName.Space
+	queryId := query.Attr("queryid")
+
+	var with string
+	var start, end time.Time
+	if form := query.ChildNS("jabber:x:data", "x"); form != nil {
+		for _, f := range form.Children {
+			value := ""
+			if v := f.Child("value"); v != nil {
+				value = v.Text
+			}
+			switch f.Attr("var") {
+			case "with":
+				with = value
+			case "start":
+				start, _ = time.Parse(time.RFC3339, value)
+			case "end":
+				end, _ = time.Parse(time.RFC3339, value)
+			}
+		}
+	}
+
+	s.server.mu.Lock()
+	var matching []ArchivedMessage
+	for _, m := range s.server.archive[with] {
+		if (start.IsZero() || !m.Stamp.Before(start)) && (end.IsZero() || !m.Stamp.After(end)) {
+			matching = append(matching, m)
+		}
+	}
+	s.server.mu.Unlock()
+
+	page, complete := paginate(matching, query.ChildNS("http://jabber.org/protocol/rsm", "set"))
+	for _, m := range page {
+		s.Send(NewStanza("", "message", "to", s.JID(), "from", stanza.Attr("to")).Add(
+			NewStanza(ns, "result", "queryid", queryId, "id", m.ID).Add(
+				NewStanza(xmpp.NsMamForward, "forwarded").Add(
+					NewStanza("urn:xmpp:delay", "delay", "stamp", stamp(m.Stamp)),
+					NewStanza(xmpp.NsJabberClient, "message", "id", m.ID, "from", m.From, "to", m.To).Add(
+						NewStanza("", "body").SetText(m.Body))))))
+	}
+
+	set := NewStanza("http://jabber.org/protocol/rsm", "set")
+	if len(page) > 0 {
+		set.Add(NewStanza("", "first").SetText(page[0].ID), NewStanza("", "last").SetText(page[len(page)-1].ID))
+	}
+	set.Add(NewStanza("", "count").SetText(strconv.Itoa(len(matching))))
+
+	s.Send(NewStanza("", "iq", "type", "result", "id", stanza.Attr("id"), "to", s.JID()).Add(
+		NewStanza(ns, "fin", "queryid", queryId, "complete", strconv.FormatBool(complete)).Add(set)))
+}
+
+// paginate returns the page of messages selected by the result set
+// management element set and whether it is the last page in its direction.
+func paginate(messages []ArchivedMessage, set *Stanza) ([]ArchivedMessage, bool) {
+	max := len(messages)
+	from, to := 0, len(messages)
+	fromEnd := false
+
+	if set != nil {
+		if m := set.Child("max"); m != nil {
+			if n, err := strconv.Atoi(m.Text); err == nil && n >= 0 {
+				max = n
+			}
+		}
+		if a := set.Child("after"); a != nil && a.Text != "" {
+			from = index(messages, a.Text) + 1
+		}
+		if b := set.Child("before"); b != nil {
+			fromEnd = true
+			if b.Text != "" {
+				to = index(messages, b.Text)
+				if to < 0 {
+					to = 0
+				}
+			}
+		}
+	}
+	if from > to {
+		from = to
+	}
+
+	if fromEnd {
+		if to-from > max {
+			return messages[to-max : to], false
+		}
+		return messages[from:to], true
+	}
+	if to-from > max {
+		return messages[from : from+max], false
+	}
+	return messages[from:to], true
+}
+
+// index returns the position of the message with the given id, or -1.
+func index(messages []ArchivedMessage, id string) int {
+	for i, m := range messages {
+		if m.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// errorReply returns the error answering stanza.
+func errorReply(stanza *Stanza, to, typ, condition string) *Stanza {
+	return NewStanza("", stanza.XMLName.Local, "type", "error", "id", stanza.Attr("id"),
+		"from", stanza.Attr("to"), "to", to).Add(
+		NewStanza("", "error", "type", typ).Add(NewStanza(xmpp.NsStanzas, condition)))
+}
+
+func stamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,369 @@
+// Package xmpptest provides an in-process XMPP server speaking enough of the
+// HipChat dialect to test clients end to end without real credentials: SASL
+// PLAIN, resource binding, the room and user lists, MUC presence and message
+// echo, pings and message archives (MAM) filled from fixtures.
+//
+// A test starts a server, adds its fixtures and points the client at it:
+//
+//	srv, err := xmpptest.NewServer()
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer srv.Close()
+//
+//	srv.AddUser(xmpptest.User{Name: "bot", Password: "secret"})
+//	srv.AddRoom(xmpptest.Room{JID: "1_test@conf.hipchat.com", Name: "Test"})
+//
+//	config := hipchat.Config{XMPPHost: srv.Host(), Port: srv.Port()}
+//	client, err := hipchat.NewClientWithConfig("bot", "secret", "bot", config)
+//
+// Handlers registered with Handle see every stanza before the server does and
+// can answer it themselves to script any other behavior.
+package xmpptest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/pyalex/hipchat/xmpp"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Domain is the domain of the JIDs handed out by the server.
+	Domain = "chat.hipchat.com"
+	// ConfDomain is the domain of room JIDs.
+	ConfDomain = "conf.hipchat.com"
+)
+
+// A User is an account the server accepts.
+type User struct {
+	Name        string // the local part of the JID and the SASL user name
+	Password    string
+	FullName    string
+	MentionName string
+}
+
+// JID returns the bare JID of the user.
+func (u User) JID() string {
+	return u.Name + "@" + Domain
+}
+
+// A Room is a room clients can list and join.
+type Room struct {
+	JID   string
+	Name  string
+	Owner string
+	Topic string
+}
+
+// An ArchivedMessage is a message returned by archive queries. The server
+// archives the messages it relays as well.
+type ArchivedMessage struct {
+	ID    string
+	From  string
+	To    string
+	Body  string
+	Stamp time.Time
+}
+
+// A Handler is called for every stanza a client sends. It reports whether it
+// took care of the stanza; otherwise the server handles it as usual.
+type Handler func(s *Session, stanza *Stanza) bool
+
+// A Server is an XMPP server listening on a local port.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	users    map[string]User
+	rooms    map[string]*room
+	archive  map[string][]ArchivedMessage
+	sessions map[*Session]bool
+	handlers []Handler
+}
+
+type room struct {
+	Room
+	occupants map[*Session]string // nick by session
+}
+
+// NewServer starts a server on a random port of the loopback interface.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		listener: l,
+		users:    make(map[string]User),
+		rooms:    make(map[string]*room),
+		archive:  make(map[string][]ArchivedMessage),
+		sessions: make(map[*Session]bool),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the host and port the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Host returns the IP address the server listens on.
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port the server listens on.
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops the server and closes all sessions.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for session := range s.sessions {
+		session.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// AddUser adds an account. As long as there are no accounts, any
+// credentials are accepted.
+func (s *Server) AddUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.Name] = u
+}
+
+// AddRoom adds a room. Joining a room that was not added fails with
+// item-not-found.
+func (s *Server) AddRoom(r Room) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rooms[r.JID] = &room{Room: r, occupants: make(map[*Session]string)}
+}
+
+// AddArchive appends messages to the archive of the conversation with the
+// room or bare user JID with. Messages must be added oldest first. Messages
+// without an id get a random one.
+func (s *Server) AddArchive(with string, messages ...ArchivedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range messages {
+		if m.ID == "" {
+			m.ID = newID()
+		}
+		s.archive[with] = append(s.archive[with], m)
+	}
+}
+
+// Handle registers a handler called for every stanza before the server
+// handles it. Handlers are called in the order they were registered.
+func (s *Server) Handle(h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, h)
+}
+
+// Sessions returns the sessions of the connected clients.
+func (s *Server) Sessions() []*Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(s.sessions))
+	for session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		session := &Session{server: s, conn: conn}
+		s.mu.Lock()
+		s.sessions[session] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			session.serve()
+			s.remove(session)
+		}()
+	}
+}
+
+// remove forgets a session whose connection ended.
+func (s *Server) remove(session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, session)
+	for _, r := range s.rooms {
+		delete(r.occupants, session)
+	}
+}
+
+// authenticate reports whether the credentials belong to an account.
+func (s *Server) authenticate(user, password string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.users) == 0 {
+		return true
+	}
+	u, ok := s.users[user]
+	return ok && u.Password == password
+}
+
+// A Session is the connection of one client.
+type Session struct {
+	server *Server
+	conn   net.Conn
+
+	writeMutex sync.Mutex
+
+	mu       sync.Mutex
+	user     string
+	resource string
+}
+
+// JID returns the full JID bound by the client, or "" before it is bound.
+func (s *Session) JID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.resource == "" {
+		return ""
+	}
+	return s.user + "@" + Domain + "/" + s.resource
+}
+
+// User returns the name the client authenticated with.
+func (s *Session) User() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.user
+}
+
+// Send writes a stanza to the client.
+func (s *Session) Send(stanza *Stanza) error {
+	inheritNamespace(stanza)
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return xml.NewEncoder(s.conn).Encode(stanza)
+}
+
+// SendRaw writes raw XML to the client, e.g. a stream error.
+func (s *Session) SendRaw(raw string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_, err := io.WriteString(s.conn, raw)
+	return err
+}
+
+// Close closes the connection to the client.
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Session) serve() {
+	defer s.conn.Close()
+
+	dec := xml.NewDecoder(s.conn)
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return
+		}
+
+		start, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Space == xmpp.NsStream && start.Name.Local == "stream" {
+			s.openStream()
+			continue
+		}
+
+		stanza := new(Stanza)
+		if err := dec.DecodeElement(stanza, &start); err != nil {
+			return
+		}
+		stripNamespaces(stanza)
+
+		s.server.mu.Lock()
+		handlers := s.server.handlers
+		s.server.mu.Unlock()
+
+		handled := false
+		for _, h := range handlers {
+			if h(s, stanza) {
+				handled = true
+				break
+			}
+		}
+		if !handled {
+			s.handle(stanza)
+		}
+	}
+}
+
+// openStream answers a stream header with the features available at this
+// point of the negotiation.
+func (s *Session) openStream() {
+	s.SendRaw(fmt.Sprintf("<?xml version='1.0'?><stream:stream from='%s' id='%s' version='1.0' xmlns='%s' xmlns:stream='%s'>",
+		Domain, newID(), xmpp.NsJabberClient, xmpp.NsStream))
+
+	features := NewStanza(xmpp.NsStream, "features")
+	if s.User() == "" {
+		features.Add(NewStanza(xmpp.NsSASL, "mechanisms").Add(
+			NewStanza("", "mechanism").SetText("PLAIN")))
+	} else {
+		features.Add(NewStanza(xmpp.NsBind, "bind"), NewStanza(xmpp.NsSession, "session"))
+	}
+	s.Send(features)
+}
+
+func (s *Session) handle(stanza *Stanza) {
+	switch stanza.XMLName.Local {
+	case "auth":
+		s.auth(stanza)
+	case "iq":
+		s.iq(stanza)
+	case "presence":
+		s.presence(stanza)
+	case "message":
+		s.message(stanza)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
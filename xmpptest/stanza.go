@@ -0,0 +1,95 @@
+package xmpptest
+
+import "encoding/xml"
+
+// A Stanza is a generic XML element as received from or sent to a client.
+type Stanza struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []*Stanza  `xml:",any"`
+}
+
+// NewStanza returns an element in the given namespace with the attributes
+// given as name, value pairs. An empty namespace is inherited from the
+// parent.
+func NewStanza(space, local string, attrs ...string) *Stanza {
+	s := &Stanza{XMLName: xml.Name{Space: space, Local: local}}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] != "" {
+			s.Attrs = append(s.Attrs, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]})
+		}
+	}
+	return s
+}
+
+// Attr returns the value of the attribute name, or "" if it is not set.
+func (s *Stanza) Attr(name string) string {
+	for _, a := range s.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Child returns the first child element with the given name, or nil.
+func (s *Stanza) Child(local string) *Stanza {
+	for _, c := range s.Children {
+		if c.XMLName.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+// ChildNS returns the first child element with the given namespace and
+// name, or nil.
+func (s *Stanza) ChildNS(space, local string) *Stanza {
+	for _, c := range s.Children {
+		if c.XMLName.Space == space && c.XMLName.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+// Add appends children to s and returns s.
+func (s *Stanza) Add(children ...*Stanza) *Stanza {
+	s.Children = append(s.Children, children...)
+	return s
+}
+
+// SetText sets the character data of s and returns s.
+func (s *Stanza) SetText(text string) *Stanza {
+	s.Text = text
+	return s
+}
+
+// stripNamespaces removes the namespace declarations decoded along with the
+// attributes, which would otherwise be written twice when the stanza is sent
+// again.
+func stripNamespaces(s *Stanza) {
+	attrs := s.Attrs[:0]
+	for _, a := range s.Attrs {
+		if a.Name.Local != "xmlns" && a.Name.Space != "xmlns" {
+			attrs = append(attrs, a)
+		}
+	}
+	s.Attrs = attrs
+
+	for _, c := range s.Children {
+		stripNamespaces(c)
+	}
+}
+
+// inheritNamespace puts children without a namespace into the namespace of
+// their parent. encoding/xml would otherwise reset it with xmlns="".
+func inheritNamespace(s *Stanza) {
+	for _, c := range s.Children {
+		if c.XMLName.Space == "" {
+			c.XMLName.Space = s.XMLName.Space
+		}
+		inheritNamespace(c)
+	}
+}